@@ -0,0 +1,322 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/git"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// methodChangedStacks is the request returning the stacks changed in the git
+// branch, which is not part of the protocol.
+const methodChangedStacks = "terramate/changedStacks"
+
+// changedStacksParams are the parameters of the terramate/changedStacks
+// request.
+type changedStacksParams struct {
+	// Directory is the URI of the directory whose changed stacks are listed.
+	// All the changed stacks of the project of the workspace folders are
+	// listed if it is empty, which requires the folders to be in a single
+	// project.
+	Directory lsp.URI `json:"directory,omitempty"`
+}
+
+// changedStacksResult is the result of the terramate/changedStacks request.
+type changedStacksResult struct {
+	// BaseRef is the git reference the changes are compared to.
+	BaseRef string `json:"baseRef"`
+
+	// Stacks are the changed stacks, sorted by their project path.
+	Stacks []changedStack `json:"stacks"`
+}
+
+// changedStack is a stack changed compared to the git base reference.
+type changedStack struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func (s *Server) handleChangedStacks(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params changedStacksParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	var dir string
+	if params.Directory != "" {
+		dir, _ = commandDir([]interface{}{string(params.Directory)})
+		if dir == "" {
+			return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams,
+				fmt.Sprintf("invalid directory URI %q", params.Directory)))
+		}
+	} else {
+		var err error
+		dir, err = s.workspaceProject()
+		if err != nil {
+			return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams, err.Error()))
+		}
+	}
+
+	p, err := s.loadProject(dir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load project")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+	changed, baseRef, err := s.changedStacks(p)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to list the changed stacks")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+
+	prjdir := project.NewPath("/")
+	if params.Directory != "" {
+		prjdir = project.PrjAbsPath(p.rootdir, dir)
+	}
+	result := changedStacksResult{BaseRef: baseRef, Stacks: []changedStack{}}
+	for path, reason := range changed {
+		if isParentOrSelf(prjdir, path) {
+			result.Stacks = append(result.Stacks, changedStack{
+				Path:   path.String(),
+				Reason: reason,
+			})
+		}
+	}
+	sort.Slice(result.Stacks, func(i, j int) bool {
+		return result.Stacks[i].Path < result.Stacks[j].Path
+	})
+	return reply(ctx, result, nil)
+}
+
+// defaults of the git configuration of a Terramate project.
+const (
+	defaultRemote        = "origin"
+	defaultBranch        = "main"
+	defaultBranchBaseRef = "HEAD^"
+)
+
+// gitConfig returns the git configuration of the project, with the defaults
+// applied.
+func (p *projectState) gitConfig() hcl.GitConfig {
+	cfg := hcl.GitConfig{
+		DefaultRemote:        defaultRemote,
+		DefaultBranch:        defaultBranch,
+		DefaultBranchBaseRef: defaultBranchBaseRef,
+	}
+
+	node := p.root.Tree().Node
+	if node.Terramate == nil || node.Terramate.Config == nil || node.Terramate.Config.Git == nil {
+		return cfg
+	}
+	gitcfg := node.Terramate.Config.Git
+	if gitcfg.DefaultRemote != "" {
+		cfg.DefaultRemote = gitcfg.DefaultRemote
+	}
+	if gitcfg.DefaultBranch != "" {
+		cfg.DefaultBranch = gitcfg.DefaultBranch
+	}
+	if gitcfg.DefaultBranchBaseRef != "" {
+		cfg.DefaultBranchBaseRef = gitcfg.DefaultBranchBaseRef
+	}
+	return cfg
+}
+
+// gitBaseRef returns the git reference the changes are compared to, which is
+// the default branch of the default remote. When the default branch is checked
+// out and it is up to date with the remote, its previous commit is used
+// instead, as `terramate list --changed` does. Only the local state of the
+// repository is used, so the remote is never fetched.
+func (p *projectState) gitBaseRef(g *git.Git) string {
+	cfg := p.gitConfig()
+	remoteRef := cfg.DefaultRemote + "/" + cfg.DefaultBranch
+
+	remoteCommit, err := g.RevParse(remoteRef)
+	if err != nil {
+		// repositories without the remote compare to the local branch.
+		remoteRef = cfg.DefaultBranch
+		remoteCommit, _ = g.RevParse(remoteRef)
+	}
+
+	branch, err := g.CurrentBranch()
+	if err != nil || branch != cfg.DefaultBranch {
+		return remoteRef
+	}
+	head, err := g.RevParse("HEAD")
+	if err != nil || head != remoteCommit {
+		return remoteRef
+	}
+	if _, err := g.RevParse(cfg.DefaultBranchBaseRef); err != nil {
+		return remoteRef
+	}
+	return cfg.DefaultBranchBaseRef
+}
+
+// changedStacks returns the stacks changed compared to the git base reference
+// of the project, which is also returned, with the reason of the change. Besides
+// the changes found by `terramate list --changed`, a stack is changed when the
+// files of its directory import a changed file. It fails if the project is not
+// inside a git repository.
+func (s *Server) changedStacks(p *projectState) (map[project.Path]string, string, error) {
+	g, err := git.WithConfig(git.Config{WorkingDir: p.rootdir})
+	if err != nil {
+		return nil, "", err
+	}
+	if !g.IsRepository() {
+		return nil, "", errors.E("%s is not inside a git repository", p.rootdir)
+	}
+
+	baseRef := p.gitBaseRef(g)
+	report, err := terramate.NewManager(p.root, baseRef).ListChanged()
+	if err != nil {
+		return nil, "", err
+	}
+
+	changed := map[project.Path]string{}
+	for _, entry := range report.Stacks {
+		changed[entry.Stack.Path()] = entry.Reason
+	}
+
+	changedFiles, err := changedFiles(g, baseRef)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(changedFiles) == 0 {
+		return changed, baseRef, nil
+	}
+
+	imports := map[string][]project.Path{}
+	s.index(p.rootdir).forEach(func(fname string, symbols *fileSymbols) {
+		for _, ref := range symbols.pathRefs {
+			if ref.block == "import" {
+				imports[fname] = append(imports[fname], ref.target)
+			}
+		}
+	})
+	for _, st := range p.stacks {
+		if _, ok := changed[st.Path()]; ok {
+			continue
+		}
+		if file, ok := importsChangedFile(p.rootdir, st.HostPath(), imports, changedFiles); ok {
+			changed[st.Path()] = fmt.Sprintf("stack changed because imported file %q changed", file)
+		}
+	}
+	return changed, baseRef, nil
+}
+
+// changedFiles returns the project paths of the files changed between the git
+// base reference and HEAD.
+func changedFiles(g *git.Git, baseRef string) (map[project.Path]bool, error) {
+	base, err := g.RevParse(baseRef)
+	if err != nil {
+		return nil, errors.E(err, "getting revision %q", baseRef)
+	}
+	head, err := g.RevParse("HEAD")
+	if err != nil {
+		return nil, errors.E(err, "getting HEAD revision")
+	}
+
+	files := map[project.Path]bool{}
+	if base == head {
+		return files, nil
+	}
+	names, err := g.DiffNames(base, head)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		files[project.NewPath("/"+name)] = true
+	}
+	return files, nil
+}
+
+// importsChangedFile tells if the files of the host directory dir, or of its
+// parent directories up to rootdir, import, directly or through other imported
+// files, one of the changed files, which is returned. The parent directories
+// are included as the stack at dir inherits their configuration. The imports
+// map the host path of the files to the project paths they import.
+func importsChangedFile(
+	rootdir string,
+	dir string,
+	imports map[string][]project.Path,
+	changedFiles map[project.Path]bool,
+) (project.Path, bool) {
+	cfgdir := project.PrjAbsPath(rootdir, dir)
+	var pending []string
+	for fname := range imports {
+		if isParentOrSelf(project.PrjAbsPath(rootdir, filepath.Dir(fname)), cfgdir) {
+			pending = append(pending, fname)
+		}
+	}
+	sort.Strings(pending)
+
+	visited := map[string]bool{}
+	for len(pending) > 0 {
+		fname := pending[0]
+		pending = pending[1:]
+		if visited[fname] {
+			continue
+		}
+		visited[fname] = true
+		for _, target := range imports[fname] {
+			if changedFiles[target] {
+				return target, true
+			}
+			pending = append(pending, filepath.Join(rootdir, filepath.FromSlash(target.String())))
+		}
+	}
+	return "", false
+}
+
+// changedStackProblem returns the problem of the stack block, at the host
+// directory dir, if the stack is changed in the git branch. It only informs
+// which stacks the branch changes.
+func (s *Server) changedStackProblem(p *projectState, dir string, block *hclsyntax.Block) (problem, bool) {
+	st, ok := p.stackAt(dir)
+	if !ok {
+		return problem{}, false
+	}
+	changed, baseRef, err := s.changedStacks(p)
+	if err != nil {
+		return problem{}, false
+	}
+	reason, ok := changed[st.Path()]
+	if !ok {
+		return problem{}, false
+	}
+	return problem{
+		code:     codeChangedStack,
+		rng:      lspRange(block.TypeRange),
+		severity: lsp.DiagnosticSeverityInformation,
+		message:  fmt.Sprintf("changed from %s: %s", baseRef, reason),
+	}, true
+}
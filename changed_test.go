@@ -0,0 +1,104 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestChangedStacks(t *testing.T) {
+	f := test.Setup(t,
+		"f:modules/common.tm:globals {\n  env = \"prod\"\n}\n",
+		"f:modules/nested.tm:import {\n  source = \"/shared/deep.tm\"\n}\n",
+		"f:shared/deep.tm:globals {\n  region = \"eu\"\n}\n",
+		"f:config/watched.txt:a",
+		"f:stacks/changed/stack.tm:stack {}\n",
+		"f:stacks/watch/stack.tm:stack {\n  watch = [\"/config/watched.txt\"]\n}\n",
+		"f:stacks/import/stack.tm:stack {}\n\nimport {\n  source = \"/modules/common.tm\"\n}\n",
+		"f:deep/stack.tm:stack {}\n\nimport {\n  source = \"../modules/nested.tm\"\n}\n",
+		"f:inherit/imports.tm:import {\n  source = \"/shared/deep.tm\"\n}\n",
+		"f:inherit/child/stack.tm:stack {}\n",
+		"f:unchanged/stack.tm:stack {}\n",
+	)
+	git := f.Sandbox.Git()
+	git.CommitAll("add stacks")
+	git.Push("main")
+
+	git.CheckoutNew("change")
+	f.Sandbox.BuildTree([]string{
+		"f:modules/common.tm:globals {\n  env = \"dev\"\n}\n",
+		"f:shared/deep.tm:globals {\n  region = \"us\"\n}\n",
+		"f:config/watched.txt:b",
+		"f:stacks/changed/main.tf:# changed\n",
+	})
+	git.CommitAll("change stacks")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	want := test.ChangedStacks{
+		BaseRef: "origin/main",
+		Stacks: []test.ChangedStack{
+			{
+				Path:   "/deep",
+				Reason: "stack changed because imported file \"/shared/deep.tm\" changed",
+			},
+			{
+				Path:   "/inherit/child",
+				Reason: "stack changed because imported file \"/shared/deep.tm\" changed",
+			},
+			{
+				Path:   "/stacks/changed",
+				Reason: "stack has unmerged changes",
+			},
+			{
+				Path:   "/stacks/import",
+				Reason: "stack changed because imported file \"/modules/common.tm\" changed",
+			},
+			{
+				Path:   "/stacks/watch",
+				Reason: "stack changed because watched file \"/config/watched.txt\" changed",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, f.Editor.ChangedStacks("")); diff != "" {
+		t.Fatalf("changed stacks mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	want.Stacks = want.Stacks[2:]
+	if diff := cmp.Diff(want, f.Editor.ChangedStacks("stacks")); diff != "" {
+		t.Fatalf("changed stacks mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	lenses := f.Editor.CodeLenses("stacks/import/stack.tm")
+	if got := lenses[len(lenses)-1].Command.Title; got != "changed from origin/main" {
+		t.Fatalf("got lens %q, want the stack changed", got)
+	}
+
+	f.Editor.ChangeConfiguration(map[string]interface{}{
+		"terramate": map[string]interface{}{
+			"experimental": []string{"changed-stacks-diagnostics"},
+		},
+	})
+	f.Editor.Open("stacks/import/stack.tm")
+	diags := f.Editor.Diagnostics("stacks/import/stack.tm")
+	if len(diags) != 1 || diags[0].Severity != lsp.DiagnosticSeverityInformation ||
+		diags[0].Message != "changed from origin/main: "+want.Stacks[1].Reason {
+		t.Fatalf("want the stack changed, got %+v", diags)
+	}
+	drainRequests(f.Editor)
+}
@@ -0,0 +1,202 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// workspaceEdit is a workspace edit supporting file operations in the document
+// changes, which the protocol package lacks.
+type workspaceEdit struct {
+	Changes         map[lsp.URI][]lsp.TextEdit `json:"changes,omitempty"`
+	DocumentChanges []interface{}              `json:"documentChanges,omitempty"`
+}
+
+// codeAction is a code action with a workspaceEdit.
+type codeAction struct {
+	Title       string             `json:"title"`
+	Kind        lsp.CodeActionKind `json:"kind,omitempty"`
+	Diagnostics []lsp.Diagnostic   `json:"diagnostics,omitempty"`
+	IsPreferred bool               `json:"isPreferred,omitempty"`
+	Edit        *workspaceEdit     `json:"edit,omitempty"`
+}
+
+// fileEdits are the text edits and file operations of a workspace edit, by
+// file name.
+type fileEdits struct {
+	created map[string]bool
+	deleted map[string]bool
+	edits   map[string][]lsp.TextEdit
+}
+
+func newFileEdits() *fileEdits {
+	return &fileEdits{
+		created: map[string]bool{},
+		deleted: map[string]bool{},
+		edits:   map[string][]lsp.TextEdit{},
+	}
+}
+
+// create adds the creation of the file.
+func (e *fileEdits) create(fname string) {
+	e.created[fname] = true
+}
+
+// delete adds the deletion of the file.
+func (e *fileEdits) delete(fname string) {
+	e.deleted[fname] = true
+}
+
+// add adds the text edit of the file.
+func (e *fileEdits) add(fname string, edit lsp.TextEdit) {
+	e.edits[fname] = append(e.edits[fname], edit)
+}
+
+// empty tells if there are no edits.
+func (e *fileEdits) empty() bool {
+	return len(e.created) == 0 && len(e.deleted) == 0 && len(e.edits) == 0
+}
+
+// workspaceEdit returns the workspace edit applying the edits. The edits are
+// sent as document changes if any file is created or deleted, as the editors
+// ignore the changes when there are document changes. The files are created
+// before and deleted after the text edits.
+func (e *fileEdits) workspaceEdit() *workspaceEdit {
+	files := make([]string, 0, len(e.edits))
+	for fname, edits := range e.edits {
+		sort.SliceStable(edits, func(i, j int) bool {
+			return positionBefore(edits[i].Range.Start, edits[j].Range.Start)
+		})
+		files = append(files, fname)
+	}
+	sort.Strings(files)
+
+	if len(e.created) == 0 && len(e.deleted) == 0 {
+		changes := map[lsp.URI][]lsp.TextEdit{}
+		for _, fname := range files {
+			changes[fileURI(fname)] = e.edits[fname]
+		}
+		return &workspaceEdit{Changes: changes}
+	}
+
+	changes := []interface{}{}
+	for _, fname := range sortedKeys(e.created) {
+		changes = append(changes, lsp.CreateFile{
+			Kind: lsp.CreateResourceOperation,
+			URI:  fileURI(fname),
+		})
+	}
+	for _, fname := range files {
+		changes = append(changes, lsp.TextDocumentEdit{
+			TextDocument: lsp.OptionalVersionedTextDocumentIdentifier{
+				TextDocumentIdentifier: lsp.TextDocumentIdentifier{
+					URI: fileURI(fname),
+				},
+			},
+			Edits: e.edits[fname],
+		})
+	}
+	for _, fname := range sortedKeys(e.deleted) {
+		changes = append(changes, lsp.DeleteFile{
+			Kind: lsp.DeleteResourceOperation,
+			URI:  fileURI(fname),
+		})
+	}
+	return &workspaceEdit{DocumentChanges: changes}
+}
+
+// sortedKeys returns the sorted keys of the set.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *Server) handleCodeAction(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.CodeActionParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	actions := []codeAction{}
+	if wantsKind(params.Context.Only, lsp.QuickFix) {
+		actions = append(actions, s.quickFixes(fname, params.Context.Diagnostics)...)
+	}
+	actions = append(actions, s.refactorings(fname, params.Range, params.Context.Only)...)
+	return reply(ctx, actions, nil)
+}
+
+// quickFixes returns the fixes of the diagnostics of the file. Only the
+// diagnostics with a code of a problem found by the language server can be
+// fixed.
+func (s *Server) quickFixes(fname string, diags []lsp.Diagnostic) []codeAction {
+	var actions []codeAction
+	var problems []problem
+	for _, diag := range diags {
+		code, ok := diag.Code.(string)
+		if !ok {
+			continue
+		}
+		if problems == nil {
+			problems = s.problems(s.newCheck(), fname)
+		}
+		for _, p := range problems {
+			if p.code != code || p.rng != diag.Range || p.fix == nil {
+				continue
+			}
+			actions = append(actions, codeAction{
+				Title:       p.fix.title,
+				Kind:        lsp.QuickFix,
+				Diagnostics: []lsp.Diagnostic{diag},
+				IsPreferred: true,
+				Edit:        p.fix.edit,
+			})
+			break
+		}
+	}
+	return actions
+}
+
+// wantsKind tells if the code actions of the kind are requested. An empty only
+// list requests all kinds.
+func wantsKind(only []lsp.CodeActionKind, kind lsp.CodeActionKind) bool {
+	if len(only) == 0 {
+		return true
+	}
+	for _, want := range only {
+		if want == kind || strings.HasPrefix(string(kind), string(want)+".") {
+			return true
+		}
+	}
+	return false
+}
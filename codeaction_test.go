@@ -0,0 +1,202 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestQuickFixes(t *testing.T) {
+	type testcase struct {
+		name   string
+		layout []string
+		file   string
+		code   string
+		title  string
+		want   string
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "add missing stack block",
+			layout: []string{
+				"f:app/main.tf:",
+				"f:app/config.tm:globals {\n  a = 1\n}\n",
+			},
+			file:  "app/config.tm",
+			code:  "missing-stack",
+			title: "Add a stack block",
+			want:  "stack {\n  name = \"app\"\n  id   = \"<uuid>\"\n}\n\nglobals {\n  a = 1\n}\n",
+		},
+		{
+			name:   "generate id for invalid stack id",
+			layout: []string{"f:stack/stack.tm:stack {\n  id = \"not valid\"\n}\n"},
+			file:   "stack/stack.tm",
+			code:   "invalid-stack-id",
+			title:  "Generate a new stack ID",
+			want:   "stack {\n  id = \"<uuid>\"\n}\n",
+		},
+		{
+			name: "generate id for duplicated stack id",
+			layout: []string{
+				"f:a/stack.tm:stack {\n  id = \"same\"\n}\n",
+				"f:b/stack.tm:stack {\n  id = \"same\"\n}\n",
+			},
+			file:  "b/stack.tm",
+			code:  "duplicated-stack-id",
+			title: "Generate a new stack ID",
+			want:  "stack {\n  id = \"<uuid>\"\n}\n",
+		},
+		{
+			name: "remove duplicated global",
+			layout: []string{
+				"f:globals.tm:globals {\n  a = 1\n}\n",
+				"f:other.tm:globals {\n  b = 2\n  a = 3\n}\n",
+			},
+			file:  "other.tm",
+			code:  "duplicated-global",
+			title: "Remove the duplicated global.a",
+			want:  "globals {\n  b = 2\n}\n",
+		},
+		{
+			name: "use project path in after",
+			layout: []string{
+				"f:stacks/a/stack.tm:stack {\n  after = [\"../b\"]\n}\n",
+				"f:stacks/b/stack.tm:stack {}\n",
+			},
+			file:  "stacks/a/stack.tm",
+			code:  "relative-stack-path",
+			title: "Use the project path /stacks/b",
+			want:  "stack {\n  after = [\"/stacks/b\"]\n}\n",
+		},
+		{
+			name:   "fix unknown function",
+			layout: []string{"f:globals.tm:globals {\n  a = tm_uper(\"x\")\n}\n"},
+			file:   "globals.tm",
+			code:   "unknown-function",
+			title:  "Change to tm_upper",
+			want:   "globals {\n  a = tm_upper(\"x\")\n}\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+			f.Editor.Open(tc.file)
+
+			action := quickFix(t, f, tc.file, tc.code)
+			if action.Title != tc.title {
+				t.Fatalf("title got %q != want %q", action.Title, tc.title)
+			}
+			if action.Kind != lsp.QuickFix || action.Edit == nil {
+				t.Fatalf("invalid quick fix: %+v", action)
+			}
+
+			abspath := filepath.Join(f.Sandbox.RootDir(), tc.file)
+			content, err := os.ReadFile(abspath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := applyEdits(string(content), action.Edit.Changes[uri.File(abspath)])
+			got = uuidRegex.ReplaceAllString(got, "<uuid>")
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("fixed content mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQuickFixCreatesMissingImport(t *testing.T) {
+	f := test.Setup(t, "f:dir/file.tm:import {\n  source = \"/modules/common.tm\"\n}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+	f.Editor.Open("dir/file.tm")
+
+	action := quickFix(t, f, "dir/file.tm", "missing-import")
+	want := []map[string]interface{}{
+		{
+			"kind": "create",
+			"uri":  string(uri.File(filepath.Join(f.Sandbox.RootDir(), "modules/common.tm"))),
+			"options": map[string]interface{}{
+				"ignoreIfExists": true,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, action.Edit.DocumentChanges); diff != "" {
+		t.Fatalf("document changes mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestQuickFixesIgnoreUnknownDiagnostics(t *testing.T) {
+	f := test.Setup(t, "f:globals.tm:globals {\n  a = 1\n}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	actions := f.Editor.CodeActions("globals.tm", lsp.Range{}, []lsp.Diagnostic{
+		{Message: "no code"},
+		{Message: "unknown code", Code: "unknown"},
+		{Message: "wrong range", Code: "duplicated-global"},
+	}, lsp.QuickFix)
+	if len(actions) != 0 {
+		t.Fatalf("want no code actions, got %+v", actions)
+	}
+}
+
+var uuidRegex = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+
+// quickFix returns the single quick fix of the diagnostic with the code
+// published for the file.
+func quickFix(t *testing.T, f test.Fixture, file string, code string) test.CodeAction {
+	t.Helper()
+	for _, diag := range f.Editor.Diagnostics(file) {
+		if diag.Code != code {
+			continue
+		}
+		actions := f.Editor.CodeActions(file, diag.Range, []lsp.Diagnostic{diag}, lsp.QuickFix)
+		if len(actions) != 1 {
+			t.Fatalf("want a single quick fix, got %+v", actions)
+		}
+		return actions[0]
+	}
+	t.Fatalf("no diagnostic with code %q", code)
+	return test.CodeAction{}
+}
+
+// applyEdits returns the content with the edits applied.
+func applyEdits(content string, edits []lsp.TextEdit) string {
+	offset := func(pos lsp.Position) int {
+		lines := strings.SplitAfter(content, "\n")
+		off := 0
+		for i := uint32(0); i < pos.Line && int(i) < len(lines); i++ {
+			off += len(lines[i])
+		}
+		return off + int(pos.Character)
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		return offset(edits[i].Range.Start) > offset(edits[j].Range.Start)
+	})
+	for _, edit := range edits {
+		start, end := offset(edit.Range.Start), offset(edit.Range.End)
+		content = content[:start] + edit.NewText + content[end:]
+	}
+	return content
+}
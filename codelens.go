@@ -0,0 +1,126 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleCodeLens(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.CodeLensParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, s.codeLenses(fname, content), nil)
+}
+
+// codeLenses returns the lenses of the stack block of the file, which show the
+// position of the stack in the run order, its generated files and if it is
+// changed in the git branch. The run order lens shows the dependencies of the
+// stack when clicked.
+func (s *Server) codeLenses(fname string, content []byte) []lsp.CodeLens {
+	lenses := []lsp.CodeLens{}
+
+	var rng lsp.Range
+	found := false
+	for _, block := range parseBody(fname, content).Blocks {
+		if block.Type == "stack" {
+			rng = lspRange(block.TypeRange)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return lenses
+	}
+
+	dir := filepath.Dir(fname)
+	p, err := s.loadProject(dir)
+	if err != nil {
+		return lenses
+	}
+	st, ok := p.stackAt(dir)
+	if !ok {
+		return lenses
+	}
+
+	lens := func(title, command string) {
+		cmd := &lsp.Command{Title: title}
+		if command != "" {
+			cmd.Command = command
+			cmd.Arguments = []interface{}{string(fileURI(dir))}
+		}
+		lenses = append(lenses, lsp.CodeLens{Range: rng, Command: cmd})
+	}
+
+	if d, err := p.runGraph(); err == nil {
+		order, reason, err := p.runOrder(d)
+		if err != nil {
+			lens("run order cycle: "+reason, commandShowDependencies)
+		}
+		for i, path := range order {
+			if path == st.Path() {
+				lens(fmt.Sprintf("runs after %s, before %d", countStacks(i), len(order)-i-1),
+					commandShowDependencies)
+			}
+		}
+	}
+
+	if files, ok := p.generatedFiles()[st.Path()]; ok {
+		if len(files) == 1 {
+			lens("1 generated file", "")
+		} else {
+			lens(fmt.Sprintf("%d generated files", len(files)), "")
+		}
+		lens("regenerate", commandGenerate)
+	}
+
+	if changed, baseRef, err := s.changedStacks(p); err == nil {
+		if _, ok := changed[st.Path()]; ok {
+			lens("changed from "+baseRef, "")
+		} else {
+			lens("unchanged from "+baseRef, "")
+		}
+	}
+	return lenses
+}
+
+// countStacks returns the number of stacks in a human readable form.
+func countStacks(n int) string {
+	if n == 1 {
+		return "1 stack"
+	}
+	return fmt.Sprintf("%d stacks", n)
+}
@@ -0,0 +1,166 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestCodeLens(t *testing.T) {
+	type testcase struct {
+		name   string
+		layout []string
+		file   string
+		want   []string
+	}
+
+	generate := "\n\ngenerate_hcl \"a.tf\" {\n  content {\n    a = 1\n  }\n}\n" +
+		"\ngenerate_file \"b.txt\" {\n  condition = false\n  content   = \"b\"\n}\n"
+
+	for _, tc := range []testcase{
+		{
+			name: "run order and generated files",
+			layout: []string{
+				"f:stacks/a/stack.tm:stack {}" + generate,
+				"f:stacks/b/stack.tm:stack {\n  after = [\"/stacks/a\"]\n}\n",
+				"f:stacks/c/stack.tm:stack {\n  before = [\"../a\"]\n}\n",
+			},
+			file: "stacks/a/stack.tm",
+			want: []string{
+				"runs after 1 stack, before 1",
+				"1 generated file",
+				"regenerate",
+				"unchanged from origin/main",
+			},
+		},
+		{
+			name: "parent stacks run before",
+			layout: []string{
+				"f:parent/stack.tm:stack {}\n",
+				"f:parent/a/stack.tm:stack {}\n",
+				"f:parent/b/stack.tm:stack {}\n",
+			},
+			file: "parent/b/stack.tm",
+			want: []string{
+				"runs after 2 stacks, before 0",
+				"0 generated files",
+				"regenerate",
+				"unchanged from origin/main",
+			},
+		},
+		{
+			name: "run order cycle",
+			layout: []string{
+				"f:a/stack.tm:stack {\n  after = [\"/b\"]\n}\n",
+				"f:b/stack.tm:stack {\n  after = [\"/a\"]\n}\n",
+			},
+			file: "a/stack.tm",
+			want: []string{
+				"run order cycle: /a -> /b -> /a",
+				"0 generated files",
+				"regenerate",
+				"unchanged from origin/main",
+			},
+		},
+		{
+			name: "file without stack",
+			layout: []string{
+				"f:stack/stack.tm:stack {}\n",
+				"f:stack/globals.tm:globals {}\n",
+			},
+			file: "stack/globals.tm",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			var titles []string
+			for _, lens := range f.Editor.CodeLenses(tc.file) {
+				if lens.Range.Start.Line != 0 {
+					t.Fatalf("lens not at the stack block: %+v", lens)
+				}
+				titles = append(titles, lens.Command.Title)
+			}
+			if diff := cmp.Diff(tc.want, titles); diff != "" {
+				t.Fatalf("lenses mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCodeLensCommands(t *testing.T) {
+	f := test.Setup(t, "f:stack/stack.tm:stack {}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	dir := string(uri.File(filepath.Join(f.Sandbox.RootDir(), "stack")))
+	want := []*lsp.Command{
+		{
+			Title:     "runs after 0 stacks, before 0",
+			Command:   "terramate.showDependencies",
+			Arguments: []interface{}{dir},
+		},
+		{
+			Title: "0 generated files",
+		},
+		{
+			Title:     "regenerate",
+			Command:   "terramate.generate",
+			Arguments: []interface{}{dir},
+		},
+		{
+			Title: "unchanged from origin/main",
+		},
+	}
+	var got []*lsp.Command
+	for _, lens := range f.Editor.CodeLenses("stack/stack.tm") {
+		got = append(got, lens.Command)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("commands mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestCodeLensChangedStack(t *testing.T) {
+	f := test.Setup(t)
+	git := f.Sandbox.Git()
+	git.CheckoutNew("change")
+	f.Sandbox.BuildTree([]string{
+		"f:changed/stack.tm:stack {}\n",
+		"f:unchanged/stack.tm:stack {}\n",
+	})
+	git.Add("changed")
+	git.Commit("add changed stack")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	for file, want := range map[string]string{
+		"changed/stack.tm":   "changed from origin/main",
+		"unchanged/stack.tm": "unchanged from origin/main",
+	} {
+		lenses := f.Editor.CodeLenses(file)
+		if len(lenses) == 0 {
+			t.Fatalf("no lenses for %s", file)
+		}
+		if got := lenses[len(lenses)-1].Command.Title; got != want {
+			t.Fatalf("%s: got lens %q != want %q", file, got, want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+
+	"github.com/mineiros-io/terramate/project"
+	"github.com/mineiros-io/terramate/run/dag"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// commands executed by the server with workspace/executeCommand.
+const (
+	// commandShowDependencies returns the locations of the stacks which must
+	// run before the stack. Its argument is the URI of the stack directory.
+	commandShowDependencies = "terramate.showDependencies"
+
+	// commandGenerate updates the generated code inside a directory and
+	// returns the summary of the changed files. Its optional argument is the
+	// URI of the directory, the whole project is generated without it when
+	// the workspace folders are in a single project.
+	commandGenerate = "terramate.generate"
+
+	// commandCreateStack creates a stack file in a directory and returns its
+	// URI. Its arguments are the URI of the directory and, optionally, an
+	// object with the name, description, after and before of the stack.
+	commandCreateStack = "terramate.createStack"
+)
+
+// commands returns the commands supported by the server.
+func commands() []string {
+	return []string{
+		commandShowDependencies,
+		commandGenerate,
+		commandCreateStack,
+	}
+}
+
+// applyWorkspaceEditParams are the parameters of the workspace/applyEdit
+// request, which the protocol package only supports with text edits.
+type applyWorkspaceEditParams struct {
+	Label string         `json:"label,omitempty"`
+	Edit  *workspaceEdit `json:"edit"`
+}
+
+func (s *Server) handleExecuteCommand(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.ExecuteCommandParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	log = log.With().Str("command", params.Command).Logger()
+	switch params.Command {
+	case commandShowDependencies, commandGenerate, commandCreateStack:
+	default:
+		log.Error().Msg("unknown command")
+		return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams,
+			fmt.Sprintf("unknown command %q", params.Command)))
+	}
+
+	dir, ok := commandDir(params.Arguments)
+	if !ok && params.Command == commandGenerate && len(params.Arguments) == 0 {
+		// without a directory the code of the whole project is generated.
+		var err error
+		dir, err = s.workspaceProject()
+		if err != nil {
+			log.Error().Err(err).Msg("no project to generate")
+			return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams, err.Error()))
+		}
+		ok = true
+	}
+	if !ok {
+		log.Error().Interface("arguments", params.Arguments).Msg("invalid command arguments")
+		return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams,
+			fmt.Sprintf("command %q requires a directory URI argument", params.Command)))
+	}
+
+	if params.Command == commandCreateStack {
+		return s.createStack(ctx, reply, dir, params.Arguments, log)
+	}
+
+	p, err := s.loadProject(dir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load project")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+
+	switch params.Command {
+	case commandShowDependencies:
+		return reply(ctx, s.stackDependencies(p, dir), nil)
+	default:
+		prjdir := project.NewPath("/")
+		if len(params.Arguments) > 0 {
+			prjdir = project.PrjAbsPath(p.rootdir, dir)
+		}
+		go func() {
+			summary, err := s.generate(ctx, p, prjdir, params.WorkDoneToken)
+			if err != nil {
+				log.Info().Err(err).Msg("code generation failed")
+			}
+			if err := reply(ctx, summary, err); err != nil {
+				log.Error().Err(err).Msg("failed to reply")
+			}
+		}()
+		return nil
+	}
+}
+
+// generateSummary is the result of the terramate.generate command, with the
+// project paths of the generated files changed by the command.
+type generateSummary struct {
+	Created []string `json:"created"`
+	Changed []string `json:"changed"`
+	Deleted []string `json:"deleted"`
+
+	// Error reports the stacks which failed to generate code and have no
+	// changes.
+	Error string `json:"error,omitempty"`
+}
+
+// String returns the summary in a human readable form.
+func (summary *generateSummary) String() string {
+	if len(summary.Created)+len(summary.Changed)+len(summary.Deleted) == 0 {
+		return "generated code is up to date"
+	}
+	return fmt.Sprintf("%d files created, %d changed, %d deleted",
+		len(summary.Created), len(summary.Changed), len(summary.Deleted))
+}
+
+// generate applies the changes of the generated code inside the project
+// directory dir and returns their summary. The progress is reported with the
+// token, if any. It must not be called from a request handler, as it waits for
+// the editor to apply the changes.
+func (s *Server) generate(
+	ctx context.Context,
+	p *projectState,
+	dir project.Path,
+	token *lsp.ProgressToken,
+) (*generateSummary, error) {
+	prog := s.startProgress(ctx, token, "terramate generate")
+	edits := newFileEdits()
+	genErr := s.generateEdits(p, dir, edits, prog)
+	if genErr != nil && edits.empty() {
+		prog.end("code generation failed")
+		return nil, jsonrpc2.NewError(codeRequestFailed, genErr.Error())
+	}
+
+	summary := &generateSummary{
+		Created: []string{},
+		Changed: []string{},
+		Deleted: []string{},
+	}
+	if genErr != nil {
+		summary.Error = genErr.Error()
+	}
+	for target := range edits.created {
+		summary.Created = append(summary.Created, project.PrjAbsPath(p.rootdir, target).String())
+	}
+	for target := range edits.edits {
+		if !edits.created[target] {
+			summary.Changed = append(summary.Changed, project.PrjAbsPath(p.rootdir, target).String())
+		}
+	}
+	for target := range edits.deleted {
+		summary.Deleted = append(summary.Deleted, project.PrjAbsPath(p.rootdir, target).String())
+	}
+	sort.Strings(summary.Created)
+	sort.Strings(summary.Changed)
+	sort.Strings(summary.Deleted)
+
+	if !edits.empty() {
+		prog.report("applying the changes", 100)
+		if err := s.applyEdit(ctx, "terramate generate", edits.workspaceEdit()); err != nil {
+			prog.end("the changes were not applied")
+			return nil, err
+		}
+	}
+	prog.end(summary.String())
+	return summary, nil
+}
+
+// createStack creates the stack at the host directory dir with the options of
+// the command arguments and replies with the URI of the stack file once the
+// editor applies the edit.
+func (s *Server) createStack(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	dir string,
+	args []interface{},
+	log zerolog.Logger,
+) error {
+	opts, err := createStackOptionsFrom(args)
+	if err != nil {
+		log.Error().Err(err).Msg("invalid stack options")
+		return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams,
+			fmt.Sprintf("invalid stack options: %v", err)))
+	}
+
+	edits := newFileEdits()
+	target, err := s.createStackEdits(dir, opts, edits)
+	if err != nil {
+		log.Info().Err(err).Msg("failed to create stack")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+
+	go func() {
+		var result interface{}
+		err := s.applyEdit(ctx, "terramate create", edits.workspaceEdit())
+		if err == nil {
+			result = fileURI(target)
+		}
+		if err := reply(ctx, result, err); err != nil {
+			log.Error().Err(err).Msg("failed to reply")
+		}
+	}()
+	return nil
+}
+
+// commandDir returns the host directory of the URI given as the first command
+// argument.
+func commandDir(args []interface{}) (string, bool) {
+	if len(args) == 0 {
+		return "", false
+	}
+	arg, ok := args[0].(string)
+	if !ok {
+		return "", false
+	}
+	u, err := url.Parse(arg)
+	if err != nil || u.Scheme != uri.FileScheme || u.Path == "" {
+		return "", false
+	}
+	return uri.URI(arg).Filename(), true
+}
+
+// stackDependencies returns the locations of the stacks which must run before
+// the stack at the host directory dir, sorted by path.
+func (s *Server) stackDependencies(p *projectState, dir string) []lsp.Location {
+	locations := []lsp.Location{}
+	d, err := p.runGraph()
+	if err != nil {
+		return locations
+	}
+
+	var deps []string
+	for _, id := range d.AncestorsOf(dag.ID(project.PrjAbsPath(p.rootdir, dir))) {
+		deps = append(deps, string(id))
+	}
+	sort.Strings(deps)
+	for _, dep := range deps {
+		if loc, ok := s.stackLocation(p.hostPath(project.Path(dep))); ok {
+			locations = append(locations, loc)
+		}
+	}
+	return locations
+}
+
+// applyEdit asks the editor to apply the workspace edit. It must not be called
+// from a request handler, as the response of the editor is only read after the
+// handler returns.
+func (s *Server) applyEdit(ctx context.Context, label string, edit *workspaceEdit) error {
+	var result lsp.ApplyWorkspaceEditResponse
+	_, err := s.conn.Call(ctx, lsp.MethodWorkspaceApplyEdit, applyWorkspaceEditParams{
+		Label: label,
+		Edit:  edit,
+	}, &result)
+	if err != nil {
+		return jsonrpc2.NewError(codeRequestFailed, err.Error())
+	}
+	if !result.Applied {
+		return jsonrpc2.NewError(codeRequestFailed,
+			fmt.Sprintf("edit not applied by the editor: %s", result.FailureReason))
+	}
+	return nil
+}
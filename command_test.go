@@ -0,0 +1,354 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/uuid"
+	"github.com/mineiros-io/terramate-ls/test"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+const genHeader = "// TERRAMATE: GENERATED AUTOMATICALLY DO NOT EDIT\n\n"
+
+func TestShowDependencies(t *testing.T) {
+	f := test.Setup(t,
+		"f:stacks/stack.tm:stack {}\n",
+		"f:stacks/a/stack.tm:stack {\n  after = [\"/other\"]\n}\n",
+		"f:stacks/b/stack.tm:stack {\n  before = [\"../a\"]\n}\n",
+		"f:other/stack.tm:stack {}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	var got []lsp.Location
+	err := f.Editor.ExecuteCommand("terramate.showDependencies",
+		[]interface{}{dirURI(f, "stacks/a")}, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var want []lsp.Location
+	for _, file := range []string{"other/stack.tm", "stacks/stack.tm", "stacks/b/stack.tm"} {
+		want = append(want, lsp.Location{
+			URI: uri.File(filepath.Join(f.Sandbox.RootDir(), file)),
+			Range: lsp.Range{
+				End: lsp.Position{Character: 5},
+			},
+		})
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("dependencies mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestGenerateCommand(t *testing.T) {
+	f := test.Setup(t,
+		"f:stack/stack.tm:stack {}\n\n"+
+			"generate_hcl \"a.tf\" {\n  content {\n    a = 1\n  }\n}\n\n"+
+			"generate_hcl \"b.tf\" {\n  content {\n    b = 2\n  }\n}\n",
+		"f:stack/b.tf:"+genHeader+"b = 1\n",
+		"f:stack/old.tf:"+genHeader+"old = 1\n",
+		"f:stack/manual.tf:manual = 1\n",
+		"f:other/stack.tm:stack {}\n\n"+
+			"generate_hcl \"other.tf\" {\n  content {\n    a = 1\n  }\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	var summary map[string]interface{}
+	err := f.Editor.ExecuteCommand("terramate.generate",
+		[]interface{}{dirURI(f, "stack")}, &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSummary := map[string]interface{}{
+		"created": []interface{}{"/stack/a.tf"},
+		"changed": []interface{}{"/stack/b.tf"},
+		"deleted": []interface{}{"/stack/old.tf"},
+	}
+	if diff := cmp.Diff(wantSummary, summary); diff != "" {
+		t.Fatalf("summary mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	edit := appliedEdit(t, f)
+	deleted := []string{}
+	for _, change := range edit.DocumentChanges {
+		if change["kind"] == "delete" {
+			deleted = append(deleted, change["uri"].(string))
+		}
+	}
+	wantDeleted := []string{dirURI(f, "stack/old.tf")}
+	if diff := cmp.Diff(wantDeleted, deleted); diff != "" {
+		t.Fatalf("deleted files mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	want := map[string]string{
+		"stack/a.tf": genHeader + "a = 1\n",
+		"stack/b.tf": genHeader + "b = 2\n",
+	}
+	if diff := cmp.Diff(want, applyWorkspaceEdit(t, f.Sandbox.RootDir(), edit)); diff != "" {
+		t.Fatalf("generated files mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestGenerateCommandProject(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {}\n",
+		"f:b/stack.tm:stack {}\n",
+		"f:stacks.tm:generate_hcl \"stack.tf\" {\n  content {\n    a = 1\n  }\n}\n\n"+
+			"generate_file \"/out/stacks.txt\" {\n  context = root\n"+
+			"  content = tm_join(\",\", terramate.stacks.list)\n}\n\n"+
+			"generate_file \"/out/old.txt\" {\n  context   = root\n"+
+			"  condition = false\n  content   = \"old\"\n}\n",
+		"f:out/old.txt:old",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	var summary map[string]interface{}
+	err := f.Editor.ExecuteCommandWithProgress("terramate.generate", nil, "generate", &summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSummary := map[string]interface{}{
+		"created": []interface{}{"/a/stack.tf", "/b/stack.tf", "/out/stacks.txt"},
+		"changed": []interface{}{},
+		"deleted": []interface{}{"/out/old.txt"},
+	}
+	if diff := cmp.Diff(wantSummary, summary); diff != "" {
+		t.Fatalf("summary mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	var edit *test.WorkspaceEdit
+	var kinds []string
+	for len(kinds) == 0 || kinds[len(kinds)-1] != "end" {
+		r := <-f.Editor.Requests
+		switch r.Method() {
+		case lsp.MethodWorkspaceApplyEdit:
+			var params struct {
+				Edit test.WorkspaceEdit `json:"edit"`
+			}
+			if err := json.Unmarshal(r.Params(), &params); err != nil {
+				t.Fatal(err)
+			}
+			edit = &params.Edit
+		case lsp.MethodProgress:
+			var params struct {
+				Token string `json:"token"`
+				Value struct {
+					Kind string `json:"kind"`
+				} `json:"value"`
+			}
+			if err := json.Unmarshal(r.Params(), &params); err != nil {
+				t.Fatal(err)
+			}
+			if params.Token != "generate" {
+				t.Fatalf("got progress token %q", params.Token)
+			}
+			kinds = append(kinds, params.Value.Kind)
+		default:
+			t.Fatalf("unexpected request %s", r.Method())
+		}
+	}
+	wantKinds := []string{"begin", "report", "report", "report", "end"}
+	if diff := cmp.Diff(wantKinds, kinds); diff != "" {
+		t.Fatalf("progress mismatch, want(-) got(+):\n%s", diff)
+	}
+	if edit == nil {
+		t.Fatal("no workspace/applyEdit request")
+	}
+
+	want := map[string]string{
+		"a/stack.tf":     genHeader + "a = 1\n",
+		"b/stack.tf":     genHeader + "a = 1\n",
+		"out/stacks.txt": "/a,/b",
+	}
+	if diff := cmp.Diff(want, applyWorkspaceEdit(t, f.Sandbox.RootDir(), edit)); diff != "" {
+		t.Fatalf("generated files mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestGenerateCommandRefusesManualCode(t *testing.T) {
+	f := test.Setup(t,
+		"f:stack/stack.tm:stack {}\n\n"+
+			"generate_hcl \"a.tf\" {\n  content {\n    a = 1\n  }\n}\n",
+		"f:stack/a.tf:a = 0\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	err := f.Editor.ExecuteCommand("terramate.generate",
+		[]interface{}{dirURI(f, "stack")}, nil)
+	if err == nil {
+		t.Fatal("want an error when overwriting manual code")
+	}
+}
+
+func TestGenerateCommandRefusesManualFile(t *testing.T) {
+	f := test.Setup(t,
+		"f:stack/stack.tm:stack {}\n\n"+
+			"generate_file \"a.txt\" {\n  content = \"generated\"\n}\n",
+		"f:stack/a.txt:written by hand",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	err := f.Editor.ExecuteCommand("terramate.generate",
+		[]interface{}{dirURI(f, "stack")}, nil)
+	if err == nil {
+		t.Fatal("want an error when overwriting a file without header")
+	}
+	// no workspace/applyEdit is sent, which the fixture checks at cleanup.
+}
+
+func TestGenerateCommandUpToDate(t *testing.T) {
+	f := test.Setup(t,
+		"f:stack/stack.tm:stack {}\n\n"+
+			"generate_hcl \"a.tf\" {\n  content {\n    a = 1\n  }\n}\n\n"+
+			"generate_file \"b.txt\" {\n  content = \"b\"\n}\n",
+		"f:stack/a.tf:"+genHeader+"a = 1\n",
+		"f:stack/b.txt:b",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	err := f.Editor.ExecuteCommand("terramate.generate",
+		[]interface{}{dirURI(f, "stack")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// no workspace/applyEdit is sent, which the fixture checks at cleanup.
+}
+
+func TestCreateStack(t *testing.T) {
+	f := test.Setup(t, "f:stacks/a/stack.tm:stack {}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	var got string
+	err := f.Editor.ExecuteCommand("terramate.createStack", []interface{}{
+		dirURI(f, "stacks/new/b"),
+		map[string]interface{}{
+			"after": []string{"/stacks/a"},
+		},
+	}, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := dirURI(f, "stacks/new/b/stack.tm"); got != want {
+		t.Fatalf("got stack file %q != want %q", got, want)
+	}
+
+	edit := appliedEdit(t, f)
+	if len(edit.DocumentChanges) == 0 || edit.DocumentChanges[0]["kind"] != "create" {
+		t.Fatalf("want the stack file to be created first, got %+v", edit.DocumentChanges)
+	}
+	files := applyWorkspaceEdit(t, f.Sandbox.RootDir(), edit)
+	content := files["stacks/new/b/stack.tm"]
+	id := regexp.MustCompile(`id +=  *"([^"]+)"`).FindStringSubmatch(content)
+	if id == nil {
+		t.Fatalf("stack without id:\n%s", content)
+	}
+	if _, err := uuid.Parse(id[1]); err != nil {
+		t.Fatalf("invalid stack id %q: %v", id[1], err)
+	}
+
+	want := "stack {\n" +
+		"  name        = \"b\"\n" +
+		"  description = \"b\"\n" +
+		"  after       = [\"/stacks/a\"]\n" +
+		"  id          = \"" + id[1] + "\"\n" +
+		"}\n"
+	if diff := cmp.Diff(map[string]string{"stacks/new/b/stack.tm": want}, files); diff != "" {
+		t.Fatalf("stack file mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestCreateStackFailures(t *testing.T) {
+	f := test.Setup(t,
+		"f:stack/stack.tm:stack {}\n",
+		"f:other/config.tm:stack {}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	for _, args := range [][]interface{}{
+		{dirURI(f, "stack")},
+		{dirURI(f, "other")},
+		{dirURI(f, ".hidden")},
+		{string(uri.File(filepath.Dir(f.Sandbox.RootDir())))},
+		{dirURI(f, "new"), "name"},
+	} {
+		if err := f.Editor.ExecuteCommand("terramate.createStack", args, nil); err == nil {
+			t.Fatalf("want error creating stack with %v", args)
+		}
+	}
+
+	// the stack schema of the pinned Terramate version has no tags.
+	err := f.Editor.ExecuteCommand("terramate.createStack", []interface{}{
+		dirURI(f, "new"),
+		map[string]interface{}{"tags": []string{"a"}},
+	}, nil)
+	var rpcErr *jsonrpc2.Error
+	if !errors.As(err, &rpcErr) || rpcErr.Code != jsonrpc2.InvalidParams ||
+		!strings.Contains(rpcErr.Message, "tags are not supported") {
+		t.Fatalf("want invalid params error for tags, got %v", err)
+	}
+	// no workspace/applyEdit is sent, which the fixture checks at cleanup.
+}
+
+func TestExecuteCommandInvalidParams(t *testing.T) {
+	f := test.Setup(t, "f:stack/stack.tm:stack {}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	for _, tc := range []struct {
+		command string
+		args    []interface{}
+	}{
+		{command: "terramate.unknown", args: []interface{}{dirURI(f, "stack")}},
+		{command: "terramate.showDependencies"},
+		{command: "terramate.generate", args: []interface{}{1}},
+		{command: "terramate.showDependencies", args: []interface{}{"https://example.com/stack"}},
+	} {
+		if err := f.Editor.ExecuteCommand(tc.command, tc.args, nil); err == nil {
+			t.Fatalf("want error for command %q with %v", tc.command, tc.args)
+		}
+	}
+}
+
+// dirURI returns the URI of the path relative to the sandbox root.
+func dirURI(f test.Fixture, path string) string {
+	return string(uri.File(filepath.Join(f.Sandbox.RootDir(), path)))
+}
+
+// appliedEdit returns the edit of the workspace/applyEdit request received by
+// the editor.
+func appliedEdit(t *testing.T, f test.Fixture) *test.WorkspaceEdit {
+	t.Helper()
+
+	r := <-f.Editor.Requests
+	if r.Method() != lsp.MethodWorkspaceApplyEdit {
+		t.Fatalf("got request %s, want %s", r.Method(), lsp.MethodWorkspaceApplyEdit)
+	}
+	var params struct {
+		Edit test.WorkspaceEdit `json:"edit"`
+	}
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		t.Fatal(err)
+	}
+	return &params.Edit
+}
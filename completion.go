@@ -0,0 +1,259 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/config"
+	"github.com/rs/zerolog/log"
+	"github.com/zclconf/go-cty/cty"
+	lsp "go.lsp.dev/protocol"
+)
+
+// completions returns the completion items for the given position of the file.
+// The completion context is computed from the HCL tokens because the file being
+// edited is very likely to have syntax errors.
+func (s *Server) completions(fname string, pos lsp.Position) ([]lsp.CompletionItem, error) {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	offset := offsetFor(content, pos)
+	tokens, _ := hclsyntax.LexConfig(content, fname, hhcl.InitialPos)
+
+	prefix, ok := importSourceAt(content, tokens, offset)
+	if !ok {
+		return nil, nil
+	}
+
+	rootdir := s.projectRoot(filepath.Dir(fname))
+	start := offset - (len(prefix) - strings.LastIndex(prefix, "/") - 1)
+	editRange := lsp.Range{
+		Start: positionFor(content, start),
+		End:   pos,
+	}
+	return s.importSourceCompletions(rootdir, fname, prefix, editRange), nil
+}
+
+// importSourceAt returns the (possibly incomplete) source path being typed at
+// offset if the offset is inside the string of an import.source attribute.
+func importSourceAt(content []byte, tokens hclsyntax.Tokens, offset int) (string, bool) {
+	var blocks []string
+	var stmt hclsyntax.Tokens
+
+	for i, tok := range tokens {
+		if tok.Range.Start.Byte >= offset {
+			break
+		}
+
+		switch tok.Type {
+		case hclsyntax.TokenOBrace:
+			blocktype := ""
+			if len(stmt) > 0 && stmt[0].Type == hclsyntax.TokenIdent &&
+				!hasToken(stmt, hclsyntax.TokenEqual) {
+				blocktype = string(stmt[0].Bytes)
+			}
+			blocks = append(blocks, blocktype)
+			stmt = nil
+		case hclsyntax.TokenCBrace:
+			if len(blocks) > 0 {
+				blocks = blocks[:len(blocks)-1]
+			}
+			stmt = nil
+		case hclsyntax.TokenNewline:
+			stmt = nil
+		case hclsyntax.TokenOQuote:
+			if tok.Range.End.Byte > offset || !isImportSource(blocks, stmt) {
+				stmt = append(stmt, tok)
+				continue
+			}
+			if i+1 < len(tokens) {
+				next := tokens[i+1]
+				if next.Type == hclsyntax.TokenQuotedLit && next.Range.End.Byte < offset {
+					return "", false
+				}
+				if next.Type != hclsyntax.TokenQuotedLit && next.Range.Start.Byte < offset {
+					return "", false
+				}
+			}
+			return string(content[tok.Range.End.Byte:offset]), true
+		default:
+			stmt = append(stmt, tok)
+		}
+	}
+	return "", false
+}
+
+func isImportSource(blocks []string, stmt hclsyntax.Tokens) bool {
+	return len(blocks) == 1 && blocks[0] == "import" &&
+		len(stmt) == 2 &&
+		stmt[0].Type == hclsyntax.TokenIdent && string(stmt[0].Bytes) == "source" &&
+		stmt[1].Type == hclsyntax.TokenEqual
+}
+
+func hasToken(tokens hclsyntax.Tokens, typ hclsyntax.TokenType) bool {
+	for _, tok := range tokens {
+		if tok.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// importSourceCompletions lists the directories and Terramate files that can
+// complete the import source prefix. Relative paths are resolved from the
+// directory of fname and absolute paths from the project rootdir.
+// Files that cannot be imported by fname are not suggested, which includes
+// fname itself, files from the same directory tree and files that would
+// create an import cycle.
+func (s *Server) importSourceCompletions(
+	rootdir string,
+	fname string,
+	prefix string,
+	editRange lsp.Range,
+) []lsp.CompletionItem {
+	basedir := filepath.Dir(fname)
+	prefixDir := prefix[:strings.LastIndex(prefix, "/")+1]
+
+	var dir string
+	if path.IsAbs(prefix) {
+		dir = filepath.Join(rootdir, filepath.FromSlash(prefixDir))
+	} else {
+		dir = filepath.Join(basedir, filepath.FromSlash(prefixDir))
+	}
+
+	if dir != rootdir && !strings.HasPrefix(dir, rootdir+string(filepath.Separator)) {
+		log.Trace().Str("dir", dir).Msg("ignoring completion outside of project root")
+		return nil
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Debug().Err(err).Msg("listing import source completions")
+		return nil
+	}
+
+	items := []lsp.CompletionItem{}
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if config.Skip(name) {
+			continue
+		}
+
+		if dirEntry.IsDir() {
+			items = append(items, lsp.CompletionItem{
+				Label: name + "/",
+				Kind:  lsp.CompletionItemKindFolder,
+				TextEdit: &lsp.TextEdit{
+					Range:   editRange,
+					NewText: name + "/",
+				},
+			})
+			continue
+		}
+
+		if !isTerramateFile(name) {
+			continue
+		}
+
+		filename := filepath.Join(dir, name)
+		if filename == fname || basedir == dir || strings.HasPrefix(basedir, dir+string(filepath.Separator)) {
+			continue
+		}
+
+		if s.importsDir(rootdir, filename, basedir, map[string]bool{}) {
+			log.Trace().Str("file", filename).Msg("ignoring file that would create an import cycle")
+			continue
+		}
+
+		items = append(items, lsp.CompletionItem{
+			Label: name,
+			Kind:  lsp.CompletionItemKindFile,
+			TextEdit: &lsp.TextEdit{
+				Range:   editRange,
+				NewText: name,
+			},
+		})
+	}
+	return items
+}
+
+// importsDir tells if the file fname imports, directly or transitively, any file
+// from the directory dir.
+func (s *Server) importsDir(rootdir string, fname string, dir string, visited map[string]bool) bool {
+	if visited[fname] {
+		return false
+	}
+	visited[fname] = true
+
+	for _, src := range s.importSources(rootdir, fname) {
+		if filepath.Dir(src) == dir {
+			return true
+		}
+		if s.importsDir(rootdir, src, dir, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// importSources returns the absolute host paths of the files imported by fname.
+// Import blocks with invalid or non-literal sources are ignored.
+func (s *Server) importSources(rootdir string, fname string) []string {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return nil
+	}
+
+	file, _ := hclsyntax.ParseConfig(content, fname, hhcl.InitialPos)
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil
+	}
+
+	var sources []string
+	for _, block := range body.Blocks {
+		if block.Type != "import" {
+			continue
+		}
+		attr, ok := block.Body.Attributes["source"]
+		if !ok {
+			continue
+		}
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || val.Type() != cty.String || val.IsNull() {
+			continue
+		}
+		sources = append(sources, resolvePath(rootdir, filepath.Dir(fname), val.AsString()))
+	}
+	return sources
+}
+
+// resolvePath resolves the Terramate path p into an absolute host path.
+// Absolute paths are relative to the project rootdir and relative paths are
+// relative to basedir.
+func resolvePath(rootdir string, basedir string, p string) string {
+	if path.IsAbs(p) {
+		return filepath.Join(rootdir, filepath.FromSlash(p))
+	}
+	return filepath.Join(basedir, filepath.FromSlash(p))
+}
@@ -0,0 +1,160 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestImportSourceCompletion(t *testing.T) {
+	type testcase struct {
+		name   string
+		layout []string
+		file   string
+		pos    lsp.Position
+		want   []string
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "project absolute path lists root entries",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/\"\n}",
+				"f:modules/globals.tm:globals {}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 13},
+			want: []string{"modules/", "stack/"},
+		},
+		{
+			name: "root files are not importable from the same tree",
+			layout: []string{
+				test.RootConfig,
+				"f:root.tm.hcl:globals {}",
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/\"\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 13},
+			want: []string{"stack/"},
+		},
+		{
+			name: "only terramate files are listed",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/\"\n}",
+				"f:modules/globals.tm:globals {}",
+				"f:modules/globals.tm.hcl:globals {}",
+				"f:modules/README.md:# modules",
+				"f:modules/main.tf:",
+				"f:modules/.hidden.tm:globals {}",
+				"d:modules/nested",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 21},
+			want: []string{"globals.tm", "globals.tm.hcl", "nested/"},
+		},
+		{
+			name: "partial file name",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/glo\"\n}",
+				"f:modules/globals.tm:globals {}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 24},
+			want: []string{"globals.tm"},
+		},
+		{
+			name: "relative path",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/stack/stack.tm:stack {}\nimport {\n  source = \"../../modules/\"\n}",
+				"f:modules/globals.tm:globals {}",
+			},
+			file: "stacks/stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 26},
+			want: []string{"globals.tm"},
+		},
+		{
+			name: "relative path outside of project root",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"../../\"\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 18},
+		},
+		{
+			name: "files importing the current directory are ignored",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/\"\n}",
+				"f:stack/globals.tm:globals {}",
+				"f:modules/cycle.tm:import {\n source = \"/stack/globals.tm\"\n}",
+				"f:modules/indirect.tm:import {\n source = \"../modules/cycle.tm\"\n}",
+				"f:modules/safe.tm:globals {}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 21},
+			want: []string{"safe.tm"},
+		},
+		{
+			name: "outside of import block",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {\n  name = \"/\"\n}",
+				"f:modules/globals.tm:globals {}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 1, Character: 10},
+		},
+		{
+			name: "attribute other than source",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:import {\n  other = \"/\"\n}",
+				"f:modules/globals.tm:globals {}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 1, Character: 11},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			items := f.Editor.Completion(tc.file, tc.pos)
+			got := []string{}
+			for _, item := range items {
+				got = append(got, item.Label)
+			}
+			sort.Strings(got)
+
+			want := tc.want
+			if want == nil {
+				want = []string{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("completion labels mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/stack"
+	"github.com/zclconf/go-cty/cty"
+	lsp "go.lsp.dev/protocol"
+)
+
+// stackFilename is the name of the files created for new stacks.
+const stackFilename = "stack.tm"
+
+// createStackOptions are the optional settings of the stack created by the
+// terramate.createStack command, given as its second argument.
+type createStackOptions struct {
+	// Name of the stack, defaults to the directory name.
+	Name string `json:"name"`
+
+	// Description of the stack, defaults to the name.
+	Description string `json:"description"`
+
+	// Tags are rejected, as the stack schema of the Terramate version used by
+	// the language server has no tags.
+	Tags []string `json:"tags"`
+
+	After  []string `json:"after"`
+	Before []string `json:"before"`
+}
+
+// createStackOptionsFrom decodes the options of the command arguments, which
+// are all the arguments after the directory.
+func createStackOptionsFrom(args []interface{}) (createStackOptions, error) {
+	var opts createStackOptions
+	if len(args) < 2 || args[1] == nil {
+		return opts, nil
+	}
+	data, err := json.Marshal(args[1])
+	if err != nil {
+		return opts, err
+	}
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return opts, err
+	}
+	if len(opts.Tags) > 0 {
+		return opts, errors.E("stack tags are not supported by Terramate v0.2.6, used by the language server")
+	}
+	return opts, nil
+}
+
+// createStackEdits adds to edits the creation of the stack file at the host
+// directory dir, as `terramate create` does. The stack gets a new UUID as its
+// ID. It returns the host path of the stack file.
+func (s *Server) createStackEdits(dir string, opts createStackOptions, edits *fileEdits) (string, error) {
+	// the directory is created with the stack file.
+	basedir := dir
+	for {
+		if _, err := os.Stat(basedir); err == nil || filepath.Dir(basedir) == basedir {
+			break
+		}
+		basedir = filepath.Dir(basedir)
+	}
+	p, err := s.loadProject(basedir)
+	if err != nil {
+		return "", err
+	}
+
+	if !p.contains(dir) {
+		return "", errors.E(stack.ErrInvalidStackDir,
+			"stack %q must be inside project root %q", dir, p.rootdir)
+	}
+	if strings.HasPrefix(filepath.Base(dir), ".") {
+		return "", errors.E(stack.ErrInvalidStackDir, "dot directories not allowed")
+	}
+	target := filepath.Join(dir, stackFilename)
+	if _, err := s.documents.read(target); err == nil {
+		return "", errors.E(stack.ErrStackDefaultCfgFound, "check file %q", target)
+	}
+	if _, ok := p.stackAt(dir); ok {
+		return "", errors.E(stack.ErrStackAlreadyExists)
+	}
+
+	if opts.Name == "" {
+		opts.Name = filepath.Base(dir)
+	}
+	if opts.Description == "" {
+		opts.Description = opts.Name
+	}
+
+	f := hclwrite.NewEmptyFile()
+	body := f.Body().AppendNewBlock("stack", nil).Body()
+	body.SetAttributeValue("name", cty.StringVal(opts.Name))
+	body.SetAttributeValue("description", cty.StringVal(opts.Description))
+	for _, attr := range []struct {
+		name   string
+		values []string
+	}{
+		{name: "after", values: opts.After},
+		{name: "before", values: opts.Before},
+	} {
+		if len(attr.values) == 0 {
+			continue
+		}
+		list := make([]cty.Value, 0, len(attr.values))
+		for _, value := range attr.values {
+			list = append(list, cty.StringVal(value))
+		}
+		body.SetAttributeValue(attr.name, cty.SetVal(list))
+	}
+	body.SetAttributeValue("id", cty.StringVal(uuid.NewString()))
+
+	edits.create(target)
+	edits.add(target, lsp.TextEdit{NewText: string(f.Bytes())})
+	return target, nil
+}
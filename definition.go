@@ -0,0 +1,154 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleDefinition(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DefinitionParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	locations, err := s.definition(fname, params.Position)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to find definition")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, locations, nil)
+}
+
+// definition returns the locations defining the symbol at the given position
+// of the file. The supported symbols are:
+//   - global references, which jump to the attributes defining them.
+//   - import sources, which jump to the imported file.
+//   - stack paths of after, before, wants and wanted_by, which jump to the
+//     file defining the target stack.
+func (s *Server) definition(fname string, pos lsp.Position) ([]lsp.Location, error) {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(fname)
+	offset := offsetFor(content, pos)
+	body := parseBody(fname, content)
+
+	if path, _, ok := globalAt(body, offset); ok {
+		p, err := s.loadProject(dir)
+		if err != nil {
+			return nil, err
+		}
+		return globalLocations(p, dir, path), nil
+	}
+
+	str, _, ok := stringAt(body, offset)
+	if !ok {
+		return nil, nil
+	}
+
+	attr, blocks, ok := attributeAt(body, offset)
+	if !ok || len(blocks) != 1 {
+		return nil, nil
+	}
+
+	rootdir := s.projectRoot(dir)
+	target := resolvePath(rootdir, dir, str)
+
+	switch {
+	case blocks[0].Type == "import" && attr.Name == "source":
+		if _, err := os.Stat(target); err != nil {
+			return nil, nil
+		}
+		return []lsp.Location{{URI: fileURI(target)}}, nil
+	case blocks[0].Type == "stack" && isStackReference(attr.Name):
+		if loc, ok := s.stackLocation(target); ok {
+			return []lsp.Location{loc}, nil
+		}
+	}
+	return nil, nil
+}
+
+// isStackReference tells if the stack attribute name contains stack paths.
+func isStackReference(name string) bool {
+	switch name {
+	case "after", "before", "wants", "wanted_by":
+		return true
+	}
+	return false
+}
+
+// globalLocations returns the locations of the attributes defining the global
+// path visible from dir, ordered from the most specific to the least specific.
+// If the exact path is not defined at any level, the locations defining its
+// parent or child objects are returned instead.
+func globalLocations(p *projectState, dir string, path []string) []lsp.Location {
+	defs := p.globalDefinitions(dir, path)
+
+	var exact []lsp.Location
+	var related []lsp.Location
+	for _, def := range defs {
+		loc := lsp.Location{
+			URI:   fileURI(def.nameRange.Filename),
+			Range: lspRange(def.nameRange),
+		}
+		if equalPaths(def.path, path) {
+			exact = append(exact, loc)
+		} else {
+			related = append(related, loc)
+		}
+	}
+
+	if len(exact) > 0 {
+		return exact
+	}
+	return related
+}
+
+// stackLocation returns the location of the stack block of the stack defined
+// at the host directory dir.
+func (s *Server) stackLocation(dir string) (lsp.Location, bool) {
+	for _, fname := range s.dirFiles(dir) {
+		content, err := s.documents.read(fname)
+		if err != nil {
+			continue
+		}
+		for _, block := range parseBody(fname, content).Blocks {
+			if block.Type == "stack" {
+				return lsp.Location{
+					URI:   fileURI(fname),
+					Range: lspRange(block.TypeRange),
+				}, true
+			}
+		}
+	}
+	return lsp.Location{}, false
+}
@@ -0,0 +1,208 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestDefinition(t *testing.T) {
+	type wantLocation struct {
+		file string
+		rng  lsp.Range
+	}
+	type testcase struct {
+		name   string
+		layout []string
+		file   string
+		pos    lsp.Position
+		want   []wantLocation
+	}
+
+	line := func(l, start, end uint32) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: l, Character: start},
+			End:   lsp.Position{Line: l, Character: end},
+		}
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "global defined at the root",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.region\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 10},
+			want: []wantLocation{
+				{file: "globals.tm", rng: line(1, 2, 8)},
+			},
+		},
+		{
+			name: "shadowed globals are returned from the most specific",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stacks/globals.tm:globals {\n  other = 1\n  region = \"eu-west-1\"\n}",
+				"f:stacks/stack/stack.tm:stack {}\ngenerate_hcl \"f.tf\" {\n  content {\n    r = global.region\n  }\n}",
+				"f:stacks/stack/globals.tm:globals {\n  region = \"sa-east-1\"\n}",
+				"f:other/globals.tm:globals {\n  region = \"ignored\"\n}",
+			},
+			file: "stacks/stack/stack.tm",
+			pos:  lsp.Position{Line: 3, Character: 16},
+			want: []wantLocation{
+				{file: "stacks/stack/globals.tm", rng: line(1, 2, 8)},
+				{file: "stacks/globals.tm", rng: line(2, 2, 8)},
+				{file: "globals.tm", rng: line(1, 2, 8)},
+			},
+		},
+		{
+			name: "labelled globals",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals \"obj\" {\n  a = 1\n  b = 2\n}",
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.obj.b\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 10},
+			want: []wantLocation{
+				{file: "globals.tm", rng: line(2, 2, 3)},
+			},
+		},
+		{
+			name: "object attribute of a global defined as a whole",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  obj = {\n    a = 1\n  }\n}",
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.obj.a\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 10},
+			want: []wantLocation{
+				{file: "globals.tm", rng: line(1, 2, 5)},
+			},
+		},
+		{
+			name: "imported globals",
+			layout: []string{
+				test.RootConfig,
+				"f:modules/globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/globals.tm\"\n}\nglobals {\n  a = global.region\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 5, Character: 10},
+			want: []wantLocation{
+				{file: "modules/globals.tm", rng: line(1, 2, 8)},
+			},
+		},
+		{
+			name: "undefined global",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.undefined\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 10},
+		},
+		{
+			name: "import source",
+			layout: []string{
+				test.RootConfig,
+				"f:modules/globals.tm:globals {}",
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"../modules/globals.tm\"\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 16},
+			want: []wantLocation{
+				{file: "modules/globals.tm"},
+			},
+		},
+		{
+			name: "import source of non-existent file",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/globals.tm\"\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 16},
+		},
+		{
+			name: "stack after project absolute path",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/config.tm:globals {}",
+				"f:stacks/a/stack.tm:\n\nstack {}",
+				"f:stacks/b/stack.tm:stack {\n  after = [\"/stacks/a\"]\n}",
+			},
+			file: "stacks/b/stack.tm",
+			pos:  lsp.Position{Line: 1, Character: 14},
+			want: []wantLocation{
+				{file: "stacks/a/stack.tm", rng: line(2, 0, 5)},
+			},
+		},
+		{
+			name: "stack before relative path",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/stack.tm:stack {}",
+				"f:stacks/b/stack.tm:stack {\n  before = [\"/other\", \"../a\"]\n}",
+			},
+			file: "stacks/b/stack.tm",
+			pos:  lsp.Position{Line: 1, Character: 24},
+			want: []wantLocation{
+				{file: "stacks/a/stack.tm", rng: line(0, 0, 5)},
+			},
+		},
+		{
+			name: "stack path of a non-stack directory",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/globals.tm:globals {}",
+				"f:stacks/b/stack.tm:stack {\n  after = [\"/stacks/a\"]\n}",
+			},
+			file: "stacks/b/stack.tm",
+			pos:  lsp.Position{Line: 1, Character: 14},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			want := []lsp.Location{}
+			for _, loc := range tc.want {
+				want = append(want, lsp.Location{
+					URI:   uri.File(filepath.Join(f.Sandbox.RootDir(), loc.file)),
+					Range: loc.rng,
+				})
+			}
+
+			got := f.Editor.Definition(tc.file, tc.pos)
+			if got == nil {
+				got = []lsp.Location{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("definition mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mineiros-io/terramate/hcl/eval"
+	"github.com/rs/zerolog/log"
+	"github.com/zclconf/go-cty/cty/function"
+)
+
+// blockDocs documents the blocks of the Terramate configuration schema.
+var blockDocs = map[string]string{
+	"terramate": "Configures the Terramate project. " +
+		"It is only allowed at the project root directory.",
+	"config": "Project wide configuration, like `git` and `run` settings.",
+	"git": "Git settings used by the change detection and safeguards, " +
+		"like `default_branch` and `default_remote`.",
+	"run": "Settings for `terramate run`, like `check_gen_code`.",
+	"env": "Environment variables exported to the commands executed by " +
+		"`terramate run`.",
+	"stack": "Marks the directory as a stack. Supports `id`, `name`, " +
+		"`description`, `after`, `before`, `wants`, `wanted_by` and `watch`.",
+	"globals": "Defines global values. Globals are inherited by all child " +
+		"directories and more specific directories override the definitions " +
+		"of their parents. Labels define the object path of the attributes.",
+	"map": "Builds a global map from the `for_each` collection using the " +
+		"`key` and `value` of each element.",
+	"value": "Defines the value of each element of a `map` block.",
+	"generate_hcl": "Generates an HCL file, with the name given by the " +
+		"label, for each stack inheriting this block. " +
+		"The file is generated from the `content` block.",
+	"generate_file": "Generates a file, with the name given by the label, " +
+		"for each stack inheriting this block. " +
+		"The file is generated from the `content` attribute.",
+	"content": "The HCL code generated by `generate_hcl`. " +
+		"Terramate expressions are evaluated and any other expression is " +
+		"copied as is.",
+	"lets": "Defines local variables, available as `let.<name>`, for the " +
+		"code generation block.",
+	"assert": "Fails (or warns, if `warning` is true) with the `message` " +
+		"when the `assertion` is false.",
+	"import": "Imports the Terramate configuration of the file in the " +
+		"`source` attribute, which can be relative or project absolute.",
+	"vendor": "Configures `terramate experimental vendor`, like the `dir` " +
+		"used for the vendored modules.",
+	"manifest": "Defines which files are vendored.",
+	"default": "The default set of files to vendor, from the `files` " +
+		"attribute.",
+}
+
+// terramateFuncDocs documents the functions that are specific to Terramate,
+// the other functions are the Terraform functions with the tm_ prefix.
+var terramateFuncDocs = map[string]string{
+	"tm_abspath": "Returns the absolute path of the given path, relative " +
+		"paths are resolved from the directory of the current file.",
+	"tm_ternary": "Returns the second argument if the condition is true or " +
+		"the third one otherwise. Unlike the conditional operator, only the " +
+		"chosen argument is evaluated.",
+	"tm_hcl_expression": "Parses the given string as an HCL expression " +
+		"which is inserted as is in the generated code.",
+	"tm_vendor": "Returns the path to the vendored module source, " +
+		"relative to the file being generated.",
+}
+
+// functionDoc returns the Markdown documentation of the function name.
+func functionDoc(dir string, name string) (string, bool) {
+	ctx, err := eval.NewContext(dir)
+	if err != nil {
+		log.Debug().Err(err).Msg("creating evaluation context")
+		return "", false
+	}
+	ctx.AddTmHCLExpression()
+
+	var signature string
+	fn, ok := ctx.Unwrap().Functions[name]
+	if ok {
+		signature = functionSignature(name, fn)
+	} else if name == "tm_vendor" {
+		signature = "tm_vendor(source string)"
+	} else {
+		return "", false
+	}
+
+	doc, ok := terramateFuncDocs[name]
+	if !ok {
+		tfname := strings.TrimPrefix(name, "tm_")
+		doc = fmt.Sprintf("Terramate version of the Terraform [`%s`]"+
+			"(https://developer.hashicorp.com/terraform/language/functions/%s) function.",
+			tfname, tfname)
+	}
+	return fmt.Sprintf("```hcl\n%s\n```\n\n%s", signature, doc), true
+}
+
+func functionSignature(name string, fn function.Function) string {
+	var params []string
+	for _, param := range fn.Params() {
+		params = append(params, param.Name+" "+param.Type.FriendlyName())
+	}
+	if param := fn.VarParam(); param != nil {
+		params = append(params, "..."+param.Name+" "+param.Type.FriendlyName())
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+}
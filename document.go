@@ -0,0 +1,140 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// documents keeps the content of the files opened in the editor.
+// The editor is the source of truth for the opened files, then their content
+// must be used instead of the (possibly stale) content on disk.
+type documents struct {
+	mu    sync.Mutex
+	files map[string]string
+}
+
+func newDocuments() *documents {
+	return &documents{
+		files: map[string]string{},
+	}
+}
+
+func (d *documents) set(fname string, content string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.files[fname] = content
+}
+
+func (d *documents) remove(fname string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.files, fname)
+}
+
+func (d *documents) get(fname string) (string, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	content, ok := d.files[fname]
+	return content, ok
+}
+
+// list returns the sorted filenames of the opened files.
+func (d *documents) list() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	files := make([]string, 0, len(d.files))
+	for fname := range d.files {
+		files = append(files, fname)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// read returns the content of the file, from the editor buffer if the file is
+// opened or from disk otherwise.
+func (d *documents) read(fname string) ([]byte, error) {
+	if content, ok := d.get(fname); ok {
+		return []byte(content), nil
+	}
+	return os.ReadFile(fname)
+}
+
+// offsetFor returns the byte offset of the LSP position in the content.
+// The LSP character is an offset in UTF-16 code units.
+func offsetFor(content []byte, pos lsp.Position) int {
+	offset := 0
+	for line := uint32(0); line < pos.Line; line++ {
+		for offset < len(content) && content[offset] != '\n' {
+			offset++
+		}
+		if offset == len(content) {
+			return offset
+		}
+		offset++
+	}
+
+	units := uint32(0)
+	for offset < len(content) && content[offset] != '\n' && units < pos.Character {
+		r, size := utf8.DecodeRune(content[offset:])
+		units += uint32(len(utf16.Encode([]rune{r})))
+		offset += size
+	}
+	return offset
+}
+
+// positionFor returns the LSP position of the given byte offset in content.
+func positionFor(content []byte, offset int) lsp.Position {
+	var pos lsp.Position
+	for i := 0; i < offset && i < len(content); {
+		r, size := utf8.DecodeRune(content[i:])
+		if r == '\n' {
+			pos.Line++
+			pos.Character = 0
+		} else {
+			pos.Character += uint32(len(utf16.Encode([]rune{r})))
+		}
+		i += size
+	}
+	return pos
+}
+
+// fileURI returns the URI of the host file path.
+func fileURI(fname string) lsp.URI {
+	return lsp.URI(uri.File(filepath.ToSlash(fname)))
+}
+
+// lspRange converts the HCL range into a LSP range.
+func lspRange(r hhcl.Range) lsp.Range {
+	return lsp.Range{
+		Start: lsp.Position{
+			Line:      uint32(r.Start.Line) - 1,
+			Character: uint32(r.Start.Column) - 1,
+		},
+		End: lsp.Position{
+			Line:      uint32(r.End.Line) - 1,
+			Character: uint32(r.End.Column) - 1,
+		},
+	}
+}
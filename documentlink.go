@@ -0,0 +1,128 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleDocumentLink(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DocumentLinkParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, s.documentLinks(fname, content), nil)
+}
+
+// documentLinks returns the links of the paths in the file:
+//   - the import sources and the watch files link to the files.
+//   - the stack paths of the after, before, wants and wanted_by attributes
+//     link to the stack blocks.
+//   - the labels of the generate blocks of stacks link to the generated files.
+//
+// The paths are resolved from the directory of the file, or from the project
+// root if they are absolute. Paths of missing files and stacks have no links.
+func (s *Server) documentLinks(fname string, content []byte) []lsp.DocumentLink {
+	links := []lsp.DocumentLink{}
+	dir := filepath.Dir(fname)
+	rootdir := s.projectRoot(dir)
+
+	link := func(rng hhcl.Range, target lsp.URI) {
+		// the link does not include the quotes of the string.
+		links = append(links, lsp.DocumentLink{
+			Range: lsp.Range{
+				Start: positionFor(content, rng.Start.Byte+1),
+				End:   positionFor(content, rng.End.Byte-1),
+			},
+			Target: lsp.DocumentURI(target),
+		})
+	}
+	fileLink := func(expr hclsyntax.Expression) {
+		value, ok := stringLiteral(expr)
+		if !ok {
+			return
+		}
+		target := resolvePath(rootdir, dir, value)
+		if st, err := os.Stat(target); err == nil && !st.IsDir() {
+			link(expr.Range(), fileURI(target))
+		}
+	}
+	stackLink := func(expr hclsyntax.Expression) {
+		value, ok := stringLiteral(expr)
+		if !ok {
+			return
+		}
+		if loc, ok := s.stackLocation(resolvePath(rootdir, dir, value)); ok {
+			link(expr.Range(), loc.URI)
+		}
+	}
+
+	body := parseBody(fname, content)
+	_, isStack := s.stackLocation(dir)
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "import":
+			if attr, ok := block.Body.Attributes["source"]; ok {
+				fileLink(attr.Expr)
+			}
+		case "stack":
+			for _, attr := range sortedAttributes(block.Body.Attributes) {
+				tuple, ok := attr.Expr.(*hclsyntax.TupleConsExpr)
+				if !ok {
+					continue
+				}
+				for _, elem := range tuple.Exprs {
+					switch {
+					case attr.Name == "watch":
+						fileLink(elem)
+					case isStackReference(attr.Name):
+						stackLink(elem)
+					}
+				}
+			}
+		case "generate_hcl", "generate_file":
+			if !isStack || len(block.Labels) != 1 {
+				continue
+			}
+			target := filepath.Join(dir, filepath.FromSlash(block.Labels[0]))
+			if st, err := os.Stat(target); err == nil && !st.IsDir() {
+				link(block.LabelRanges[0], fileURI(target))
+			}
+		}
+	}
+	return links
+}
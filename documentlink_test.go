@@ -0,0 +1,70 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestDocumentLinks(t *testing.T) {
+	f := test.Setup(t,
+		"f:modules/common.tm:globals {}\n",
+		"f:files/watched.txt:watched\n",
+		"f:stacks/b/stack.tm:stack {}\n",
+		"f:stacks/a/main.tf:"+genHeader+"a = 1\n",
+		"f:stacks/a/stack.tm:import {\n  source = \"/modules/common.tm\"\n}\n\n"+
+			"import {\n  source = \"../../missing.tm\"\n}\n\n"+
+			"stack {\n"+
+			"  after = [\"../b\", \"/stacks/missing\"]\n"+
+			"  watch = [\"/files/watched.txt\", \"watched.json\"]\n"+
+			"}\n\n"+
+			"generate_hcl \"main.tf\" {\n  content {\n    a = 1\n  }\n}\n\n"+
+			"generate_file \"missing.txt\" {\n  content = \"a\"\n}\n",
+		"f:stacks/main.tf:"+genHeader+"a = 1\n",
+		"f:stacks/generate.tm:generate_hcl \"main.tf\" {\n  content {\n    a = 1\n  }\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	link := func(line, start, end uint32, target string) lsp.DocumentLink {
+		return lsp.DocumentLink{
+			Range: lsp.Range{
+				Start: lsp.Position{Line: line, Character: start},
+				End:   lsp.Position{Line: line, Character: end},
+			},
+			Target: uri.File(filepath.Join(f.Sandbox.RootDir(), target)),
+		}
+	}
+
+	want := []lsp.DocumentLink{
+		link(1, 12, 30, "modules/common.tm"),
+		link(9, 12, 16, "stacks/b/stack.tm"),
+		link(10, 12, 30, "files/watched.txt"),
+		link(13, 14, 21, "stacks/a/main.tf"),
+	}
+	if diff := cmp.Diff(want, f.Editor.DocumentLinks("stacks/a/stack.tm")); diff != "" {
+		t.Fatalf("links mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	// generate blocks outside stacks generate code for many stacks.
+	if got := f.Editor.DocumentLinks("stacks/generate.tm"); len(got) != 0 {
+		t.Fatalf("want no links outside stacks, got %+v", got)
+	}
+}
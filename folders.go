@@ -0,0 +1,148 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// folderDirs returns the host directories of the workspace folders.
+func folderDirs(folders []lsp.WorkspaceFolder) []string {
+	dirs := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		dirs = append(dirs, uri.New(folder.URI).Filename())
+	}
+	return dirs
+}
+
+// workspaceFolders returns the host directories of the workspace folders, in
+// the order they were added.
+func (s *Server) workspaceFolders() []string {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	return append([]string{}, s.folders...)
+}
+
+// defaultFolder returns the first workspace folder, which is the project root
+// of the files outside of the workspace folders.
+func (s *Server) defaultFolder() string {
+	s.foldersMu.Lock()
+	defer s.foldersMu.Unlock()
+	if len(s.folders) == 0 {
+		return ""
+	}
+	return s.folders[0]
+}
+
+// workspaceProject returns a directory of the project of the workspace folders,
+// which is used by the requests not given a directory. It fails if the folders
+// are in different projects, as the request is ambiguous then.
+func (s *Server) workspaceProject() (string, error) {
+	rootdirs := map[string]bool{}
+	for _, folder := range s.workspaceFolders() {
+		rootdirs[s.projectRoot(folder)] = true
+	}
+	if len(rootdirs) > 1 {
+		return "", errors.E("the workspace folders are in %d projects, a directory is required", len(rootdirs))
+	}
+	return s.defaultFolder(), nil
+}
+
+// workspaceFolder returns the workspace folder containing the host directory
+// dir, the innermost one if the folders are nested. The default folder is
+// returned if no folder contains dir.
+func (s *Server) workspaceFolder(dir string) string {
+	found := ""
+	for _, folder := range s.workspaceFolders() {
+		if (dir == folder || strings.HasPrefix(dir, folder+string(filepath.Separator))) &&
+			len(folder) > len(found) {
+			found = folder
+		}
+	}
+	if found == "" {
+		return s.defaultFolder()
+	}
+	return found
+}
+
+// addWorkspaceFolders adds the folders not yet in the workspace, which are
+// returned.
+func (s *Server) addWorkspaceFolders(dirs []string) []string {
+	s.foldersMu.Lock()
+	var added []string
+	for _, dir := range dirs {
+		exists := false
+		for _, folder := range s.folders {
+			exists = exists || folder == dir
+		}
+		if !exists {
+			s.folders = append(s.folders, dir)
+			added = append(added, dir)
+		}
+	}
+	s.foldersMu.Unlock()
+	return added
+}
+
+// removeWorkspaceFolders removes the folders from the workspace and drops the
+// indexes of the projects inside them.
+func (s *Server) removeWorkspaceFolders(dirs []string) {
+	s.foldersMu.Lock()
+	folders := s.folders[:0]
+	for _, folder := range s.folders {
+		removed := false
+		for _, dir := range dirs {
+			removed = removed || folder == dir
+		}
+		if !removed {
+			folders = append(folders, folder)
+		}
+	}
+	s.folders = folders
+	s.foldersMu.Unlock()
+
+	for _, dir := range dirs {
+		s.dropIndexesIn(dir)
+	}
+}
+
+func (s *Server) handleDidChangeWorkspaceFolders(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DidChangeWorkspaceFoldersParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	s.removeWorkspaceFolders(folderDirs(params.Event.Removed))
+	for _, dir := range s.addWorkspaceFolders(folderDirs(params.Event.Added)) {
+		go s.index(s.projectRoot(dir))
+	}
+	log.Info().Strs("folders", s.workspaceFolders()).Msg("workspace folders changed")
+	return reply(ctx, nil, nil)
+}
@@ -0,0 +1,92 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestWorkspaceFolders(t *testing.T) {
+	f := test.Setup(t,
+		"f:repo1/a/stack.tm:stack {\n  after = [\"/b\"]\n}\n",
+		"f:repo1/b/stack.tm:stack {}\n",
+		"f:repo2/c/stack.tm:stack {\n  after = [\"/d\"]\n}\n",
+		"f:repo2/d/stack.tm:stack {}\n",
+		"f:repo3/e/stack.tm:stack {}\n",
+	)
+	got := f.Editor.InitializeWithFolders("repo1", "repo2")
+	if diff := cmp.Diff(test.DefaultInitializeResult(), got); diff != "" {
+		t.Fatalf("init result mismatch, want(-) got(+):\n%s", diff)
+	}
+	assert.EqualStrings(t, lsp.MethodWindowShowMessage, (<-f.Editor.Requests).Method())
+
+	// the stack paths are relative to the folder of each file.
+	f.Editor.Open("repo2/c/stack.tm")
+	if diags := f.Editor.Diagnostics("repo2/c/stack.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics, got %+v", diags)
+	}
+	drainRequests(f.Editor)
+
+	stacks := func() []string {
+		var paths []string
+		for _, sym := range f.Editor.WorkspaceSymbols("") {
+			if sym.Kind == lsp.SymbolKindModule {
+				paths = append(paths, sym.ContainerName)
+			}
+		}
+		return paths
+	}
+	if diff := cmp.Diff([]string{"/a", "/b", "/c", "/d"}, stacks()); diff != "" {
+		t.Fatalf("stacks mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	f.Editor.ChangeWorkspaceFolders([]string{"repo3"}, []string{"repo1"})
+	if diff := cmp.Diff([]string{"/c", "/d", "/e"}, stacks()); diff != "" {
+		t.Fatalf("stacks mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestWorkspaceFoldersWithoutDirectory(t *testing.T) {
+	f := test.Setup(t,
+		"f:repo1/terramate.tm:terramate {\n  config {\n  }\n}\n",
+		"f:repo1/a/stack.tm:stack {\n  after = [\"/b\"]\n}\n",
+		"f:repo1/b/stack.tm:stack {}\n",
+		"f:repo2/c/stack.tm:stack {}\n",
+	)
+	f.Editor.InitializeWithFolders("repo1", "repo1/a")
+	drainRequests(f.Editor)
+
+	// the folders of a single project are the whole project.
+	if diff := cmp.Diff([]string{"/b", "/a"}, f.Editor.RunOrder("").Order); diff != "" {
+		t.Fatalf("run order mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	f.Editor.ChangeWorkspaceFolders([]string{"repo2"}, nil)
+	drainRequests(f.Editor)
+	want := "the workspace folders are in 2 projects, a directory is required"
+	err := f.Editor.ExecuteCommand("terramate.generate", nil, nil)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("want error %q, got %v", want, err)
+	}
+	if diff := cmp.Diff([]string{"/c"}, f.Editor.RunOrder("repo2").Order); diff != "" {
+		t.Fatalf("run order mismatch, want(-) got(+):\n%s", diff)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleFoldingRange(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.FoldingRangeParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, foldingRanges(fname, content), nil)
+}
+
+// foldingRanges returns the folding ranges of the Terramate file. It folds:
+//   - blocks and multi-line objects and lists, keeping the closing brace or
+//     bracket visible.
+//   - heredocs, keeping the closing marker visible.
+//   - groups of comments in consecutive lines and multi-line comments.
+//
+// Only the lines are folded, as not every editor can fold part of a line.
+func foldingRanges(fname string, content []byte) []lsp.FoldingRange {
+	var ranges []lsp.FoldingRange
+
+	// fold adds the folding range between the lines, which are 1-based as the
+	// HCL positions are.
+	fold := func(start, end int, kind lsp.FoldingRangeKind) {
+		if end <= start {
+			return
+		}
+		ranges = append(ranges, lsp.FoldingRange{
+			StartLine: uint32(start - 1),
+			EndLine:   uint32(end - 1),
+			Kind:      kind,
+		})
+	}
+
+	body := parseBody(fname, content)
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hhcl.Diagnostics {
+		switch n := node.(type) {
+		case *hclsyntax.Block:
+			fold(n.OpenBraceRange.Start.Line, n.CloseBraceRange.Start.Line-1, "")
+		case *hclsyntax.ObjectConsExpr, *hclsyntax.TupleConsExpr:
+			rng := n.Range()
+			fold(rng.Start.Line, rng.End.Line-1, "")
+		}
+		return nil
+	})
+
+	tokens, _ := hclsyntax.LexConfig(content, fname, hhcl.InitialPos)
+	heredocStart := 0
+	commentStart, commentEnd := 0, 0
+	lastCodeLine := 0
+	for _, tok := range tokens {
+		switch tok.Type {
+		case hclsyntax.TokenOHeredoc:
+			heredocStart = tok.Range.Start.Line
+		case hclsyntax.TokenCHeredoc:
+			fold(heredocStart, tok.Range.Start.Line-1, "")
+		case hclsyntax.TokenComment:
+			if tok.Range.Start.Line == lastCodeLine {
+				// comments after code are not part of a comment group.
+				continue
+			}
+			end := tok.Range.End.Line
+			if bytes.HasSuffix(tok.Bytes, []byte("\n")) {
+				end--
+			}
+			if commentEnd == 0 || tok.Range.Start.Line != commentEnd+1 {
+				fold(commentStart, commentEnd, lsp.CommentFoldingRange)
+				commentStart = tok.Range.Start.Line
+			}
+			commentEnd = end
+			continue
+		case hclsyntax.TokenNewline:
+			continue
+		}
+		lastCodeLine = tok.Range.End.Line
+	}
+	fold(commentStart, commentEnd, lsp.CommentFoldingRange)
+
+	// a single range per starting line, the outermost one.
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].StartLine != ranges[j].StartLine {
+			return ranges[i].StartLine < ranges[j].StartLine
+		}
+		return ranges[i].EndLine > ranges[j].EndLine
+	})
+	result := []lsp.FoldingRange{}
+	for i, rng := range ranges {
+		if i > 0 && rng.StartLine == ranges[i-1].StartLine {
+			continue
+		}
+		result = append(result, rng)
+	}
+	return result
+}
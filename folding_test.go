@@ -0,0 +1,112 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestFoldingRanges(t *testing.T) {
+	type testcase struct {
+		name    string
+		content string
+		want    []lsp.FoldingRange
+	}
+
+	fold := func(start, end uint32) lsp.FoldingRange {
+		return lsp.FoldingRange{StartLine: start, EndLine: end}
+	}
+	comment := func(start, end uint32) lsp.FoldingRange {
+		return lsp.FoldingRange{StartLine: start, EndLine: end, Kind: lsp.CommentFoldingRange}
+	}
+
+	for _, tc := range []testcase{
+		{
+			name:    "empty document",
+			content: "",
+			want:    []lsp.FoldingRange{},
+		},
+		{
+			name:    "single line block",
+			content: "stack {}\n",
+			want:    []lsp.FoldingRange{},
+		},
+		{
+			name:    "nested blocks",
+			content: "terramate {\n  config {\n    git {\n      default_branch = \"main\"\n    }\n  }\n}\n",
+			want: []lsp.FoldingRange{
+				fold(0, 5),
+				fold(1, 4),
+				fold(2, 3),
+			},
+		},
+		{
+			name: "objects and lists",
+			content: "globals {\n  obj = {\n    a = 1\n  }\n  list = [\n    1,\n    2,\n  ]\n" +
+				"  inline = { a = [1] }\n}\n",
+			want: []lsp.FoldingRange{
+				fold(0, 8),
+				fold(1, 2),
+				fold(4, 6),
+			},
+		},
+		{
+			name:    "heredoc",
+			content: "globals {\n  a = <<-EOT\n  one\n  two\n  EOT\n}\n",
+			want: []lsp.FoldingRange{
+				fold(0, 4),
+				fold(1, 3),
+			},
+		},
+		{
+			name: "comment groups",
+			content: "# one\n# two\n// three\n\n# alone\nstack {\n  name = \"a\" # trailing\n" +
+				"  # inner\n  # comments\n}\n/*\n multi\n*/\n",
+			want: []lsp.FoldingRange{
+				comment(0, 2),
+				fold(5, 8),
+				comment(7, 8),
+				comment(10, 12),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, "f:file.tm:"+tc.content)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			got := f.Editor.FoldingRanges("file.tm")
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("folding ranges mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFoldingRangesUseEditorBuffer(t *testing.T) {
+	f := test.Setup(t, "f:file.tm:stack {}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+	f.Editor.Open("file.tm")
+	f.Editor.Change("file.tm", "stack {\n  name = \"a\"\n}\n")
+	drainRequests(f.Editor)
+
+	want := []lsp.FoldingRange{{StartLine: 0, EndLine: 1}}
+	if diff := cmp.Diff(want, f.Editor.FoldingRanges("file.tm")); diff != "" {
+		t.Fatalf("folding ranges mismatch, want(-) got(+):\n%s", diff)
+	}
+}
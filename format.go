@@ -0,0 +1,190 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/mineiros-io/terramate/errors"
+	hclfmt "github.com/mineiros-io/terramate/hcl/fmt"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// maxDiffCells is the maximum size of the table used to compute the minimal
+// line edits. Bigger changes are replaced as a single edit.
+const maxDiffCells = 1_000_000
+
+func (s *Server) handleFormatting(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DocumentFormattingParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	edits, err := s.format(params.TextDocument.URI.Filename())
+	if err != nil {
+		log.Debug().Err(err).Msg("not formatting document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, edits, nil)
+}
+
+func (s *Server) handleRangeFormatting(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DocumentRangeFormattingParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	edits, err := s.format(params.TextDocument.URI.Filename())
+	if err != nil {
+		log.Debug().Err(err).Msg("not formatting document")
+		return reply(ctx, nil, nil)
+	}
+
+	inRange := []lsp.TextEdit{}
+	for _, edit := range edits {
+		if rangesOverlap(edit.Range, params.Range) {
+			inRange = append(inRange, edit)
+		}
+	}
+	return reply(ctx, inRange, nil)
+}
+
+// format formats the document with the same formatter as `terramate fmt` and
+// returns the edits needed to format it. Documents with syntax errors or of
+// projects disabling the formatting are not formatted.
+func (s *Server) format(fname string) ([]lsp.TextEdit, error) {
+	if s.projectConfig(s.projectRoot(filepath.Dir(fname))).formatDisabled {
+		return nil, errors.E("formatting disabled by %s", projectConfigFilename)
+	}
+
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	formatted, err := hclfmt.Format(string(content), fname)
+	if err != nil {
+		return nil, err
+	}
+	return lineEdits(string(content), formatted), nil
+}
+
+// lineEdits returns the edits transforming before into after. Only the lines
+// which differ are replaced, so the editor keeps the cursor and markers of the
+// unchanged lines.
+func lineEdits(before, after string) []lsp.TextEdit {
+	a := strings.SplitAfter(before, "\n")
+	b := strings.SplitAfter(after, "\n")
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	// offsets are the byte offsets of the start of each line of before.
+	offsets := make([]int, len(a)+1)
+	for i, line := range a {
+		offsets[i+1] = offsets[i] + len(line)
+	}
+	content := []byte(before)
+	newEdit := func(aStart, aEnd, bStart, bEnd int) lsp.TextEdit {
+		return lsp.TextEdit{
+			Range: lsp.Range{
+				Start: positionFor(content, offsets[aStart]),
+				End:   positionFor(content, offsets[aEnd]),
+			},
+			NewText: strings.Join(b[bStart:bEnd], ""),
+		}
+	}
+
+	am := a[prefix : len(a)-suffix]
+	bm := b[prefix : len(b)-suffix]
+	if len(am) == 0 && len(bm) == 0 {
+		return []lsp.TextEdit{}
+	}
+	if len(am)*len(bm) > maxDiffCells {
+		return []lsp.TextEdit{newEdit(prefix, len(a)-suffix, prefix, len(b)-suffix)}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of am[i:]
+	// and bm[j:].
+	lcs := make([][]int, len(am)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bm)+1)
+	}
+	for i := len(am) - 1; i >= 0; i-- {
+		for j := len(bm) - 1; j >= 0; j-- {
+			if am[i] == bm[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	edits := []lsp.TextEdit{}
+	i, j := 0, 0
+	for i < len(am) || j < len(bm) {
+		if i < len(am) && j < len(bm) && am[i] == bm[j] {
+			i++
+			j++
+			continue
+		}
+		i0, j0 := i, j
+		for (i < len(am) || j < len(bm)) && !(i < len(am) && j < len(bm) && am[i] == bm[j]) {
+			if j == len(bm) || (i < len(am) && lcs[i+1][j] >= lcs[i][j+1]) {
+				i++
+			} else {
+				j++
+			}
+		}
+		edits = append(edits, newEdit(prefix+i0, prefix+i, prefix+j0, prefix+j))
+	}
+	return edits
+}
+
+// rangesOverlap tells if the ranges a and b have any position in common.
+func rangesOverlap(a, b lsp.Range) bool {
+	return !positionBefore(a.End, b.Start) && !positionBefore(b.End, a.Start)
+}
+
+// positionBefore tells if the position a comes before b.
+func positionBefore(a, b lsp.Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
@@ -0,0 +1,103 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestFormatting(t *testing.T) {
+	type testcase struct {
+		name    string
+		content string
+		rng     *lsp.Range
+		want    []lsp.TextEdit
+	}
+
+	lines := func(start, end uint32) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: start},
+			End:   lsp.Position{Line: end},
+		}
+	}
+
+	unformatted := "globals {\n  a = 1\n  bbb = 2\n}\n\nstack {\n  name   = \"x\"\n}\n"
+
+	for _, tc := range []testcase{
+		{
+			name:    "formatted document",
+			content: "globals {\n  a   = 1\n  bbb = 2\n}\n",
+			want:    []lsp.TextEdit{},
+		},
+		{
+			name:    "only the changed lines are edited",
+			content: unformatted,
+			want: []lsp.TextEdit{
+				{Range: lines(1, 2), NewText: "  a   = 1\n"},
+				{Range: lines(6, 7), NewText: "  name = \"x\"\n"},
+			},
+		},
+		{
+			name:    "indentation and lines without trailing newline",
+			content: "stack {\nname = \"x\"\n}",
+			want: []lsp.TextEdit{
+				{Range: lines(1, 2), NewText: "  name = \"x\"\n"},
+			},
+		},
+		{
+			name:    "syntax error",
+			content: "globals {\n  a =    \n",
+		},
+		{
+			name:    "range formatting",
+			content: unformatted,
+			rng: &lsp.Range{
+				Start: lsp.Position{Line: 6, Character: 0},
+				End:   lsp.Position{Line: 6, Character: 5},
+			},
+			want: []lsp.TextEdit{
+				{Range: lines(6, 7), NewText: "  name = \"x\"\n"},
+			},
+		},
+		{
+			name:    "range formatting of formatted lines",
+			content: unformatted,
+			rng: &lsp.Range{
+				Start: lsp.Position{Line: 3, Character: 0},
+				End:   lsp.Position{Line: 5, Character: 3},
+			},
+			want: []lsp.TextEdit{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, "f:file.tm:"+tc.content)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			var got []lsp.TextEdit
+			if tc.rng != nil {
+				got = f.Editor.RangeFormatting("file.tm", *tc.rng)
+			} else {
+				got = f.Editor.Formatting("file.tm")
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("edits mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"path"
+	"path/filepath"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/generate"
+	"github.com/mineiros-io/terramate/generate/genfile"
+	"github.com/mineiros-io/terramate/hcl/eval"
+	"github.com/mineiros-io/terramate/project"
+	lsp "go.lsp.dev/protocol"
+)
+
+// defaultVendorDir is the vendor directory of projects not configuring one.
+const defaultVendorDir = "/modules"
+
+// vendorDir returns the vendor directory of the project, used by tm_vendor.
+func (p *projectState) vendorDir() project.Path {
+	vendor := p.root.Tree().Node.Vendor
+	if vendor != nil && vendor.Dir != "" {
+		return project.NewPath(vendor.Dir)
+	}
+	return project.NewPath(defaultVendorDir)
+}
+
+// generatedFiles returns the files generated for each stack of the project, by
+// stack path. The files of generate blocks with a false condition are not
+// included. Stacks failing to generate code are not included either.
+func (p *projectState) generatedFiles() map[project.Path][]generate.GenFile {
+	results, err := generate.Load(p.root, p.vendorDir())
+	if err != nil {
+		return nil
+	}
+
+	files := map[project.Path][]generate.GenFile{}
+	for _, res := range results {
+		if res.Err != nil {
+			continue
+		}
+		if _, ok := p.stackAt(p.hostPath(res.Dir)); !ok {
+			continue
+		}
+		list := []generate.GenFile{}
+		for _, file := range res.Files {
+			if file.Condition() {
+				list = append(list, file)
+			}
+		}
+		files[res.Dir] = list
+	}
+	return files
+}
+
+// generateEdits adds to edits the file creations, changes and deletions which
+// make the generated code inside the project directory dir up to date, as
+// `terramate generate` does. This includes the code of the stacks inside dir
+// and the files generated with root context into dir. The buffers of opened
+// files are compared instead of their saved content. The stacks failing to
+// generate code are reported in the returned error and have no edits.
+func (s *Server) generateEdits(p *projectState, dir project.Path, edits *fileEdits, prog *progress) error {
+	results, err := generate.Load(p.root, p.vendorDir())
+	if err != nil {
+		return err
+	}
+
+	var stackResults []generate.LoadResult
+	for _, res := range results {
+		if !isParentOrSelf(dir, res.Dir) {
+			continue
+		}
+		if _, ok := p.stackAt(p.hostPath(res.Dir)); ok {
+			stackResults = append(stackResults, res)
+		}
+	}
+
+	errs := errors.L()
+	for i, res := range stackResults {
+		prog.report(res.Dir.String(), uint32(i*100/len(stackResults)))
+		if res.Err != nil {
+			errs.Append(errors.E(res.Err, "generating code for stack %s", res.Dir))
+			continue
+		}
+		if err := s.stackGenerateEdits(p, res, edits); err != nil {
+			errs.Append(errors.E(err, "generating code for stack %s", res.Dir))
+		}
+	}
+	errs.Append(s.rootGenerateEdits(p, dir, edits))
+	return errs.AsError()
+}
+
+// rootGenFiles evaluates the generate_file blocks with root context which
+// generate files into the project directory dir, including the ones with a
+// false condition.
+func (p *projectState) rootGenFiles(dir project.Path) ([]generate.GenFile, error) {
+	evalctx, err := eval.NewContext(p.rootdir)
+	if err != nil {
+		return nil, err
+	}
+	evalctx.SetNamespace("terramate", p.metadata.ToCtyMap())
+
+	var files []generate.GenFile
+	errs := errors.L()
+	for _, cfg := range p.root.Tree().AsList() {
+		if cfg.IsEmptyConfig() || cfg.IsStack() {
+			continue
+		}
+		for _, block := range cfg.Node.Generate.Files {
+			if block.Context != genfile.RootContext {
+				continue
+			}
+			if !path.IsAbs(block.Label) {
+				errs.Append(errors.E(generate.ErrInvalidGenBlockLabel, block.Range,
+					"%s: is not an absolute path", block.Label))
+				continue
+			}
+			target := project.NewPath(block.Label)
+			if !isParentOrSelf(dir, target.Dir()) {
+				continue
+			}
+			for _, st := range p.stacks {
+				if isParentOrSelf(st.Path(), target.Dir()) {
+					errs.Append(errors.E(generate.ErrInvalidGenBlockLabel, block.Range,
+						"%s: generates code inside stack %s", block.Label, st.Path()))
+				}
+			}
+
+			file, err := genfile.Eval(block, evalctx)
+			if err != nil {
+				errs.Append(err)
+				continue
+			}
+			files = append(files, file)
+		}
+	}
+	return files, errs.AsError()
+}
+
+// rootGenerateEdits adds to edits the changes of the files generated with
+// root context into the project directory dir. The files of blocks with a
+// false condition are deleted. No edits are added if any block is invalid.
+func (s *Server) rootGenerateEdits(p *projectState, dir project.Path, edits *fileEdits) error {
+	genfiles, err := p.rootGenFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	files := map[project.Path]generate.GenFile{}
+	removed := map[project.Path]bool{}
+	for _, file := range genfiles {
+		target := project.NewPath(file.Label())
+		if !file.Condition() {
+			removed[target] = true
+			continue
+		}
+		if _, ok := files[target]; ok {
+			return errors.E(generate.ErrConflictingConfig,
+				"multiple generate_file blocks for %s", target)
+		}
+		files[target] = file
+	}
+
+	for target, file := range files {
+		fname := p.hostPath(target)
+		code := file.Header() + file.Body()
+		content, err := s.documents.read(fname)
+		if err != nil {
+			edits.create(fname)
+			edits.add(fname, lsp.TextEdit{NewText: code})
+			continue
+		}
+		if string(content) != code {
+			edits.add(fname, lsp.TextEdit{
+				Range: lsp.Range{
+					End: positionFor(content, len(content)),
+				},
+				NewText: code,
+			})
+		}
+	}
+	for target := range removed {
+		if _, ok := files[target]; ok {
+			continue
+		}
+		fname := p.hostPath(target)
+		if _, err := s.documents.read(fname); err == nil {
+			edits.delete(fname)
+		}
+	}
+	return nil
+}
+
+// stackGenerateEdits adds to edits the changes of the generated files of a
+// stack. No edits are added if any file would overwrite code not generated by
+// Terramate, which is any existing file not listed as generated. The files
+// generated by generate_file blocks have no header, so they are only kept if
+// they are up to date.
+func (s *Server) stackGenerateEdits(p *projectState, res generate.LoadResult, edits *fileEdits) error {
+	stackdir := p.hostPath(res.Dir)
+	oldFiles, err := generate.ListGenFiles(p.root, stackdir)
+	if err != nil {
+		return err
+	}
+	removed := map[string]bool{}
+	for _, name := range oldFiles {
+		removed[filepath.Join(stackdir, filepath.FromSlash(name))] = true
+	}
+
+	stackEdits := newFileEdits()
+	for _, file := range res.Files {
+		if !file.Condition() {
+			continue
+		}
+		target := filepath.Join(stackdir, filepath.FromSlash(file.Label()))
+		code := file.Header() + file.Body()
+		content, err := s.documents.read(target)
+		if err != nil {
+			stackEdits.create(target)
+			stackEdits.add(target, lsp.TextEdit{NewText: code})
+			continue
+		}
+		if !removed[target] && string(content) != code {
+			return errors.E(generate.ErrManualCodeExists, "check file %q", target)
+		}
+		delete(removed, target)
+		if string(content) != code {
+			stackEdits.add(target, lsp.TextEdit{
+				Range: lsp.Range{
+					End: positionFor(content, len(content)),
+				},
+				NewText: code,
+			})
+		}
+	}
+	for target := range removed {
+		stackEdits.delete(target)
+	}
+
+	for target := range stackEdits.created {
+		edits.create(target)
+	}
+	for target := range stackEdits.deleted {
+		edits.delete(target)
+	}
+	for target, list := range stackEdits.edits {
+		for _, edit := range list {
+			edits.add(target, edit)
+		}
+	}
+	return nil
+}
+
+// outdatedCodeProblem returns the problem of the stack block, at the host
+// directory dir, if `terramate generate` would change the generated files of
+// the stack. The fix generates the code of the stack.
+func (s *Server) outdatedCodeProblem(p *projectState, dir string, block *hclsyntax.Block) (problem, bool) {
+	st, ok := p.stackAt(dir)
+	if !ok {
+		return problem{}, false
+	}
+	results, err := generate.Load(p.root, p.vendorDir())
+	if err != nil {
+		return problem{}, false
+	}
+	for _, res := range results {
+		if res.Dir != st.Path() || res.Err != nil {
+			continue
+		}
+		edits := newFileEdits()
+		if err := s.stackGenerateEdits(p, res, edits); err != nil || edits.empty() {
+			return problem{}, false
+		}
+		return problem{
+			code:     codeOutdatedCode,
+			rng:      lspRange(block.TypeRange),
+			severity: lsp.DiagnosticSeverityWarning,
+			message:  "the generated code is outdated, run `terramate generate`",
+			fix: &quickFix{
+				title: "Generate code",
+				edit:  edits.workspaceEdit(),
+			},
+		}, true
+	}
+	return problem{}, false
+}
@@ -0,0 +1,224 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/mineiros-io/terramate/globals"
+	"github.com/mineiros-io/terramate/hcl/eval"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// globalDefinition is the definition of a global inside a globals block.
+type globalDefinition struct {
+	// path is the global accessor path, eg.: [a b] for global.a.b.
+	path []string
+
+	// dir is the configuration directory defining the global.
+	dir project.Path
+
+	// nameRange is the range of the attribute name (or map label).
+	nameRange hhcl.Range
+
+	// rng is the range of the whole definition.
+	rng hhcl.Range
+
+	// expr is the expression of the attribute definition.
+	// It is nil for globals defined with map blocks.
+	expr hhcl.Expression
+}
+
+// name returns the global reference name, eg.: global.a.b.
+func (def globalDefinition) name() string {
+	return "global." + strings.Join(def.path, ".")
+}
+
+// globalDefinitions returns the definitions of the global accessor path which
+// are visible from the host directory dir. A definition matches if it defines
+// the accessor path, any of its parent objects or any of its children.
+// The definitions are ordered from the most specific directory to the
+// project root, which means the first definition is the one that wins.
+func (p *projectState) globalDefinitions(dir string, path []string) []globalDefinition {
+	var defs []globalDefinition
+	cfgdir := project.PrjAbsPath(p.rootdir, dir)
+	for {
+		for _, def := range p.dirGlobals(cfgdir) {
+			if isPathPrefix(def.path, path) || isPathPrefix(path, def.path) {
+				defs = append(defs, def)
+			}
+		}
+
+		parent := cfgdir.Dir()
+		if parent == cfgdir {
+			break
+		}
+		cfgdir = parent
+	}
+	return defs
+}
+
+// effectiveGlobal returns the definition of the global path that wins when
+// evaluated at the host directory dir. If the path is not defined by itself,
+// the definition of its nearest parent object is returned instead.
+func (p *projectState) effectiveGlobal(dir string, path []string) (globalDefinition, bool) {
+	var parent *globalDefinition
+	for _, def := range p.globalDefinitions(dir, path) {
+		if equalPaths(def.path, path) {
+			return def, true
+		}
+		if parent == nil && isPathPrefix(def.path, path) {
+			def := def
+			parent = &def
+		}
+	}
+	if parent != nil {
+		return *parent, true
+	}
+	return globalDefinition{}, false
+}
+
+// sameAs tells if both definitions are the same attribute in the same file.
+func (def globalDefinition) sameAs(other globalDefinition) bool {
+	return def.nameRange.Filename == other.nameRange.Filename &&
+		def.nameRange.Start.Byte == other.nameRange.Start.Byte
+}
+
+// definedAt returns the project path and line of the definition, eg.:
+// /globals.tm:2.
+func (p *projectState) definedAt(def globalDefinition) string {
+	file := project.PrjAbsPath(p.rootdir, def.nameRange.Filename)
+	return fmt.Sprintf("%s:%d", file, def.nameRange.Start.Line)
+}
+
+// dirGlobals returns all the globals defined in the configuration directory.
+// The definitions are sorted by the global path.
+func (p *projectState) dirGlobals(cfgdir project.Path) []globalDefinition {
+	node, ok := p.root.Lookup(cfgdir)
+	if !ok {
+		return nil
+	}
+
+	var defs []globalDefinition
+	for _, block := range node.Node.Globals.AsList() {
+		for _, attr := range block.Attributes {
+			defs = append(defs, globalDefinition{
+				path:      append(append([]string{}, block.Labels...), attr.Name),
+				dir:       cfgdir,
+				nameRange: attr.NameRange,
+				rng:       attr.Attribute.Range,
+				expr:      attr.Expr,
+			})
+		}
+		for _, mapBlock := range block.Blocks {
+			raw := mapBlock.RawOrigins[0]
+			defs = append(defs, globalDefinition{
+				path:      append(append([]string{}, block.Labels...), mapBlock.Labels[0]),
+				dir:       cfgdir,
+				nameRange: raw.LabelRanges[0],
+				rng:       raw.Block.Range(),
+			})
+		}
+	}
+
+	sort.Slice(defs, func(i, j int) bool {
+		return strings.Join(defs[i].path, ".") < strings.Join(defs[j].path, ".")
+	})
+	return defs
+}
+
+// isPathPrefix tells if prefix is a prefix of path.
+func isPathPrefix(prefix []string, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i, name := range prefix {
+		if path[i] != name {
+			return false
+		}
+	}
+	return true
+}
+
+func equalPaths(a []string, b []string) bool {
+	return len(a) == len(b) && isPathPrefix(a, b)
+}
+
+// globalAt returns the global accessor path at the byte offset, which can be
+// a global.<path> reference or the name of an attribute inside a globals block.
+// The returned range is the range of the reference or attribute name.
+func globalAt(body *hclsyntax.Body, offset int) ([]string, hhcl.Range, bool) {
+	if expr, ok := traversalAt(body, offset); ok {
+		if expr.Traversal.RootName() != "global" {
+			return nil, hhcl.Range{}, false
+		}
+		path := traversalPath(expr.Traversal)
+		if len(path) == 0 {
+			return nil, hhcl.Range{}, false
+		}
+		return path, expr.SrcRange, true
+	}
+
+	blocks := blocksAt(body, offset)
+	if len(blocks) == 0 || blocks[len(blocks)-1].Type != "globals" {
+		return nil, hhcl.Range{}, false
+	}
+
+	block := blocks[len(blocks)-1]
+	for _, attr := range block.Body.Attributes {
+		if containsOffset(attr.NameRange, offset) {
+			path := append(append([]string{}, block.Labels...), attr.Name)
+			return path, attr.NameRange, true
+		}
+	}
+	return nil, hhcl.Range{}, false
+}
+
+// globalValue returns the evaluated global at the accessor path and the
+// information about where it was defined.
+func globalValue(report globals.EvalReport, path []string) (cty.Value, eval.Info, bool) {
+	if report.Globals == nil {
+		return cty.NilVal, eval.Info{}, false
+	}
+
+	v, ok := report.Globals.GetKeyPath(path)
+	if !ok {
+		return cty.NilVal, eval.Info{}, false
+	}
+
+	switch val := v.(type) {
+	case eval.CtyValue:
+		return val.Raw(), val.Info(), true
+	case *eval.Object:
+		return cty.ObjectVal(val.AsValueMap()), val.Info(), true
+	default:
+		return cty.NilVal, eval.Info{}, false
+	}
+}
+
+// formatValue formats the value as HCL code.
+func formatValue(val cty.Value) string {
+	tokens, err := eval.TokensForValue(val)
+	if err != nil {
+		return val.GoString()
+	}
+	return string(hclwrite.Format(tokens.Bytes()))
+}
@@ -0,0 +1,164 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleHover(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.HoverParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	hover, err := s.hover(fname, params.Position)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to compute hover")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, hover, nil)
+}
+
+// hover returns the Markdown documentation of the symbol at the given
+// position of the file or nil if there's nothing to show.
+func (s *Server) hover(fname string, pos lsp.Position) (*lsp.Hover, error) {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(fname)
+	offset := offsetFor(content, pos)
+	body := parseBody(fname, content)
+
+	var (
+		doc string
+		rng hhcl.Range
+	)
+
+	if call, ok := funcCallAt(body, offset); ok {
+		doc, _ = functionDoc(dir, call.Name)
+		rng = call.NameRange
+	} else if path, r, ok := globalAt(body, offset); ok {
+		p, err := s.loadProject(dir)
+		if err != nil {
+			return nil, err
+		}
+		doc = globalHover(p, dir, path)
+		rng = r
+	} else if expr, ok := traversalAt(body, offset); ok && expr.Traversal.RootName() == "terramate" {
+		p, err := s.loadProject(dir)
+		if err != nil {
+			return nil, err
+		}
+		doc = metadataHover(p, dir, content, expr)
+		rng = expr.SrcRange
+	} else if blocks := blocksAt(body, offset); len(blocks) > 0 {
+		block := blocks[len(blocks)-1]
+		if containsOffset(block.TypeRange, offset) {
+			if blockDoc, ok := blockDocs[block.Type]; ok {
+				doc = fmt.Sprintf("```hcl\n%s\n```\n\n%s", block.Type, blockDoc)
+				rng = block.TypeRange
+			}
+		}
+	}
+
+	if doc == "" {
+		return nil, nil
+	}
+
+	return &lsp.Hover{
+		Contents: lsp.MarkupContent{
+			Kind:  lsp.Markdown,
+			Value: doc,
+		},
+		Range: &lsp.Range{
+			Start: positionFor(content, rng.Start.Byte),
+			End:   positionFor(content, rng.End.Byte),
+		},
+	}, nil
+}
+
+// globalHover documents the evaluated value of the global path for the
+// directory dir and all the definitions of it, from the most specific
+// directory to the root. The definition providing the value is highlighted.
+func globalHover(p *projectState, dir string, path []string) string {
+	name := "global." + strings.Join(path, ".")
+	report := p.globalsFor(dir)
+	val, info, hasValue := globalValue(report, path)
+
+	var doc strings.Builder
+	if hasValue {
+		fmt.Fprintf(&doc, "```hcl\n%s = %s\n```\n", name, formatValue(val))
+	} else {
+		fmt.Fprintf(&doc, "```hcl\n%s\n```\n\n_The global could not be evaluated._\n", name)
+	}
+
+	defs := p.globalDefinitions(dir, path)
+	if len(defs) == 0 {
+		return doc.String()
+	}
+
+	doc.WriteString("\nDefinitions, from the most specific:\n\n")
+	effectiveFound := false
+	for _, def := range defs {
+		file := project.PrjAbsPath(p.rootdir, def.nameRange.Filename)
+		fmt.Fprintf(&doc, "- `%s` at `%s`", def.name(), p.definedAt(def))
+		if hasValue && !effectiveFound && equalPaths(path, def.path) && info.DefinedAt == file {
+			effectiveFound = true
+			doc.WriteString(" (effective)")
+		}
+		doc.WriteString("\n")
+	}
+	return doc.String()
+}
+
+// metadataHover documents the evaluated value of the terramate metadata
+// traversal for the directory dir.
+func metadataHover(p *projectState, dir string, content []byte, expr *hclsyntax.ScopeTraversalExpr) string {
+	name := string(content[expr.SrcRange.Start.Byte:expr.SrcRange.End.Byte])
+	val, diags := expr.Traversal.TraverseAbs(&hhcl.EvalContext{
+		Variables: map[string]cty.Value{
+			"terramate": cty.ObjectVal(p.metadataFor(dir)),
+		},
+	})
+	if diags.HasErrors() {
+		if _, ok := p.stackAt(dir); !ok && strings.HasPrefix(name, "terramate.stack") {
+			return fmt.Sprintf("```hcl\n%s\n```\n\n_Stack metadata is only available inside stacks._", name)
+		}
+		return fmt.Sprintf("```hcl\n%s\n```\n\n_%s_", name, diags[0].Summary)
+	}
+	return fmt.Sprintf("```hcl\n%s = %s\n```", name, formatValue(val))
+}
@@ -0,0 +1,211 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestHover(t *testing.T) {
+	type testcase struct {
+		name      string
+		layout    []string
+		file      string
+		pos       lsp.Position
+		want      []string
+		wantRange lsp.Range
+		wantNil   bool
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "global reference overridden by the stack",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/globals.tm:globals {\n  region = \"eu-west-1\"\n}",
+				"f:stack/stack.tm:stack {}\nglobals {\n  description = global.region\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 24},
+			want: []string{
+				"```hcl\nglobal.region = \"eu-west-1\"\n```",
+				"- `global.region` at `/stack/globals.tm:2` (effective)\n" +
+					"- `global.region` at `/globals.tm:2`\n",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 2, Character: 16},
+				End:   lsp.Position{Line: 2, Character: 29},
+			},
+		},
+		{
+			name: "global reference inside generate_hcl content",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/stack.tm:stack {}\ngenerate_hcl \"file.tf\" {\n  content {\n    r = global.region\n  }\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 3, Character: 12},
+			want: []string{
+				"```hcl\nglobal.region = \"us-east-1\"\n```",
+				"- `global.region` at `/globals.tm:2` (effective)\n",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 3, Character: 8},
+				End:   lsp.Position{Line: 3, Character: 21},
+			},
+		},
+		{
+			name: "global definition name",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals \"obj\" {\n  a = 1\n}",
+				"f:stack/stack.tm:stack {}",
+			},
+			file: "globals.tm",
+			pos:  lsp.Position{Line: 1, Character: 2},
+			want: []string{
+				"```hcl\nglobal.obj.a = 1\n```",
+				"- `global.obj.a` at `/globals.tm:2` (effective)\n",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 1, Character: 2},
+				End:   lsp.Position{Line: 1, Character: 3},
+			},
+		},
+		{
+			name: "undefined global",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nglobals {\n  description = global.undefined\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 24},
+			want: []string{
+				"_The global could not be evaluated._",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 2, Character: 16},
+				End:   lsp.Position{Line: 2, Character: 32},
+			},
+		},
+		{
+			name: "stack metadata",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {\n  name = \"my-stack\"\n}\nglobals {\n  name = terramate.stack.name\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 4, Character: 20},
+			want: []string{
+				"```hcl\nterramate.stack.name = \"my-stack\"\n```",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 4, Character: 9},
+				End:   lsp.Position{Line: 4, Character: 29},
+			},
+		},
+		{
+			name: "stack metadata outside of stack",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  name = terramate.stack.name\n}",
+			},
+			file: "globals.tm",
+			pos:  lsp.Position{Line: 1, Character: 20},
+			want: []string{
+				"_Stack metadata is only available inside stacks._",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 1, Character: 9},
+				End:   lsp.Position{Line: 1, Character: 29},
+			},
+		},
+		{
+			name: "function call",
+			layout: []string{
+				"f:globals.tm:globals {\n  a = tm_upper(\"a\")\n}",
+			},
+			file: "globals.tm",
+			pos:  lsp.Position{Line: 1, Character: 7},
+			want: []string{
+				"```hcl\ntm_upper(str string)\n```",
+				"Terraform [`upper`]",
+			},
+			wantRange: lsp.Range{
+				Start: lsp.Position{Line: 1, Character: 6},
+				End:   lsp.Position{Line: 1, Character: 14},
+			},
+		},
+		{
+			name: "block keyword",
+			layout: []string{
+				"f:stack.tm:stack {\n}",
+			},
+			file: "stack.tm",
+			pos:  lsp.Position{Line: 0, Character: 2},
+			want: []string{
+				"```hcl\nstack\n```",
+				"Marks the directory as a stack.",
+			},
+			wantRange: lsp.Range{
+				End: lsp.Position{Line: 0, Character: 5},
+			},
+		},
+		{
+			name: "nothing to show",
+			layout: []string{
+				"f:stack.tm:stack {\n  name = \"test\"\n}",
+			},
+			file:    "stack.tm",
+			pos:     lsp.Position{Line: 1, Character: 11},
+			wantNil: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			got := f.Editor.Hover(tc.file, tc.pos)
+			if tc.wantNil {
+				if got != nil {
+					t.Fatalf("unexpected hover: %v", got.Contents)
+				}
+				return
+			}
+
+			if got == nil {
+				t.Fatal("expected hover but got nil")
+			}
+
+			assert.EqualStrings(t, string(lsp.Markdown), string(got.Contents.Kind))
+			for _, want := range tc.want {
+				if !strings.Contains(got.Contents.Value, want) {
+					t.Errorf("hover %q does not contain %q", got.Contents.Value, want)
+				}
+			}
+			assert.IsTrue(t, got.Range != nil, "hover range is nil")
+			if *got.Range != tc.wantRange {
+				t.Fatalf("hover range got %v != want %v", *got.Range, tc.wantRange)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/config"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog/log"
+)
+
+// symbolIndex is a workspace wide index of the symbols of a Terramate project.
+// The index is built from the syntax of the files only, so it can be kept up
+// to date at every change without evaluating the configuration.
+type symbolIndex struct {
+	rootdir string
+	built   sync.Once
+
+	// cfg are the settings of the project when the index was created. The
+	// indexes are dropped when the settings change and the index is built
+	// again when the excludes of the project configuration change.
+	cfg settings
+
+	mu    sync.Mutex
+	files map[string]*fileSymbols
+}
+
+// fileSymbols are the symbols found in a single file.
+type fileSymbols struct {
+	globalRefs []globalRef
+	pathRefs   []pathRef
+
+	stacks     []stackSymbol
+	globalDefs []globalSymbol
+	generates  []generateSymbol
+}
+
+// stackSymbol is a stack block.
+type stackSymbol struct {
+	name string
+	id   string
+	rng  hhcl.Range
+}
+
+// globalSymbol is a global defined inside a globals block.
+type globalSymbol struct {
+	path      []string
+	nameRange hhcl.Range
+}
+
+// generateSymbol is a generate_hcl or generate_file block.
+type generateSymbol struct {
+	block string
+	label string
+	rng   hhcl.Range
+}
+
+// globalRef is a global.<path> reference.
+type globalRef struct {
+	path []string
+	rng  hhcl.Range
+
+	// nameRanges are the ranges of each name of the path.
+	nameRanges []hhcl.Range
+}
+
+// pathRef is a path inside the after, before, wants, wanted_by or watch
+// attributes of a stack block or inside the source of an import block.
+type pathRef struct {
+	// block is the type of the block containing the reference.
+	block string
+
+	// attr is the name of the attribute containing the reference.
+	attr string
+
+	// value is the path as written in the configuration.
+	value string
+
+	// target is the referenced file or directory.
+	target project.Path
+
+	// rng is the range of the string, including the quotes.
+	rng hhcl.Range
+}
+
+// index returns the symbol index of the project rootdir, building it if
+// needed. The settings are checked at every call, as the editor may not watch
+// the project configuration file, which is only loaded again when its
+// modification time or size change.
+func (s *Server) index(rootdir string) *symbolIndex {
+	cfg := s.settingsFor(rootdir)
+
+	s.indexesMu.Lock()
+	idx, ok := s.indexes[rootdir]
+	if !ok || !equalPaths(idx.cfg.Ignore, cfg.Ignore) {
+		idx = &symbolIndex{
+			rootdir: rootdir,
+			cfg:     cfg,
+			files:   map[string]*fileSymbols{},
+		}
+		s.indexes[rootdir] = idx
+	}
+	s.indexesMu.Unlock()
+
+	idx.built.Do(func() {
+		idx.build(s.documents, rootdir)
+	})
+	return idx
+}
+
+// reindexFile updates the symbols of fname in all the indexes containing it.
+func (s *Server) reindexFile(fname string) {
+	s.indexesMu.Lock()
+	var indexes []*symbolIndex
+	for rootdir, idx := range s.indexes {
+		if strings.HasPrefix(fname, rootdir+string(filepath.Separator)) {
+			indexes = append(indexes, idx)
+		}
+	}
+	s.indexesMu.Unlock()
+
+	for _, idx := range indexes {
+		idx.indexFile(s.documents, fname)
+	}
+	s.dropHints()
+}
+
+// dropIndexes drops all the indexes, so they are built again when needed.
+func (s *Server) dropIndexes() {
+	s.indexesMu.Lock()
+	defer s.indexesMu.Unlock()
+
+	s.indexes = map[string]*symbolIndex{}
+	s.dropHints()
+}
+
+// dropIndexesIn drops the indexes of the projects inside the host directory
+// dir, including the project rooted at dir.
+func (s *Server) dropIndexesIn(dir string) {
+	s.indexesMu.Lock()
+	defer s.indexesMu.Unlock()
+
+	for rootdir := range s.indexes {
+		if rootdir == dir || strings.HasPrefix(rootdir, dir+string(filepath.Separator)) {
+			delete(s.indexes, rootdir)
+		}
+	}
+	s.dropHints()
+}
+
+func (idx *symbolIndex) build(docs *documents, dir string) {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Debug().Err(err).Str("dir", dir).Msg("indexing directory")
+		return
+	}
+
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name() == config.SkipFilename {
+			return
+		}
+	}
+
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if config.Skip(name) {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		if idx.cfg.ignored(project.PrjAbsPath(idx.rootdir, path)) {
+			continue
+		}
+		if dirEntry.IsDir() {
+			idx.build(docs, path)
+		} else if isTerramateFile(name) {
+			idx.indexFile(docs, path)
+		}
+	}
+}
+
+// indexFile parses fname and replaces its symbols in the index.
+// If the file does not exist anymore or is ignored its symbols are removed.
+func (idx *symbolIndex) indexFile(docs *documents, fname string) {
+	content, err := docs.read(fname)
+	if err != nil || idx.cfg.ignored(project.PrjAbsPath(idx.rootdir, fname)) {
+		idx.mu.Lock()
+		delete(idx.files, fname)
+		idx.mu.Unlock()
+		return
+	}
+
+	symbols := &fileSymbols{}
+	body := parseBody(fname, content)
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hhcl.Diagnostics {
+		expr, ok := node.(*hclsyntax.ScopeTraversalExpr)
+		if !ok || expr.Traversal.RootName() != "global" {
+			return nil
+		}
+		if path := traversalPath(expr.Traversal); len(path) > 0 {
+			symbols.globalRefs = append(symbols.globalRefs, globalRef{
+				path:       path,
+				rng:        expr.SrcRange,
+				nameRanges: traversalNameRanges(expr.Traversal),
+			})
+		}
+		return nil
+	})
+
+	dir := filepath.Dir(fname)
+	addPathRef := func(block, attr string, expr hclsyntax.Expression) {
+		value, ok := stringLiteral(expr)
+		if !ok {
+			return
+		}
+		symbols.pathRefs = append(symbols.pathRefs, pathRef{
+			block:  block,
+			attr:   attr,
+			value:  value,
+			target: project.PrjAbsPath(idx.rootdir, resolvePath(idx.rootdir, dir, value)),
+			rng:    expr.Range(),
+		})
+	}
+
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "globals":
+			for _, attr := range sortedAttributes(block.Body.Attributes) {
+				symbols.globalDefs = append(symbols.globalDefs, globalSymbol{
+					path:      append(append([]string{}, block.Labels...), attr.Name),
+					nameRange: attr.NameRange,
+				})
+			}
+		case "generate_hcl", "generate_file":
+			if len(block.Labels) == 1 {
+				symbols.generates = append(symbols.generates, generateSymbol{
+					block: block.Type,
+					label: block.Labels[0],
+					rng:   block.LabelRanges[0],
+				})
+			}
+		case "import":
+			if attr, ok := block.Body.Attributes["source"]; ok {
+				addPathRef(block.Type, attr.Name, attr.Expr)
+			}
+		case "stack":
+			symbols.stacks = append(symbols.stacks, newStackSymbol(block))
+			for _, attr := range sortedAttributes(block.Body.Attributes) {
+				if !isStackReference(attr.Name) && attr.Name != "watch" {
+					continue
+				}
+				tuple, ok := attr.Expr.(*hclsyntax.TupleConsExpr)
+				if !ok {
+					continue
+				}
+				for _, elem := range tuple.Exprs {
+					addPathRef(block.Type, attr.Name, elem)
+				}
+			}
+		}
+	}
+
+	idx.mu.Lock()
+	idx.files[fname] = symbols
+	idx.mu.Unlock()
+}
+
+func newStackSymbol(block *hclsyntax.Block) stackSymbol {
+	sym := stackSymbol{rng: block.TypeRange}
+	if attr, ok := block.Body.Attributes["name"]; ok {
+		sym.name, _ = stringLiteral(attr.Expr)
+	}
+	if attr, ok := block.Body.Attributes["id"]; ok {
+		sym.id, _ = stringLiteral(attr.Expr)
+	}
+	return sym
+}
+
+// forEach calls fn for each indexed file, sorted by filename.
+func (idx *symbolIndex) forEach(fn func(fname string, symbols *fileSymbols)) {
+	idx.mu.Lock()
+	files := make([]string, 0, len(idx.files))
+	for fname := range idx.files {
+		files = append(files, fname)
+	}
+	idx.mu.Unlock()
+
+	sort.Strings(files)
+	for _, fname := range files {
+		idx.mu.Lock()
+		symbols, ok := idx.files[fname]
+		idx.mu.Unlock()
+		if ok {
+			fn(fname, symbols)
+		}
+	}
+}
+
+// dirs returns the directories containing indexed files, sorted.
+func (idx *symbolIndex) dirs() []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	set := map[string]struct{}{}
+	for fname := range idx.files {
+		set[filepath.Dir(fname)] = struct{}{}
+	}
+	dirs := make([]string, 0, len(set))
+	for dir := range set {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
@@ -0,0 +1,327 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// methodTextDocumentInlayHint is missing in the protocol package.
+const methodTextDocumentInlayHint = "textDocument/inlayHint"
+
+// maxInlayHintLen is the maximum number of characters of the values shown in
+// the hints. Longer values are truncated and shown in full in the tooltip.
+const maxInlayHintLen = 40
+
+// maxHintStacks is the maximum number of stacks of a directory where its
+// references are evaluated, as evaluating the globals of all the stacks of a
+// large project is slow.
+const maxHintStacks = 10
+
+// maxStackHints is the maximum number of hints of a reference with different
+// values in the stacks. The values of the other stacks are summarized in a
+// single hint.
+const maxStackHints = 3
+
+// inlayHintParams are the parameters of the textDocument/inlayHint request.
+type inlayHintParams struct {
+	TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	Range        lsp.Range                  `json:"range"`
+}
+
+// inlayHint is a label shown by the editor inline with the code.
+type inlayHint struct {
+	Position    lsp.Position `json:"position"`
+	Label       string       `json:"label"`
+	Tooltip     string       `json:"tooltip,omitempty"`
+	PaddingLeft bool         `json:"paddingLeft,omitempty"`
+}
+
+// hintScope is the context where the references of a file are evaluated.
+type hintScope struct {
+	// stack is the path of the stack, which is empty when the references are
+	// evaluated in a directory without stacks.
+	stack project.Path
+	ctx   *hhcl.EvalContext
+}
+
+// hintScopes are the scopes of the files of a directory, with the project they
+// were evaluated in.
+type hintScopes struct {
+	project *projectState
+	scopes  []hintScope
+
+	// omitted is the number of stacks of the directory not evaluated, after
+	// the first maxHintStacks ones.
+	omitted int
+}
+
+func (s *Server) handleInlayHint(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params inlayHintParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, s.inlayHints(fname, content, params.Range), nil)
+}
+
+// inlayHints returns the hints inside the range of the file, which show the
+// values of the global and metadata references and which globals override a
+// definition of a parent directory.
+//
+// The references are evaluated for the stack of the file. If the file is not in
+// a stack, they are evaluated for the stacks inside its directory, with a
+// hint per stack, up to a limit, when the values differ.
+func (s *Server) inlayHints(fname string, content []byte, rng lsp.Range) []inlayHint {
+	hints := []inlayHint{}
+	dir := filepath.Dir(fname)
+	scopes, ok := s.hintScopes(dir)
+	if !ok {
+		return hints
+	}
+	p := scopes.project
+
+	start, end := offsetFor(content, rng.Start), offsetFor(content, rng.End)
+	inRange := func(offset int) bool {
+		return start <= offset && offset <= end
+	}
+
+	body := parseBody(fname, content)
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hhcl.Diagnostics {
+		expr, ok := node.(*hclsyntax.ScopeTraversalExpr)
+		if !ok || len(expr.Traversal) < 2 || !inRange(expr.SrcRange.End.Byte) {
+			return nil
+		}
+		switch expr.Traversal.RootName() {
+		case "global", "terramate":
+			pos := positionFor(content, expr.SrcRange.End.Byte)
+			hints = append(hints, valueHints(scopes, expr.Traversal, pos)...)
+		}
+		return nil
+	})
+
+	cfgdir := project.PrjAbsPath(p.rootdir, dir)
+	for _, block := range body.Blocks {
+		if block.Type != "globals" {
+			continue
+		}
+		for _, attr := range block.Body.Attributes {
+			if !inRange(attr.NameRange.End.Byte) {
+				continue
+			}
+			path := append(append([]string{}, block.Labels...), attr.Name)
+			for _, def := range p.globalDefinitions(dir, path) {
+				if def.dir != cfgdir && equalPaths(def.path, path) {
+					hints = append(hints, inlayHint{
+						Position:    positionFor(content, attr.NameRange.End.Byte),
+						Label:       "overrides " + p.definedAt(def),
+						PaddingLeft: true,
+					})
+					break
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(hints, func(i, j int) bool {
+		return positionBefore(hints[i].Position, hints[j].Position)
+	})
+	return hints
+}
+
+// hintScopes returns the scopes of the host directory dir, which are evaluated
+// once until a file changes, as the editor asks for the hints frequently.
+func (s *Server) hintScopes(dir string) (hintScopes, bool) {
+	s.hintsMu.Lock()
+	scopes, ok := s.hints[dir]
+	gen := s.hintsGen
+	s.hintsMu.Unlock()
+	if ok {
+		return scopes, true
+	}
+
+	p, err := s.loadProject(dir)
+	if err != nil {
+		return hintScopes{}, false
+	}
+	scopes = p.hintScopes(dir)
+
+	s.hintsMu.Lock()
+	if gen == s.hintsGen {
+		s.hints[dir] = scopes
+	}
+	s.hintsMu.Unlock()
+	return scopes, true
+}
+
+// dropHints drops the evaluated scopes of the inlay hints, after a file
+// changed.
+func (s *Server) dropHints() {
+	s.hintsMu.Lock()
+	defer s.hintsMu.Unlock()
+
+	s.hintsGen++
+	s.hints = map[string]hintScopes{}
+}
+
+// hintScopes returns the scopes where the references of the files of the host
+// directory dir are evaluated: the stack at dir, the first maxHintStacks stacks
+// inside dir or, if there are none, dir itself.
+func (p *projectState) hintScopes(dir string) hintScopes {
+	scopes := hintScopes{project: p}
+	if st, ok := p.stackAt(dir); ok {
+		scopes.scopes = []hintScope{{stack: st.Path(), ctx: p.evalContext(dir)}}
+		return scopes
+	}
+
+	cfgdir := project.PrjAbsPath(p.rootdir, dir)
+	for _, st := range p.stacks {
+		if !isParentOrSelf(cfgdir, st.Path()) {
+			continue
+		}
+		if len(scopes.scopes) == maxHintStacks {
+			scopes.omitted++
+			continue
+		}
+		scopes.scopes = append(scopes.scopes, hintScope{
+			stack: st.Path(),
+			ctx:   p.evalContext(p.hostPath(st.Path())),
+		})
+	}
+	if len(scopes.scopes) == 0 {
+		scopes.scopes = append(scopes.scopes, hintScope{ctx: p.evalContext(dir)})
+	}
+	return scopes
+}
+
+// evalContext returns the context with the globals and metadata of the host
+// directory dir.
+func (p *projectState) evalContext(dir string) *hhcl.EvalContext {
+	vars := map[string]cty.Value{
+		"terramate": cty.ObjectVal(p.metadataFor(dir)),
+	}
+	if report := p.globalsFor(dir); report.Globals != nil {
+		vars["global"] = cty.ObjectVal(report.Globals.AsValueMap())
+	}
+	return &hhcl.EvalContext{Variables: vars}
+}
+
+// valueHints returns the hints with the values of the traversal in the scopes,
+// which are a single hint if all the stacks have the same value. Otherwise
+// there is a hint for each of the first maxStackHints stacks and one counting
+// the other stacks. The scopes where the traversal cannot be evaluated are
+// ignored.
+func valueHints(scopes hintScopes, traversal hhcl.Traversal, pos lsp.Position) []inlayHint {
+	type result struct {
+		stack project.Path
+		val   cty.Value
+	}
+
+	var results []result
+	same := true
+	for _, scope := range scopes.scopes {
+		val, diags := traversal.TraverseAbs(scope.ctx)
+		if diags.HasErrors() || !val.IsWhollyKnown() {
+			continue
+		}
+		if len(results) > 0 && !val.RawEquals(results[0].val) {
+			same = false
+		}
+		results = append(results, result{stack: scope.stack, val: val})
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	if same && scopes.omitted == 0 {
+		return []inlayHint{valueHint(pos, "= ", results[0].val)}
+	}
+
+	var hints []inlayHint
+	for _, res := range results {
+		if len(hints) == maxStackHints {
+			break
+		}
+		hints = append(hints, valueHint(pos, res.stack.String()+": ", res.val))
+	}
+
+	others := len(results) - len(hints) + scopes.omitted
+	if others == 0 {
+		return hints
+	}
+	var tooltip []string
+	for _, res := range results[len(hints):] {
+		tooltip = append(tooltip, res.stack.String()+": "+singleLine(formatValue(res.val)))
+	}
+	if scopes.omitted > 0 {
+		tooltip = append(tooltip, fmt.Sprintf("%d stacks not evaluated", scopes.omitted))
+	}
+	return append(hints, inlayHint{
+		Position:    pos,
+		Label:       fmt.Sprintf("+%d stacks", others),
+		Tooltip:     strings.Join(tooltip, "\n"),
+		PaddingLeft: true,
+	})
+}
+
+// valueHint returns the hint with the value formatted in a single line after
+// the prefix.
+func valueHint(pos lsp.Position, prefix string, val cty.Value) inlayHint {
+	code := strings.TrimSpace(formatValue(val))
+	text := singleLine(code)
+	hint := inlayHint{
+		Position:    pos,
+		Label:       prefix + text,
+		PaddingLeft: true,
+	}
+	if runes := []rune(text); len(runes) > maxInlayHintLen {
+		hint.Label = prefix + string(runes[:maxInlayHintLen-1]) + "…"
+		hint.Tooltip = code
+	}
+	return hint
+}
+
+// singleLine joins the lines of the formatted code in a single line.
+func singleLine(code string) string {
+	lines := strings.Split(strings.TrimSpace(code), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.Join(lines, " ")
+}
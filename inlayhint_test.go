@@ -0,0 +1,193 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestInlayHints(t *testing.T) {
+	type testcase struct {
+		name   string
+		layout []string
+		file   string
+		rng    lsp.Range
+		want   []test.InlayHint
+	}
+
+	wholeFile := lsp.Range{End: lsp.Position{Line: 100}}
+	hint := func(line, char uint32, label string) test.InlayHint {
+		return test.InlayHint{
+			Position:    lsp.Position{Line: line, Character: char},
+			Label:       label,
+			PaddingLeft: true,
+		}
+	}
+	generate := func(attrs ...string) string {
+		return "generate_hcl \"a.tf\" {\n  content {\n    " +
+			strings.Join(attrs, "\n    ") + "\n  }\n}\n"
+	}
+	long := strings.Repeat("a", 50)
+
+	// the stacks have different values, only the first ones are evaluated.
+	manyStacks := []string{
+		"f:stacks/common.tm:" + generate("n = global.n"),
+	}
+	var tooltip []string
+	for i := 0; i < 12; i++ {
+		manyStacks = append(manyStacks,
+			fmt.Sprintf("f:stacks/s%02d/stack.tm:stack {}\n\nglobals {\n  n = %d\n}\n", i, i))
+		if i >= 3 && i < 10 {
+			tooltip = append(tooltip, fmt.Sprintf("/stacks/s%02d: %d", i, i))
+		}
+	}
+	tooltip = append(tooltip, "2 stacks not evaluated")
+
+	for _, tc := range []testcase{
+		{
+			name: "references and overrides in a stack",
+			layout: []string{
+				"f:globals.tm:globals {\n  env = \"prod\"\n}\n",
+				"f:stack/stack.tm:stack {\n  name = \"app\"\n}\n\n" +
+					"globals {\n  env = \"dev\"\n}\n\n" +
+					generate("env  = global.env", "name = terramate.stack.name"),
+			},
+			file: "stack/stack.tm",
+			rng:  wholeFile,
+			want: []test.InlayHint{
+				hint(5, 5, "overrides /globals.tm:2"),
+				hint(10, 21, "= \"dev\""),
+				hint(11, 31, "= \"app\""),
+			},
+		},
+		{
+			name: "values of the stacks of a directory",
+			layout: []string{
+				"f:globals.tm:globals {\n  env  = \"prod\"\n  name = \"x\"\n}\n",
+				"f:stacks/a/stack.tm:stack {}\n",
+				"f:stacks/b/stack.tm:stack {}\n\nglobals {\n  env = \"dev\"\n}\n",
+				"f:stacks/common.tm:" + generate("env  = global.env", "name = global.name"),
+			},
+			file: "stacks/common.tm",
+			rng:  wholeFile,
+			want: []test.InlayHint{
+				hint(2, 21, "/stacks/a: \"prod\""),
+				hint(2, 21, "/stacks/b: \"dev\""),
+				hint(3, 22, "= \"x\""),
+			},
+		},
+		{
+			name:   "values of many stacks",
+			layout: manyStacks,
+			file:   "stacks/common.tm",
+			rng:    wholeFile,
+			want: []test.InlayHint{
+				hint(2, 16, "/stacks/s00: 0"),
+				hint(2, 16, "/stacks/s01: 1"),
+				hint(2, 16, "/stacks/s02: 2"),
+				{
+					Position:    lsp.Position{Line: 2, Character: 16},
+					Label:       "+9 stacks",
+					Tooltip:     strings.Join(tooltip, "\n"),
+					PaddingLeft: true,
+				},
+			},
+		},
+		{
+			name: "directory without stacks",
+			layout: []string{
+				"f:globals.tm:globals {\n  env = \"prod\"\n}\n",
+				"f:dir/globals.tm:globals {\n  copy = global.env\n  name = terramate.stack.name\n}\n",
+			},
+			file: "dir/globals.tm",
+			rng:  wholeFile,
+			want: []test.InlayHint{
+				hint(1, 19, "= \"prod\""),
+			},
+		},
+		{
+			name: "truncated values",
+			layout: []string{
+				"f:stack/stack.tm:stack {}\n\nglobals {\n  long = \"" + long + "\"\n}\n\n" +
+					generate("a = global.long"),
+			},
+			file: "stack/stack.tm",
+			rng:  wholeFile,
+			want: []test.InlayHint{
+				{
+					Position:    lsp.Position{Line: 8, Character: 19},
+					Label:       "= \"" + long[:38] + "…",
+					Tooltip:     "\"" + long + "\"",
+					PaddingLeft: true,
+				},
+			},
+		},
+		{
+			name: "hints in the range",
+			layout: []string{
+				"f:stack/stack.tm:stack {}\n\nglobals {\n  a = 1\n  b = 2\n}\n\n" +
+					generate("a = global.a", "b = global.b"),
+			},
+			file: "stack/stack.tm",
+			rng: lsp.Range{
+				Start: lsp.Position{Line: 10, Character: 0},
+				End:   lsp.Position{Line: 11, Character: 0},
+			},
+			want: []test.InlayHint{
+				hint(10, 16, "= 2"),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			got := f.Editor.InlayHints(tc.file, tc.rng)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Fatalf("hints mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestInlayHintsAfterChange(t *testing.T) {
+	f := test.Setup(t,
+		"f:stack/stack.tm:stack {}\n\nglobals {\n  a = 1\n}\n",
+		"f:stack/gen.tm:generate_hcl \"a.tf\" {\n  content {\n    a = global.a\n  }\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	wholeFile := lsp.Range{End: lsp.Position{Line: 100}}
+	got := f.Editor.InlayHints("stack/gen.tm", wholeFile)
+	if len(got) != 1 || got[0].Label != "= 1" {
+		t.Fatalf("want the value of the global, got %+v", got)
+	}
+
+	// the evaluated values are dropped when the files change.
+	f.Editor.Open("stack/stack.tm")
+	f.Editor.Change("stack/stack.tm", "stack {}\n\nglobals {\n  a = 2\n}\n")
+	drainRequests(f.Editor)
+
+	got = f.Editor.InlayHints("stack/gen.tm", wholeFile)
+	if len(got) != 1 || got[0].Label != "= 2" {
+		t.Fatalf("want the changed value of the global, got %+v", got)
+	}
+}
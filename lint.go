@@ -0,0 +1,529 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/agext/levenshtein"
+	"github.com/google/uuid"
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/hcl/eval"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog/log"
+	lsp "go.lsp.dev/protocol"
+)
+
+// Codes of the diagnostics reported by the language server itself, which the
+// quick fixes are tied to.
+const (
+	codeMissingStack      = "missing-stack"
+	codeInvalidStackID    = "invalid-stack-id"
+	codeDuplicatedStackID = "duplicated-stack-id"
+	codeDuplicatedGlobal  = "duplicated-global"
+	codeRelativeStackPath = "relative-stack-path"
+	codeMissingImport     = "missing-import"
+	codeUnknownFunction   = "unknown-function"
+	codeRunOrderCycle     = "run-order-cycle"
+	codeOutdatedCode      = "outdated-generated-code"
+	codeChangedStack      = "changed-stack"
+)
+
+// stackIDRegex is the format of the stack IDs accepted by Terramate.
+var stackIDRegex = regexp.MustCompile("^[a-zA-Z0-9_-]{1,64}$")
+
+// problem is an issue found in a Terramate file, with an optional fix.
+type problem struct {
+	code     string
+	rng      lsp.Range
+	severity lsp.DiagnosticSeverity
+	message  string
+	fix      *quickFix
+}
+
+// quickFix is the edit fixing a problem.
+type quickFix struct {
+	title string
+	edit  *workspaceEdit
+}
+
+// diagnostic returns the problem as a LSP diagnostic.
+func (p problem) diagnostic() lsp.Diagnostic {
+	return lsp.Diagnostic{
+		Range:    p.rng,
+		Severity: p.severity,
+		Code:     p.code,
+		Source:   "terramate",
+		Message:  p.message,
+	}
+}
+
+// addProblems adds the problems to the diagnostics. A problem reported at the
+// same range of an existing error, eg.: the invalid stack ID also reported by
+// the Terramate parser, only adds its code to the existing diagnostic.
+func addProblems(diags []lsp.Diagnostic, problems []problem) []lsp.Diagnostic {
+	for _, p := range problems {
+		merged := false
+		for i := range diags {
+			if diags[i].Code == nil && diags[i].Range == p.rng &&
+				p.severity == lsp.DiagnosticSeverityError {
+				diags[i].Code = p.code
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			diags = append(diags, p.diagnostic())
+		}
+	}
+	return diags
+}
+
+// check is the state shared by the problems of the files checked together.
+// The problems of the stacks depend on the whole project, which is loaded once
+// per check with its run order cycle, as loading them is expensive.
+type check struct {
+	s        *Server
+	projects map[string]*projectState
+	cycles   map[string]runOrderCycle
+}
+
+func (s *Server) newCheck() *check {
+	return &check{
+		s:        s,
+		projects: map[string]*projectState{},
+		cycles:   map[string]runOrderCycle{},
+	}
+}
+
+// project returns the project containing the host directory dir.
+func (c *check) project(dir string) (*projectState, bool) {
+	rootdir := c.s.projectRoot(dir)
+	p, ok := c.projects[rootdir]
+	if !ok {
+		p, _ = c.s.loadProject(dir)
+		c.projects[rootdir] = p
+	}
+	return p, p != nil
+}
+
+// cycle returns the run order cycle of the project, which is empty if the run
+// order has no cycles.
+func (c *check) cycle(p *projectState) runOrderCycle {
+	cycle, ok := c.cycles[p.rootdir]
+	if !ok {
+		cycle = p.runOrderCycle()
+		c.cycles[p.rootdir] = cycle
+	}
+	return cycle
+}
+
+// problems returns the problems found in the Terramate file, which are not
+// reported by the Terramate parser or are reported without enough information
+// to be fixed. The problems are reported as configured by the lint settings
+// and the project configuration.
+func (s *Server) problems(c *check, fname string) []problem {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Debug().Err(err).Str("file", fname).Msg("reading file for problems")
+		return nil
+	}
+
+	dir := filepath.Dir(fname)
+	rootdir := s.projectRoot(dir)
+	idx := s.index(rootdir)
+	body := parseBody(fname, content)
+	uri := fileURI(fname)
+
+	var problems []problem
+	replace := func(rng hhcl.Range, text string) *workspaceEdit {
+		return &workspaceEdit{
+			Changes: map[lsp.URI][]lsp.TextEdit{
+				uri: {{Range: lspRange(rng), NewText: text}},
+			},
+		}
+	}
+
+	cfg := s.settingsFor(rootdir)
+	hasStack := false
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "stack":
+			hasStack = true
+			problems = append(problems, stackProblems(idx, fname, block, replace)...)
+			prj, ok := c.project(dir)
+			if !ok {
+				continue
+			}
+			problems = append(problems, prj.cycleProblems(c.cycle(prj), dir, block)...)
+			if cfg.GenerateCheck {
+				if p, ok := s.outdatedCodeProblem(prj, dir, block); ok {
+					problems = append(problems, p)
+				}
+			}
+			if cfg.experimental(experimentalChangedStacks) {
+				if p, ok := s.changedStackProblem(prj, dir, block); ok {
+					problems = append(problems, p)
+				}
+			}
+		case "import":
+			if p, ok := importProblem(s.documents, rootdir, dir, block); ok {
+				problems = append(problems, p)
+			}
+		}
+	}
+	if !hasStack {
+		if p, ok := missingStackProblem(idx, fname, content); ok {
+			problems = append(problems, p)
+		}
+	}
+	problems = append(problems, duplicatedGlobals(idx, fname, content, body)...)
+
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hhcl.Diagnostics {
+		call, ok := node.(*hclsyntax.FunctionCallExpr)
+		if !ok || !strings.HasPrefix(call.Name, "tm_") || isFunction(call.Name) {
+			return nil
+		}
+		p := problem{
+			code:     codeUnknownFunction,
+			rng:      lspRange(call.NameRange),
+			severity: lsp.DiagnosticSeverityError,
+			message:  fmt.Sprintf("unknown function %q", call.Name),
+		}
+		if suggestion, ok := closestFunction(call.Name); ok {
+			p.message += fmt.Sprintf(", did you mean %q?", suggestion)
+			p.fix = &quickFix{
+				title: fmt.Sprintf("Change to %s", suggestion),
+				edit:  replace(call.NameRange, suggestion),
+			}
+		}
+		problems = append(problems, p)
+		return nil
+	})
+
+	problems = cfg.applyLint(problems)
+	sort.SliceStable(problems, func(i, j int) bool {
+		return positionBefore(problems[i].rng.Start, problems[j].rng.Start)
+	})
+	return problems
+}
+
+// stackProblems returns the problems of the stack block, which are invalid or
+// duplicated IDs and relative paths of stacks.
+func stackProblems(
+	idx *symbolIndex,
+	fname string,
+	block *hclsyntax.Block,
+	replace func(hhcl.Range, string) *workspaceEdit,
+) []problem {
+	var problems []problem
+	newID := func(rng hhcl.Range) *quickFix {
+		return &quickFix{
+			title: "Generate a new stack ID",
+			edit:  replace(rng, fmt.Sprintf("%q", uuid.NewString())),
+		}
+	}
+
+	if attr, ok := block.Body.Attributes["id"]; ok {
+		if id, ok := stringLiteral(attr.Expr); ok {
+			if !stackIDRegex.MatchString(id) {
+				// same range of the error reported by the Terramate parser.
+				problems = append(problems, problem{
+					code:     codeInvalidStackID,
+					rng:      lspRange(attr.NameRange),
+					severity: lsp.DiagnosticSeverityError,
+					message:  fmt.Sprintf("stack ID %q doesn't match %q", id, stackIDRegex),
+					fix:      newID(attr.Expr.Range()),
+				})
+			} else if other, ok := stackWithID(idx, fname, id); ok {
+				problems = append(problems, problem{
+					code:     codeDuplicatedStackID,
+					rng:      lspRange(attr.Expr.Range()),
+					severity: lsp.DiagnosticSeverityError,
+					message:  fmt.Sprintf("stack ID %q is also used by the stack at %s", id, other),
+					fix:      newID(attr.Expr.Range()),
+				})
+			}
+		}
+	}
+
+	dir := project.PrjAbsPath(idx.rootdir, filepath.Dir(fname))
+	for _, attr := range sortedAttributes(block.Body.Attributes) {
+		if !isStackReference(attr.Name) {
+			continue
+		}
+		tuple, ok := attr.Expr.(*hclsyntax.TupleConsExpr)
+		if !ok {
+			continue
+		}
+		for _, elem := range tuple.Exprs {
+			value, ok := stringLiteral(elem)
+			if !ok || path.IsAbs(value) {
+				continue
+			}
+			abspath := path.Join(dir.String(), value)
+			problems = append(problems, problem{
+				code:     codeRelativeStackPath,
+				rng:      lspRange(elem.Range()),
+				severity: lsp.DiagnosticSeverityHint,
+				message:  fmt.Sprintf("relative path %q can be the project path %q", value, abspath),
+				fix: &quickFix{
+					title: fmt.Sprintf("Use the project path %s", abspath),
+					edit:  replace(elem.Range(), fmt.Sprintf("%q", abspath)),
+				},
+			})
+		}
+	}
+	return problems
+}
+
+// stackWithID returns the project directory of a stack, defined outside fname,
+// with the given id.
+func stackWithID(idx *symbolIndex, fname string, id string) (string, bool) {
+	var found string
+	idx.forEach(func(other string, symbols *fileSymbols) {
+		if found != "" || other == fname {
+			return
+		}
+		for _, stack := range symbols.stacks {
+			if stack.id == id {
+				found = project.PrjAbsPath(idx.rootdir, filepath.Dir(other)).String()
+				return
+			}
+		}
+	})
+	return found, found != ""
+}
+
+// importProblem returns the problem of an import of a file which doesn't
+// exist. The import of files in the same directory tree is reported by the
+// Terramate parser and is not a missing file.
+func importProblem(docs *documents, rootdir, dir string, block *hclsyntax.Block) (problem, bool) {
+	attr, ok := block.Body.Attributes["source"]
+	if !ok {
+		return problem{}, false
+	}
+	source, ok := stringLiteral(attr.Expr)
+	if !ok {
+		return problem{}, false
+	}
+	target := resolvePath(rootdir, dir, source)
+	if srcdir := filepath.Dir(target); srcdir == dir ||
+		strings.HasPrefix(dir, srcdir+string(filepath.Separator)) {
+		return problem{}, false
+	}
+	if _, ok := docs.get(target); ok {
+		return problem{}, false
+	}
+	if _, err := os.Stat(target); err == nil {
+		return problem{}, false
+	}
+
+	return problem{
+		// same range of the error reported by the Terramate parser.
+		code:     codeMissingImport,
+		rng:      lspRange(attr.Expr.Range()),
+		severity: lsp.DiagnosticSeverityError,
+		message:  fmt.Sprintf("imported file %q does not exist", source),
+		fix: &quickFix{
+			title: fmt.Sprintf("Create %s", source),
+			edit: &workspaceEdit{
+				DocumentChanges: []interface{}{
+					lsp.CreateFile{
+						Kind: lsp.CreateResourceOperation,
+						URI:  fileURI(target),
+						Options: &lsp.CreateFileOptions{
+							IgnoreIfExists: true,
+						},
+					},
+				},
+			},
+		},
+	}, true
+}
+
+// missingStackProblem returns the problem of a directory with Terraform files
+// which is neither a stack nor inside a stack.
+func missingStackProblem(idx *symbolIndex, fname string, content []byte) (problem, bool) {
+	dir := filepath.Dir(fname)
+	inStack := false
+	idx.forEach(func(fname string, symbols *fileSymbols) {
+		stackdir := filepath.Dir(fname)
+		if len(symbols.stacks) > 0 &&
+			(stackdir == dir || strings.HasPrefix(dir, stackdir+string(filepath.Separator))) {
+			inStack = true
+		}
+	})
+	if inStack {
+		return problem{}, false
+	}
+
+	tfFiles, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if len(tfFiles) == 0 {
+		return problem{}, false
+	}
+
+	firstLine := len(content)
+	if i := bytes.IndexByte(content, '\n'); i >= 0 {
+		firstLine = i
+	}
+	text := fmt.Sprintf("stack {\n  name = %q\n  id   = %q\n}\n",
+		filepath.Base(dir), uuid.NewString())
+	if len(content) > 0 {
+		text += "\n"
+	}
+	return problem{
+		code: codeMissingStack,
+		rng: lsp.Range{
+			End: positionFor(content, firstLine),
+		},
+		severity: lsp.DiagnosticSeverityInformation,
+		message:  "directory has Terraform files but is not a stack",
+		fix: &quickFix{
+			title: "Add a stack block",
+			edit: &workspaceEdit{
+				Changes: map[lsp.URI][]lsp.TextEdit{
+					fileURI(fname): {{NewText: text}},
+				},
+			},
+		},
+	}, true
+}
+
+// duplicatedGlobals returns the globals of the file already defined in the
+// same directory, by a previous file or earlier in the same file.
+func duplicatedGlobals(idx *symbolIndex, fname string, content []byte, body *hclsyntax.Body) []problem {
+	dir := filepath.Dir(fname)
+	rootdir := idx.rootdir
+	defined := map[string]string{}
+	idx.forEach(func(other string, symbols *fileSymbols) {
+		if filepath.Dir(other) != dir || other >= fname {
+			return
+		}
+		for _, def := range symbols.globalDefs {
+			name := globalDefinition{path: def.path}.name()
+			if _, ok := defined[name]; !ok {
+				defined[name] = fmt.Sprintf("%s:%d",
+					project.PrjAbsPath(rootdir, other), def.nameRange.Start.Line)
+			}
+		}
+	})
+
+	var problems []problem
+	for _, block := range body.Blocks {
+		if block.Type != "globals" {
+			continue
+		}
+		for _, attr := range sortedAttributes(block.Body.Attributes) {
+			path := append(append([]string{}, block.Labels...), attr.Name)
+			name := globalDefinition{path: path}.name()
+			at, ok := defined[name]
+			if !ok {
+				defined[name] = fmt.Sprintf("%s:%d",
+					project.PrjAbsPath(rootdir, fname), attr.NameRange.Start.Line)
+				continue
+			}
+			problems = append(problems, problem{
+				code:     codeDuplicatedGlobal,
+				rng:      lspRange(attr.NameRange),
+				severity: lsp.DiagnosticSeverityError,
+				message:  fmt.Sprintf("%s is already defined at %s", name, at),
+				fix: &quickFix{
+					title: fmt.Sprintf("Remove the duplicated %s", name),
+					edit: &workspaceEdit{
+						Changes: map[lsp.URI][]lsp.TextEdit{
+							fileURI(fname): {{Range: lineRange(content, attr.Range())}},
+						},
+					},
+				},
+			})
+		}
+	}
+	return problems
+}
+
+// lineRange returns the range of the lines of rng, including the line break,
+// if nothing else is in these lines. Otherwise it returns rng.
+func lineRange(content []byte, rng hhcl.Range) lsp.Range {
+	start, end := rng.Start.Byte, rng.End.Byte
+	for start > 0 && (content[start-1] == ' ' || content[start-1] == '\t') {
+		start--
+	}
+	for end < len(content) && (content[end] == ' ' || content[end] == '\t' || content[end] == '\r') {
+		end++
+	}
+	if (start > 0 && content[start-1] != '\n') || (end < len(content) && content[end] != '\n') {
+		return lspRange(rng)
+	}
+	if end < len(content) {
+		end++
+	}
+	return lsp.Range{
+		Start: positionFor(content, start),
+		End:   positionFor(content, end),
+	}
+}
+
+var (
+	functionNamesOnce sync.Once
+	functionNames     []string
+)
+
+// terramateFunctions returns the sorted names of the Terramate functions.
+func terramateFunctions() []string {
+	functionNamesOnce.Do(func() {
+		ctx, err := eval.NewContext(os.TempDir())
+		if err != nil {
+			log.Debug().Err(err).Msg("creating evaluation context")
+			return
+		}
+		ctx.AddTmHCLExpression()
+		functionNames = append(functionNames, "tm_vendor")
+		for name := range ctx.Unwrap().Functions {
+			functionNames = append(functionNames, name)
+		}
+		sort.Strings(functionNames)
+	})
+	return functionNames
+}
+
+// isFunction tells if name is a Terramate function. If the functions are not
+// known, every name is considered a function.
+func isFunction(name string) bool {
+	names := terramateFunctions()
+	i := sort.SearchStrings(names, name)
+	return len(names) == 0 || (i < len(names) && names[i] == name)
+}
+
+// closestFunction returns the Terramate function closest to name, if any is
+// close enough to be a typo.
+func closestFunction(name string) (string, bool) {
+	best, bestDist := "", 3
+	for _, fn := range terramateFunctions() {
+		if dist := levenshtein.Distance(name, fn, nil); dist < bestDist {
+			best, bestDist = fn, dist
+		}
+	}
+	return best, best != ""
+}
@@ -23,10 +23,13 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mineiros-io/terramate/config"
 	"github.com/mineiros-io/terramate/errors"
 	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/project"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"go.lsp.dev/jsonrpc2"
@@ -37,8 +40,56 @@ import (
 // Server is the Language Server.
 type Server struct {
 	conn      jsonrpc2.Conn
-	workspace string
 	handlers  handlers
+	documents *documents
+
+	// folders are the host directories of the workspace folders. The files
+	// outside of a Terramate project belong to the folder containing them.
+	foldersMu sync.Mutex
+	folders   []string
+
+	indexesMu sync.Mutex
+	indexes   map[string]*symbolIndex
+
+	// workDoneProgress tells if the editor supports progress reported with
+	// tokens created by the server.
+	workDoneProgress bool
+
+	// watchFiles tells if the editor supports watching the files changed
+	// outside of it, with watchers registered by the server.
+	watchFiles bool
+
+	// configuration tells if the editor supports fetching the settings.
+	configuration bool
+
+	settingsMu sync.Mutex
+	cfg        settings
+
+	// logLevel is the global log level when the server is created, which is
+	// restored when the log level setting is cleared.
+	logLevel zerolog.Level
+
+	// projectConfigs are the configurations of the projects, by root
+	// directory, loaded from their configuration files.
+	projectConfigsMu sync.Mutex
+	projectConfigs   map[string]loadedProjectConfig
+
+	// checks are the pending checks of the changed files, which are delayed
+	// by the debounce delay setting.
+	checksMu sync.Mutex
+	checks   map[string]*time.Timer
+
+	// cycles are the run order cycles found by the last checks, by project
+	// root directory.
+	cyclesMu sync.Mutex
+	cycles   map[string]runOrderCycle
+
+	// hints are the evaluated scopes of the inlay hints, by host directory,
+	// which are evaluated again after any file changes. hintsGen counts the
+	// changes, so scopes evaluated before a change are not kept.
+	hintsMu  sync.Mutex
+	hintsGen uint64
+	hints    map[string]hintScopes
 
 	log zerolog.Logger
 }
@@ -61,8 +112,16 @@ func NewServer(conn jsonrpc2.Conn) *Server {
 // ServerWithLogger creates a new language server with a custom logger.
 func ServerWithLogger(conn jsonrpc2.Conn, l zerolog.Logger) *Server {
 	s := &Server{
-		conn: conn,
-		log:  l,
+		conn:      conn,
+		log:       l,
+		documents: newDocuments(),
+		indexes:   map[string]*symbolIndex{},
+		checks:    map[string]*time.Timer{},
+		hints:     map[string]hintScopes{},
+		cycles:    map[string]runOrderCycle{},
+		logLevel:  zerolog.GlobalLevel(),
+
+		projectConfigs: map[string]loadedProjectConfig{},
 	}
 	s.buildHandlers()
 	return s
@@ -70,12 +129,39 @@ func ServerWithLogger(conn jsonrpc2.Conn, l zerolog.Logger) *Server {
 
 func (s *Server) buildHandlers() {
 	s.handlers = map[string]handler{
-		lsp.MethodInitialize:             s.handleInitialize,
-		lsp.MethodInitialized:            s.handleInitialized,
-		lsp.MethodTextDocumentDidOpen:    s.handleDocumentOpen,
-		lsp.MethodTextDocumentDidChange:  s.handleDocumentChange,
-		lsp.MethodTextDocumentDidSave:    s.handleDocumentSaved,
-		lsp.MethodTextDocumentCompletion: s.handleCompletion,
+		lsp.MethodInitialize:                         s.handleInitialize,
+		lsp.MethodInitialized:                        s.handleInitialized,
+		lsp.MethodTextDocumentDidOpen:                s.handleDocumentOpen,
+		lsp.MethodTextDocumentDidChange:              s.handleDocumentChange,
+		lsp.MethodTextDocumentDidSave:                s.handleDocumentSaved,
+		lsp.MethodTextDocumentDidClose:               s.handleDocumentClose,
+		lsp.MethodTextDocumentCompletion:             s.handleCompletion,
+		lsp.MethodTextDocumentHover:                  s.handleHover,
+		lsp.MethodTextDocumentDefinition:             s.handleDefinition,
+		lsp.MethodTextDocumentReferences:             s.handleReferences,
+		lsp.MethodTextDocumentPrepareRename:          s.handlePrepareRename,
+		lsp.MethodTextDocumentRename:                 s.handleRename,
+		lsp.MethodTextDocumentDocumentSymbol:         s.handleDocumentSymbol,
+		lsp.MethodTextDocumentFormatting:             s.handleFormatting,
+		lsp.MethodTextDocumentRangeFormatting:        s.handleRangeFormatting,
+		lsp.MethodTextDocumentCodeAction:             s.handleCodeAction,
+		lsp.MethodTextDocumentCodeLens:               s.handleCodeLens,
+		lsp.MethodTextDocumentDocumentLink:           s.handleDocumentLink,
+		lsp.MethodTextDocumentFoldingRange:           s.handleFoldingRange,
+		methodTextDocumentSelectionRange:             s.handleSelectionRange,
+		methodTextDocumentInlayHint:                  s.handleInlayHint,
+		methodGeneratedPreview:                       s.handleGeneratedPreview,
+		methodRunOrder:                               s.handleRunOrder,
+		methodChangedStacks:                          s.handleChangedStacks,
+		lsp.MethodSemanticTokensFull:                 s.handleSemanticTokensFull,
+		lsp.MethodSemanticTokensRange:                s.handleSemanticTokensRange,
+		lsp.MethodWorkspaceSymbol:                    s.handleWorkspaceSymbol,
+		lsp.MethodWillRenameFiles:                    s.handleWillRenameFiles,
+		lsp.MethodDidRenameFiles:                     s.handleDidRenameFiles,
+		lsp.MethodWorkspaceDidChangeWatchedFiles:     s.handleDidChangeWatchedFiles,
+		lsp.MethodWorkspaceDidChangeWorkspaceFolders: s.handleDidChangeWorkspaceFolders,
+		lsp.MethodWorkspaceDidChangeConfiguration:    s.handleDidChangeConfiguration,
+		lsp.MethodWorkspaceExecuteCommand:            s.handleExecuteCommand,
 	}
 }
 
@@ -83,7 +169,7 @@ func (s *Server) buildHandlers() {
 func (s *Server) Handler(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Request) error {
 	logger := s.log.With().
 		Str("action", "server.Handler()").
-		Str("workspace", s.workspace).
+		Str("workspace", s.defaultFolder()).
 		Str("method", r.Method()).
 		Logger()
 
@@ -99,6 +185,21 @@ func (s *Server) Handler(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2
 	return reply(ctx, nil, jsonrpc2.ErrMethodNotFound)
 }
 
+// initializeResult is the result of the initialize request, whose server
+// capabilities include the ones missing in the protocol package.
+type initializeResult struct {
+	Capabilities serverCapabilities `json:"capabilities"`
+}
+
+// serverCapabilities are the capabilities of the protocol package and the
+// ones of newer versions of the protocol.
+type serverCapabilities struct {
+	lsp.ServerCapabilities
+
+	// InlayHintProvider tells if the server supports inlay hints.
+	InlayHintProvider bool `json:"inlayHintProvider,omitempty"`
+}
+
 func (s *Server) handleInitialize(
 	ctx context.Context,
 	reply jsonrpc2.Replier,
@@ -106,8 +207,21 @@ func (s *Server) handleInitialize(
 	log zerolog.Logger,
 ) error {
 	type initParams struct {
-		ProcessID int    `json:"processId,omitempty"`
-		RootURI   string `json:"rootUri,omitempty"`
+		ProcessID        int                   `json:"processId,omitempty"`
+		RootURI          string                `json:"rootUri,omitempty"`
+		WorkspaceFolders []lsp.WorkspaceFolder `json:"workspaceFolders,omitempty"`
+
+		Capabilities struct {
+			Window struct {
+				WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+			} `json:"window,omitempty"`
+			Workspace struct {
+				Configuration         bool `json:"configuration,omitempty"`
+				DidChangeWatchedFiles struct {
+					DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+				} `json:"didChangeWatchedFiles,omitempty"`
+			} `json:"workspace,omitempty"`
+		} `json:"capabilities,omitempty"`
 	}
 
 	var params initParams
@@ -119,26 +233,106 @@ func (s *Server) handleInitialize(
 		return jsonrpc2.ErrInvalidParams
 	}
 
-	s.workspace = string(uri.New(params.RootURI).Filename())
-	err := reply(ctx, lsp.InitializeResult{
-		Capabilities: lsp.ServerCapabilities{
-			CompletionProvider: &lsp.CompletionOptions{},
+	if len(params.WorkspaceFolders) > 0 {
+		s.addWorkspaceFolders(folderDirs(params.WorkspaceFolders))
+	} else if params.RootURI != "" {
+		s.addWorkspaceFolders([]string{uri.New(params.RootURI).Filename()})
+	}
+	s.workDoneProgress = params.Capabilities.Window.WorkDoneProgress
+	s.watchFiles = params.Capabilities.Workspace.DidChangeWatchedFiles.DynamicRegistration
+	s.configuration = params.Capabilities.Workspace.Configuration
+	err := reply(ctx, initializeResult{
+		Capabilities: serverCapabilities{
+			// If we support showing the values of globals and metadata inline.
+			InlayHintProvider: true,
+
+			ServerCapabilities: lsp.ServerCapabilities{
+				CompletionProvider: &lsp.CompletionOptions{},
+
+				// if we support `goto` definition.
+				DefinitionProvider: true,
+
+				// If we support `hover` info.
+				HoverProvider: true,
+
+				// If we support finding references of globals and stacks.
+				ReferencesProvider: true,
+
+				// If we support the outline of Terramate files.
+				DocumentSymbolProvider: true,
+
+				// If we support formatting with the same rules as `terramate fmt`.
+				DocumentFormattingProvider:      true,
+				DocumentRangeFormattingProvider: true,
+
+				// If we support fixing the problems found by the language server and
+				// refactoring globals.
+				CodeActionProvider: &lsp.CodeActionOptions{
+					CodeActionKinds: []lsp.CodeActionKind{
+						lsp.QuickFix,
+						lsp.RefactorExtract,
+						lsp.RefactorInline,
+						lsp.RefactorRewrite,
+					},
+				},
+
+				// If we support showing the run order, generated files and changes
+				// of stacks.
+				CodeLensProvider: &lsp.CodeLensOptions{},
+
+				// If we support following the paths of imports, stacks and
+				// generated files.
+				DocumentLinkProvider: &lsp.DocumentLinkOptions{},
 
-			// if we support `goto` definition.
-			DefinitionProvider: false,
+				// If we support the commands of the code lenses.
+				ExecuteCommandProvider: &lsp.ExecuteCommandOptions{
+					Commands: commands(),
+				},
+
+				// If we support folding and expanding the selection along the
+				// syntax tree.
+				FoldingRangeProvider:   true,
+				SelectionRangeProvider: true,
+
+				// If we support highlighting the Terramate constructs.
+				SemanticTokensProvider: &semanticTokensOptions{
+					Legend: semanticTokensLegend(),
+					Range:  true,
+					Full:   true,
+				},
+
+				// If we support searching stacks, globals and generated files.
+				WorkspaceSymbolProvider: true,
 
-			// If we support `hover` info.
-			HoverProvider: false,
+				// If we support renaming globals.
+				RenameProvider: &lsp.RenameOptions{
+					PrepareProvider: true,
+				},
+
+				Workspace: &lsp.ServerCapabilitiesWorkspace{
+					// If we support opening several projects in the same editor.
+					WorkspaceFolders: &lsp.ServerCapabilitiesWorkspaceFolders{
+						Supported:           true,
+						ChangeNotifications: true,
+					},
+
+					// If we want to fix the stack and import paths when files are moved.
+					FileOperations: &lsp.ServerCapabilitiesWorkspaceFileOperations{
+						WillRename: fileOperationOptions(),
+						DidRename:  fileOperationOptions(),
+					},
+				},
 
-			TextDocumentSync: lsp.TextDocumentSyncOptions{
-				// Send all file content on every change (can be optimized later).
-				Change: lsp.TextDocumentSyncKindFull,
+				TextDocumentSync: lsp.TextDocumentSyncOptions{
+					// Send all file content on every change (can be optimized later).
+					Change: lsp.TextDocumentSyncKindFull,
 
-				// if we want to be notified about open/close of Terramate files.
-				OpenClose: true,
-				Save: &lsp.SaveOptions{
-					// If we want the file content on save,
-					IncludeText: false,
+					// if we want to be notified about open/close of Terramate files.
+					OpenClose: true,
+					Save: &lsp.SaveOptions{
+						// If we want the file content on save,
+						IncludeText: false,
+					},
 				},
 			},
 		},
@@ -150,7 +344,7 @@ func (s *Server) handleInitialize(
 		log.Fatal().Err(err).Msg("failed to reply")
 	}
 
-	log.Info().Msgf("client connected using workspace %q", s.workspace)
+	log.Info().Strs("folders", s.workspaceFolders()).Msg("client connected")
 
 	err = s.conn.Notify(ctx, lsp.MethodWindowShowMessage, lsp.ShowMessageParams{
 		Message: "connected to terramate-ls",
@@ -169,6 +363,17 @@ func (s *Server) handleInitialized(
 	r jsonrpc2.Request,
 	log zerolog.Logger,
 ) error {
+	// the workspace index is built in the background, so the first requests
+	// needing it don't pay for walking the whole workspace.
+	for _, folder := range s.workspaceFolders() {
+		go s.index(s.projectRoot(folder))
+	}
+	if s.watchFiles {
+		go s.registerFileWatchers(ctx)
+	}
+	if s.configuration {
+		go s.fetchSettings(ctx)
+	}
 	return reply(ctx, nil, nil)
 }
 
@@ -186,6 +391,8 @@ func (s *Server) handleDocumentOpen(
 
 	fname := params.TextDocument.URI.Filename()
 	content := params.TextDocument.Text
+	s.documents.set(fname, content)
+	s.reindexFile(fname)
 
 	return s.checkAndReply(ctx, reply, fname, content)
 }
@@ -210,7 +417,13 @@ func (s *Server) handleDocumentChange(
 
 	content := params.ContentChanges[0].Text
 	fname := params.TextDocument.URI.Filename()
+	s.documents.set(fname, content)
+	s.reindexFile(fname)
 
+	if delay := s.settings().debounceDelay(); delay > 0 {
+		s.checkLater(fname, delay)
+		return reply(ctx, nil, nil)
+	}
 	return s.checkAndReply(ctx, reply, fname, content)
 }
 
@@ -233,13 +446,33 @@ func (s *Server) handleDocumentSaved(
 		return nil
 	}
 
+	s.documents.set(fname, string(content))
+	s.reindexFile(fname)
 	return s.checkAndReply(ctx, reply, fname, string(content))
 }
 
+func (s *Server) handleDocumentClose(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DidCloseTextDocumentParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	s.documents.remove(fname)
+	s.reindexFile(fname)
+	return reply(ctx, nil, nil)
+}
+
 // sendErrorDiagnostics sends diagnostics for each provided file, the ones with
 // no reported error gets an empty list of diagnostics, so the editor can clean
 // up its problems panel for it.
-func (s *Server) sendErrorDiagnostics(ctx context.Context, files []string, err error) error {
+func (s *Server) sendErrorDiagnostics(ctx context.Context, c *check, files []string, err error) error {
 	errs := errors.L()
 	switch e := err.(type) {
 	case *errors.Error:
@@ -283,7 +516,10 @@ func (s *Server) sendErrorDiagnostics(ctx context.Context, files []string, err e
 	}
 
 	for _, filename := range files {
-		diags := diagsMap[filename]
+		diags := []lsp.Diagnostic{}
+		if !s.ignoredFile(filename) {
+			diags = addProblems(diagsMap[filename], s.problems(c, filename))
+		}
 		filePath := lsp.URI(uri.File(filepath.ToSlash(filename)))
 		s.sendDiagnostics(ctx, filePath, diags)
 	}
@@ -302,8 +538,15 @@ func (s *Server) handleCompletion(
 		log.Error().Err(err).Msg("failed to unmarshal params")
 		return jsonrpc2.ErrParse
 	}
-	log.Debug().Str("params", string(r.Params()))
-	return reply(ctx, nil, nil)
+
+	fname := params.TextDocument.URI.Filename()
+	items, err := s.completions(fname, params.Position)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to compute completions")
+		return reply(ctx, nil, nil)
+	}
+
+	return reply(ctx, items, nil)
 }
 
 func (s *Server) sendDiagnostics(ctx context.Context, uri lsp.URI, diags []lsp.Diagnostic) {
@@ -323,16 +566,53 @@ func (s *Server) checkAndReply(
 	fname string,
 	content string,
 ) error {
+	return reply(ctx, nil, s.checkAndPublish(ctx, fname, content))
+}
+
+// checkAndPublish checks the files in the directory of fname, with the given
+// content for fname, and publishes their diagnostics. The stacks entering or
+// leaving a run order cycle are checked too.
+func (s *Server) checkAndPublish(ctx context.Context, fname string, content string) error {
+	c := s.newCheck()
+	err := s.checkDir(ctx, c, fname, content)
+	s.recheckCycles(ctx, c, []string{filepath.Dir(fname)})
+	return err
+}
+
+// checkDir checks the files in the directory of fname, with the given content
+// for fname, and publishes their diagnostics.
+func (s *Server) checkDir(ctx context.Context, c *check, fname string, content string) error {
 	files, err := listFiles(fname)
 	files = append(files, fname)
 	sort.Strings(files)
 	if err == nil {
 		err = s.checkFiles(files, fname, content)
 	}
+	return s.sendErrorDiagnostics(ctx, c, files, err)
+}
+
+// checkLater checks the opened file fname after the delay, unless it changes
+// again before, so the files are not checked at every key stroke.
+func (s *Server) checkLater(fname string, delay time.Duration) {
+	s.checksMu.Lock()
+	defer s.checksMu.Unlock()
 
-	return reply(ctx, nil,
-		s.sendErrorDiagnostics(ctx, files, err),
-	)
+	if timer, ok := s.checks[fname]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		s.checksMu.Lock()
+		if s.checks[fname] == timer {
+			delete(s.checks, fname)
+		}
+		s.checksMu.Unlock()
+
+		if content, ok := s.documents.get(fname); ok {
+			_ = s.checkAndPublish(context.Background(), fname, content)
+		}
+	})
+	s.checks[fname] = timer
 }
 
 func listFiles(fromFile string) ([]string, error) {
@@ -356,7 +636,7 @@ func listFiles(fromFile string) ([]string, error) {
 		}
 
 		filename := dirEntry.Name()
-		if strings.HasSuffix(filename, ".tm") || strings.HasSuffix(filename, ".tm.hcl") {
+		if isTerramateFile(filename) {
 			path := filepath.Join(dir, filename)
 
 			if path == fromFile {
@@ -371,16 +651,35 @@ func listFiles(fromFile string) ([]string, error) {
 	return files, nil
 }
 
-// checkFiles checks if the given provided files have errors but the currentFile
-// is handled separately because it can be unsaved.
-func (s *Server) checkFiles(files []string, currentFile string, currentContent string) error {
-	dir := filepath.Dir(currentFile)
+func isTerramateFile(filename string) bool {
+	return strings.HasSuffix(filename, ".tm") || strings.HasSuffix(filename, ".tm.hcl")
+}
+
+// projectRoot returns the Terramate project root directory of the given dir.
+// If no project root configuration is found then the workspace folder
+// containing dir is used.
+func (s *Server) projectRoot(dir string) string {
 	_, rootdir, found, _ := config.TryLoadConfig(dir)
 	if !found {
-		rootdir = s.workspace
+		rootdir = s.workspaceFolder(dir)
 	}
 
 	log.Trace().Msgf("using project root: %s (found: %t)", rootdir, found)
+	return rootdir
+}
+
+// ignoredFile tells if the file is ignored by the settings or excluded by the
+// project configuration.
+func (s *Server) ignoredFile(fname string) bool {
+	rootdir := s.projectRoot(filepath.Dir(fname))
+	return s.settingsFor(rootdir).ignored(project.PrjAbsPath(rootdir, fname))
+}
+
+// checkFiles checks if the given provided files have errors but the currentFile
+// is handled separately because it can be unsaved.
+func (s *Server) checkFiles(files []string, currentFile string, currentContent string) error {
+	dir := filepath.Dir(currentFile)
+	rootdir := s.projectRoot(dir)
 
 	parser, err := hcl.NewTerramateParser(rootdir, dir)
 	if err != nil {
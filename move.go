@@ -0,0 +1,181 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"github.com/zclconf/go-cty/cty"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func (s *Server) handleWillRenameFiles(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.RenameFilesParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	var moves []fileMove
+	for _, file := range params.Files {
+		moves = append(moves, fileMove{
+			from: uri.New(file.OldURI).Filename(),
+			to:   uri.New(file.NewURI).Filename(),
+		})
+	}
+	return reply(ctx, s.moveEdits(moves), nil)
+}
+
+func (s *Server) handleDidRenameFiles(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.RenameFilesParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	// the moved files changed the symbols of the whole project, so the indexes
+	// are built again when needed.
+	s.dropIndexes()
+	return reply(ctx, nil, nil)
+}
+
+// fileOperationOptions are the options for the file operations the server is
+// interested in, which are any file or directory inside the workspace.
+func fileOperationOptions() *lsp.FileOperationRegistrationOptions {
+	return &lsp.FileOperationRegistrationOptions{
+		Filters: []lsp.FileOperationFilter{
+			{
+				Scheme: "file",
+				Pattern: lsp.FileOperationPattern{
+					Glob: "**",
+				},
+			},
+		},
+	}
+}
+
+// fileMove is a file or directory being moved.
+type fileMove struct {
+	from string
+	to   string
+}
+
+// apply returns the new location of the file if it is moved.
+func (m fileMove) apply(fname string) string {
+	if fname == m.from {
+		return m.to
+	}
+	if strings.HasPrefix(fname, m.from+string(filepath.Separator)) {
+		return filepath.Join(m.to, strings.TrimPrefix(fname, m.from))
+	}
+	return fname
+}
+
+// moveEdits returns the edits which keep the project configuration pointing at
+// the same stacks and files after the moves. It rewrites:
+//   - the after, before, wants, wanted_by and watch paths of stacks.
+//   - the import sources.
+//
+// Paths inside the moved directories are rewritten if they point outside
+// the moved directories using relative paths. The moves leaving the project
+// are ignored, as the paths can't point outside of it. It returns nil if
+// nothing needs to be changed.
+func (s *Server) moveEdits(moves []fileMove) *lsp.WorkspaceEdit {
+	var inside []fileMove
+	for _, m := range moves {
+		rootdir := s.projectRoot(filepath.Dir(m.from))
+		if m.to == rootdir || strings.HasPrefix(m.to, rootdir+string(filepath.Separator)) {
+			inside = append(inside, m)
+		}
+	}
+	moves = inside
+
+	move := func(fname string) string {
+		for _, m := range moves {
+			fname = m.apply(fname)
+		}
+		return fname
+	}
+
+	changes := map[lsp.URI][]lsp.TextEdit{}
+	roots := map[string]bool{}
+	for _, m := range moves {
+		rootdir := s.projectRoot(filepath.Dir(m.from))
+		if roots[rootdir] {
+			continue
+		}
+		roots[rootdir] = true
+
+		s.index(rootdir).forEach(func(fname string, symbols *fileSymbols) {
+			newdir := filepath.Dir(move(fname))
+			for _, ref := range symbols.pathRefs {
+				target := filepath.Join(rootdir, filepath.FromSlash(ref.target.String()))
+				newTarget := move(target)
+				if newTarget == target && newdir == filepath.Dir(fname) {
+					continue
+				}
+
+				var value string
+				if path.IsAbs(ref.value) {
+					value = project.PrjAbsPath(rootdir, newTarget).String()
+				} else {
+					rel, err := filepath.Rel(newdir, newTarget)
+					if err != nil {
+						continue
+					}
+					value = filepath.ToSlash(rel)
+				}
+
+				if value == path.Clean(ref.value) {
+					continue
+				}
+
+				// only the content of the string is replaced, escaped as in
+				// an HCL string.
+				quoted := formatValue(cty.StringVal(value))
+				rng := lspRange(ref.rng)
+				rng.Start.Character++
+				rng.End.Character--
+				uri := fileURI(fname)
+				changes[uri] = append(changes[uri], lsp.TextEdit{
+					Range:   rng,
+					NewText: quoted[1 : len(quoted)-1],
+				})
+			}
+		})
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	return &lsp.WorkspaceEdit{Changes: changes}
+}
@@ -0,0 +1,178 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestWillRenameFiles(t *testing.T) {
+	type wantEdit struct {
+		rng     lsp.Range
+		newText string
+	}
+	type testcase struct {
+		name   string
+		layout []string
+		files  map[string]string
+		want   map[string][]wantEdit
+	}
+
+	line := func(l, start, end uint32) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: l, Character: start},
+			End:   lsp.Position{Line: l, Character: end},
+		}
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "move stack to a deeper directory",
+			layout: []string{
+				test.RootConfig,
+				"f:modules/g.tm:globals {}",
+				"f:stacks/a/globals.tm:globals {}",
+				"f:stacks/a/stack.tm:stack {\n  before = [\"../b\"]\n}\nimport {\n  source = \"../../modules/g.tm\"\n}",
+				"f:stacks/b/stack.tm:stack {\n  after = [\"/stacks/a\", \"../a\"]\n}",
+				"f:stacks/c/stack.tm:stack {\n  watch = [\"/stacks/a/file.txt\"]\n}\nimport {\n  source = \"/stacks/a/globals.tm\"\n}",
+			},
+			files: map[string]string{
+				"stacks/a": "infra/prod/a",
+			},
+			want: map[string][]wantEdit{
+				"stacks/a/stack.tm": {
+					{rng: line(1, 13, 17), newText: "../../../stacks/b"},
+					{rng: line(4, 12, 30), newText: "../../../modules/g.tm"},
+				},
+				"stacks/b/stack.tm": {
+					{rng: line(1, 12, 21), newText: "/infra/prod/a"},
+					{rng: line(1, 25, 29), newText: "../../infra/prod/a"},
+				},
+				"stacks/c/stack.tm": {
+					{rng: line(1, 12, 30), newText: "/infra/prod/a/file.txt"},
+					{rng: line(4, 12, 32), newText: "/infra/prod/a/globals.tm"},
+				},
+			},
+		},
+		{
+			name: "move parent directory of stacks",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/stack.tm:stack {\n  after = [\"/stacks/b\", \"../b\"]\n}",
+				"f:stacks/b/stack.tm:stack {}",
+				"f:other/stack.tm:stack {\n  after = [\"/stacks\"]\n}",
+			},
+			files: map[string]string{
+				"stacks": "infra",
+			},
+			want: map[string][]wantEdit{
+				"other/stack.tm": {
+					{rng: line(1, 12, 19), newText: "/infra"},
+				},
+				"stacks/a/stack.tm": {
+					{rng: line(1, 12, 21), newText: "/infra/b"},
+				},
+			},
+		},
+		{
+			name: "move imported file",
+			layout: []string{
+				test.RootConfig,
+				"f:modules/g.tm:globals {}",
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/g.tm\"\n}",
+			},
+			files: map[string]string{
+				"modules/g.tm": "modules/globals.tm",
+			},
+			want: map[string][]wantEdit{
+				"stack/stack.tm": {
+					{rng: line(2, 12, 25), newText: "/modules/globals.tm"},
+				},
+			},
+		},
+		{
+			name: "new path is escaped",
+			layout: []string{
+				test.RootConfig,
+				"f:modules/g.tm:globals {}",
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/g.tm\"\n}",
+			},
+			files: map[string]string{
+				"modules/g.tm": "modules/\"${g}\\.tm",
+			},
+			want: map[string][]wantEdit{
+				"stack/stack.tm": {
+					{rng: line(2, 12, 25), newText: "/modules/\\\"$${g}\\\\.tm"},
+				},
+			},
+		},
+		{
+			name: "move stack outside the project",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/stack.tm:stack {\n  after = [\"/stacks/b\"]\n}",
+				"f:stacks/b/stack.tm:stack {}",
+			},
+			files: map[string]string{
+				"stacks/b": "../b",
+			},
+		},
+		{
+			name: "move unreferenced stack",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/stack.tm:stack {\n  after = [\"/stacks/b\"]\n}",
+				"f:stacks/b/stack.tm:stack {}",
+				"f:stacks/c/stack.tm:stack {}",
+			},
+			files: map[string]string{
+				"stacks/c": "stacks/d",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			var want map[lsp.URI][]lsp.TextEdit
+			if len(tc.want) > 0 {
+				want = map[lsp.URI][]lsp.TextEdit{}
+			}
+			for file, edits := range tc.want {
+				fileuri := uri.File(filepath.Join(f.Sandbox.RootDir(), file))
+				for _, edit := range edits {
+					want[fileuri] = append(want[fileuri], lsp.TextEdit{
+						Range:   edit.rng,
+						NewText: edit.newText,
+					})
+				}
+			}
+
+			var got map[lsp.URI][]lsp.TextEdit
+			if edit := f.Editor.WillRenameFiles(tc.files); edit != nil {
+				got = edit.Changes
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("edits mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
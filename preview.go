@@ -0,0 +1,218 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"path/filepath"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/generate"
+	"github.com/mineiros-io/terramate/generate/genfile"
+	"github.com/mineiros-io/terramate/hcl/info"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// methodGeneratedPreview is the request returning the code generated by a
+// generate_hcl or generate_file block, which is not part of the protocol.
+const methodGeneratedPreview = "terramate/generatedPreview"
+
+// generatedScheme is the URI scheme of the previews of generated files,
+// eg.: terramate-generated:/stacks/a/main.tf.
+const generatedScheme = "terramate-generated"
+
+// generatedPreviewParams are the parameters of the terramate/generatedPreview
+// request, with the position of the generate block.
+type generatedPreviewParams struct {
+	lsp.TextDocumentPositionParams
+
+	// Stack is the project path of the stack to preview. If empty, all the
+	// stacks inheriting the block are previewed.
+	Stack string `json:"stack,omitempty"`
+}
+
+// generatedPreview is the code generated by a block for a stack, or for the
+// project if the block has root context.
+type generatedPreview struct {
+	// URI is the virtual document of the generated file.
+	URI lsp.URI `json:"uri"`
+
+	// Stack is the project path of the stack, which is empty for the files
+	// generated with root context.
+	Stack string `json:"stack,omitempty"`
+
+	// Condition tells if the file is generated. The content of files not
+	// generated is empty.
+	Condition bool   `json:"condition"`
+	Content   string `json:"content"`
+
+	// Error is the reason the code could not be generated for the stack.
+	Error string `json:"error,omitempty"`
+}
+
+func (s *Server) handleGeneratedPreview(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params generatedPreviewParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+
+	block, ok := generateBlockAt(parseBody(fname, content), offsetFor(content, params.Position))
+	if !ok {
+		return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams,
+			"no generate_hcl or generate_file block at the position"))
+	}
+
+	dir := filepath.Dir(fname)
+	p, err := s.loadProject(dir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load project")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+	return reply(ctx, p.generatedPreviews(block, params.Stack), nil)
+}
+
+// generateBlockAt returns the generate block with a single label containing
+// the byte offset.
+func generateBlockAt(body *hclsyntax.Body, offset int) (*hclsyntax.Block, bool) {
+	blocks := blocksAt(body, offset)
+	if len(blocks) == 0 || len(blocks[0].Labels) != 1 {
+		return nil, false
+	}
+	switch blocks[0].Type {
+	case "generate_hcl", "generate_file":
+		return blocks[0], true
+	}
+	return nil, false
+}
+
+// generatedPreviews returns the code generated by the block, for the stack
+// path or, if it is empty, for all the stacks inheriting the block. Blocks with
+// root context have a single preview.
+func (p *projectState) generatedPreviews(block *hclsyntax.Block, stack string) []generatedPreview {
+	previews := []generatedPreview{}
+	label := block.Labels[0]
+
+	if attr, ok := block.Body.Attributes["context"]; ok && hhcl.ExprAsKeyword(attr.Expr) == genfile.RootContext {
+		target := project.NewPath(label)
+		preview := generatedPreview{URI: generatedURI(target)}
+		files, err := p.rootGenFiles(target.Dir())
+		if err != nil {
+			preview.Error = err.Error()
+		}
+		for _, file := range files {
+			if project.NewPath(file.Label()) == target {
+				preview.setFile(file)
+				if file.Condition() {
+					break
+				}
+			}
+		}
+		return append(previews, preview)
+	}
+
+	results, err := generate.Load(p.root, p.vendorDir())
+	if err != nil {
+		return previews
+	}
+	for _, res := range results {
+		if (stack != "" && res.Dir != project.NewPath(stack)) || !p.inherits(res.Dir, block.Range()) {
+			continue
+		}
+		if _, ok := p.stackAt(p.hostPath(res.Dir)); !ok {
+			continue
+		}
+
+		preview := generatedPreview{
+			URI:   generatedURI(project.NewPath(path.Join(res.Dir.String(), label))),
+			Stack: res.Dir.String(),
+		}
+		if res.Err != nil {
+			preview.Error = res.Err.Error()
+			previews = append(previews, preview)
+			continue
+		}
+		for _, file := range res.Files {
+			if sameBlock(file.Range(), block.Range()) {
+				preview.setFile(file)
+				previews = append(previews, preview)
+				break
+			}
+		}
+	}
+	return previews
+}
+
+// inherits tells if the configuration of the directory at the project path,
+// including its parent directories and the files they import, contains the
+// generate block at the range.
+func (p *projectState) inherits(dir project.Path, rng hhcl.Range) bool {
+	for {
+		if cfg, ok := p.root.Lookup(dir); ok {
+			for _, gen := range cfg.Node.Generate.HCLs {
+				if sameBlock(gen.Range, rng) {
+					return true
+				}
+			}
+			for _, gen := range cfg.Node.Generate.Files {
+				if sameBlock(gen.Range, rng) {
+					return true
+				}
+			}
+		}
+		if dir == dir.Dir() {
+			return false
+		}
+		dir = dir.Dir()
+	}
+}
+
+// sameBlock tells if the range of a loaded block is the range of the block.
+func sameBlock(loaded info.Range, rng hhcl.Range) bool {
+	return loaded.HostPath() == rng.Filename && loaded.Start().Byte() == rng.Start.Byte
+}
+
+// setFile sets the content of the generated file in the preview.
+func (preview *generatedPreview) setFile(file generate.GenFile) {
+	preview.Condition = file.Condition()
+	preview.Content = ""
+	if file.Condition() {
+		preview.Content = file.Header() + file.Body()
+	}
+}
+
+// generatedURI returns the URI of the preview of the generated file at the
+// project path.
+func generatedURI(target project.Path) lsp.URI {
+	return lsp.URI(generatedScheme + ":" + target.String())
+}
@@ -0,0 +1,161 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestGeneratedPreview(t *testing.T) {
+	generate := "generate_hcl \"main.tf\" {\n  content {\n    name = global.name\n  }\n}\n"
+	f := test.Setup(t,
+		"f:globals.tm:globals {\n  name = \"root\"\n}\n",
+		"f:generate.tm:"+generate,
+		"f:stacks/a/stack.tm:stack {}\n\nglobals {\n  name = \"a\"\n}\n",
+		"f:stacks/b/stack.tm:stack {}\n",
+		"f:stacks/c/stack.tm:stack {}\n\nglobals {\n  name = tm_unknown()\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	got, err := f.Editor.GeneratedPreview("generate.tm", lsp.Position{Line: 2, Character: 4}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[2].Error == "" {
+		t.Fatalf("want the failure of stack c, got %+v", got)
+	}
+	want := []test.GeneratedPreview{
+		{
+			URI:       "terramate-generated:/stacks/a/main.tf",
+			Stack:     "/stacks/a",
+			Condition: true,
+			Content:   genHeader + "name = \"a\"\n",
+		},
+		{
+			URI:       "terramate-generated:/stacks/b/main.tf",
+			Stack:     "/stacks/b",
+			Condition: true,
+			Content:   genHeader + "name = \"root\"\n",
+		},
+	}
+	if diff := cmp.Diff(want, got[:2]); diff != "" {
+		t.Fatalf("previews mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	// the preview shows the unsaved changes.
+	f.Editor.Open("generate.tm")
+	f.Editor.Change("generate.tm", "generate_hcl \"main.tf\" {\n  condition = false\n"+
+		"  content {\n    name = global.name\n  }\n}\n")
+	drainRequests(f.Editor)
+
+	got, err = f.Editor.GeneratedPreview("generate.tm", lsp.Position{}, "/stacks/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []test.GeneratedPreview{
+		{
+			URI:   "terramate-generated:/stacks/b/main.tf",
+			Stack: "/stacks/b",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("previews mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestGeneratedPreviewRootContext(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {}\n",
+		"f:b/stack.tm:stack {}\n",
+		"f:root.tm:globals {}\n\n"+
+			"generate_file \"/out/stacks.txt\" {\n  context = root\n"+
+			"  content = tm_join(\",\", terramate.stacks.list)\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	got, err := f.Editor.GeneratedPreview("root.tm", lsp.Position{Line: 3}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []test.GeneratedPreview{
+		{
+			URI:       "terramate-generated:/out/stacks.txt",
+			Condition: true,
+			Content:   "/a,/b",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("previews mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	if _, err := f.Editor.GeneratedPreview("root.tm", lsp.Position{}, ""); err == nil {
+		t.Fatal("want error outside generate blocks")
+	}
+}
+
+func TestGeneratedPreviewImportedBlock(t *testing.T) {
+	f := test.Setup(t,
+		"f:modules/gen.tm:generate_hcl \"main.tf\" {\n  content {\n    name = \"a\"\n  }\n}\n",
+		"f:stacks/a/stack.tm:stack {}\n\nimport {\n  source = \"/modules/gen.tm\"\n}\n",
+		"f:stacks/b/stack.tm:stack {}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	got, err := f.Editor.GeneratedPreview("modules/gen.tm", lsp.Position{Line: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []test.GeneratedPreview{
+		{
+			URI:       "terramate-generated:/stacks/a/main.tf",
+			Stack:     "/stacks/a",
+			Condition: true,
+			Content:   genHeader + "name = \"a\"\n",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("previews mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	// the unsaved imported file and the unsaved import are previewed.
+	f.Editor.Open("modules/gen.tm")
+	f.Editor.Change("modules/gen.tm", "generate_hcl \"main.tf\" {\n  condition = false\n"+
+		"  content {\n    name = \"a\"\n  }\n}\n")
+	f.Editor.Open("stacks/b/stack.tm")
+	f.Editor.Change("stacks/b/stack.tm", "stack {}\n\nimport {\n  source = \"/modules/gen.tm\"\n}\n")
+	drainRequests(f.Editor)
+
+	got, err = f.Editor.GeneratedPreview("modules/gen.tm", lsp.Position{Line: 1}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []test.GeneratedPreview{
+		{
+			URI:   "terramate-generated:/stacks/a/main.tf",
+			Stack: "/stacks/a",
+		},
+		{
+			URI:   "terramate-generated:/stacks/b/main.tf",
+			Stack: "/stacks/b",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("previews mismatch, want(-) got(+):\n%s", diff)
+	}
+}
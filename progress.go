@@ -0,0 +1,103 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	lsp "go.lsp.dev/protocol"
+)
+
+// progressTokens counts the progress tokens created by the server.
+var progressTokens int64
+
+// progressParams are the parameters of $/progress and of
+// window/workDoneProgress/create, whose token only marshals to JSON as a
+// pointer.
+type progressParams struct {
+	Token *lsp.ProgressToken `json:"token"`
+	Value interface{}        `json:"value,omitempty"`
+}
+
+// progress reports the progress of a long running request with $/progress
+// notifications. A nil progress reports nothing.
+type progress struct {
+	s     *Server
+	ctx   context.Context
+	token *lsp.ProgressToken
+}
+
+// startProgress begins the progress of a request with the token sent by the
+// editor. If there is no token but the editor supports progress created by
+// the server, a new token is created. It returns nil if the progress cannot be
+// reported.
+//
+// It must not be called from a request handler, as it waits for the editor.
+func (s *Server) startProgress(ctx context.Context, token *lsp.ProgressToken, title string) *progress {
+	if token == nil {
+		if !s.workDoneProgress {
+			return nil
+		}
+		token = lsp.NewProgressToken(fmt.Sprintf("terramate-ls/%d", atomic.AddInt64(&progressTokens, 1)))
+		_, err := s.conn.Call(ctx, lsp.MethodWorkDoneProgressCreate,
+			progressParams{Token: token}, nil)
+		if err != nil {
+			s.log.Error().Err(err).Msg("failed to create progress token")
+			return nil
+		}
+	}
+
+	p := &progress{s: s, ctx: ctx, token: token}
+	p.notify(lsp.WorkDoneProgressBegin{
+		Kind:  lsp.WorkDoneProgressKindBegin,
+		Title: title,
+	})
+	return p
+}
+
+// report updates the message and the percentage of the progress.
+func (p *progress) report(message string, percentage uint32) {
+	if p == nil {
+		return
+	}
+	p.notify(lsp.WorkDoneProgressReport{
+		Kind:       lsp.WorkDoneProgressKindReport,
+		Message:    message,
+		Percentage: percentage,
+	})
+}
+
+// end finishes the progress with a final message.
+func (p *progress) end(message string) {
+	if p == nil {
+		return
+	}
+	p.notify(lsp.WorkDoneProgressEnd{
+		Kind:    lsp.WorkDoneProgressKindEnd,
+		Message: message,
+	})
+}
+
+func (p *progress) notify(value interface{}) {
+	err := p.s.conn.Notify(p.ctx, lsp.MethodProgress, progressParams{
+		Token: p.token,
+		Value: value,
+	})
+	if err != nil {
+		p.s.log.Error().Err(err).Msg("failed to notify progress")
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/config"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/mineiros-io/terramate/globals"
+	"github.com/mineiros-io/terramate/hcl"
+	"github.com/mineiros-io/terramate/hcl/eval"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/mineiros-io/terramate/stack"
+	"github.com/rs/zerolog/log"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// projectState is the loaded configuration of a Terramate project.
+type projectState struct {
+	rootdir  string
+	root     *config.Root
+	stacks   stack.List
+	metadata project.Metadata
+}
+
+// loadProject loads the Terramate project containing the directory dir.
+// The files opened in the editor, imported ones included, are loaded from
+// their buffers, the other ones from disk. A directory with invalid
+// configuration is loaded as an empty configuration, so the features depending
+// on the project keep working while the user is editing the files.
+func (s *Server) loadProject(dir string) (*projectState, error) {
+	rootdir := s.projectRoot(dir)
+	tree, err := s.loadTree(rootdir, rootdir)
+	if err != nil {
+		return nil, err
+	}
+
+	root := config.NewRoot(tree)
+	stacks := stack.List{}
+	for _, node := range root.Tree().Stacks() {
+		st, err := stack.New(rootdir, node.Node)
+		if err != nil {
+			log.Debug().Err(err).Str("dir", node.Dir()).Msg("ignoring invalid stack")
+			continue
+		}
+		stacks = append(stacks, st)
+	}
+
+	return &projectState{
+		rootdir:  rootdir,
+		root:     root,
+		stacks:   stacks,
+		metadata: stack.NewProjectMetadata(rootdir, stacks),
+	}, nil
+}
+
+func (s *Server) loadTree(rootdir string, cfgdir string) (*config.Tree, error) {
+	dirEntries, err := os.ReadDir(cfgdir)
+	if err != nil {
+		return nil, errors.E(err, "failed to read files in %s", cfgdir)
+	}
+
+	tree := config.NewTree(cfgdir)
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name() == config.SkipFilename {
+			return tree, nil
+		}
+	}
+
+	tree.Node = s.parseDir(rootdir, cfgdir)
+	for _, dirEntry := range dirEntries {
+		if config.Skip(dirEntry.Name()) || !dirEntry.IsDir() {
+			continue
+		}
+
+		node, err := s.loadTree(rootdir, filepath.Join(cfgdir, dirEntry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		node.Parent = tree
+		tree.Children[dirEntry.Name()] = node
+	}
+	return tree, nil
+}
+
+// parseDir parses the Terramate configuration of the directory dir.
+// An empty configuration is returned if the directory has errors.
+func (s *Server) parseDir(rootdir string, dir string) hcl.Config {
+	logger := log.With().
+		Str("action", "server.parseDir()").
+		Str("dir", dir).
+		Logger()
+
+	empty, _ := hcl.NewConfig(dir)
+	parser, err := s.newParser(rootdir, dir, s.dirFiles(dir), map[string]bool{})
+	if err != nil {
+		logger.Debug().Err(err).Msg("creating parser")
+		return empty
+	}
+
+	cfg, err := parser.ParseConfig()
+	if err != nil {
+		logger.Debug().Err(err).Msg("ignoring invalid configuration")
+		return empty
+	}
+	return cfg
+}
+
+// newParser creates a Terramate parser for the files of the directory dir with
+// their imports already resolved. The Terramate parser reads the imported
+// files from disk, so the import blocks are blanked out of the files given to
+// it and the imported files are parsed here from their buffers instead. The
+// visited files detect the files imported more than once.
+func (s *Server) newParser(
+	rootdir string,
+	dir string,
+	files []string,
+	visited map[string]bool,
+) (*hcl.TerramateParser, error) {
+	parser, err := hcl.NewTerramateParser(rootdir, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []string
+	for _, fname := range files {
+		visited[fname] = true
+		content, err := s.documents.read(fname)
+		if err != nil {
+			return nil, errors.E(err, "reading file %q", fname)
+		}
+		content, imported, err := stripImports(rootdir, fname, content)
+		if err != nil {
+			return nil, err
+		}
+		if err := parser.AddFileContent(fname, content); err != nil {
+			return nil, err
+		}
+		sources = append(sources, imported...)
+	}
+
+	for _, src := range sources {
+		srcdir := filepath.Dir(src)
+		if srcdir == dir || strings.HasPrefix(dir, srcdir+string(filepath.Separator)) {
+			return nil, errors.E(hcl.ErrImport,
+				"importing %q from the same directory tree is not permitted", src)
+		}
+		if visited[src] {
+			return nil, errors.E(hcl.ErrImport, "file %q already parsed", src)
+		}
+
+		importParser, err := s.newParser(rootdir, srcdir, []string{src}, visited)
+		if err != nil {
+			return nil, errors.E(hcl.ErrImport, err, "importing %q", src)
+		}
+		if err := importParser.Parse(); err != nil {
+			return nil, err
+		}
+		for _, block := range importParser.Config.UnmergedBlocks {
+			if block.Type == "stack" {
+				return nil, errors.E(hcl.ErrImport,
+					"import of stack block is not permitted in %q", src)
+			}
+		}
+		errs := errors.L()
+		errs.Append(parser.Imported.Merge(importParser.Imported))
+		errs.Append(parser.Imported.Merge(importParser.Config))
+		if err := errs.AsError(); err != nil {
+			return nil, errors.E(hcl.ErrImport, err, "failed to merge imported configuration")
+		}
+	}
+	return parser, nil
+}
+
+// stripImports returns the content of the file fname with its import blocks
+// replaced by blanks, keeping the ranges of the other blocks, and the host
+// paths of the files imported by them. Files with syntax errors are returned
+// unchanged for the parser to report them.
+func stripImports(rootdir string, fname string, content []byte) ([]byte, []string, error) {
+	file, diags := hclsyntax.ParseConfig(content, fname, hhcl.InitialPos)
+	if diags.HasErrors() {
+		return content, nil, nil
+	}
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return content, nil, nil
+	}
+
+	stripped := append([]byte{}, content...)
+	var sources []string
+	for _, block := range body.Blocks {
+		if block.Type != "import" {
+			continue
+		}
+		attr, ok := block.Body.Attributes["source"]
+		if len(block.Labels) != 0 || !ok || len(block.Body.Attributes) != 1 ||
+			len(block.Body.Blocks) != 0 {
+			return nil, nil, errors.E(hcl.ErrTerramateSchema, block.DefRange(),
+				"import must have no labels and only the source attribute")
+		}
+		val, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() || val.Type() != cty.String || val.IsNull() {
+			return nil, nil, errors.E(hcl.ErrTerramateSchema, attr.Expr.Range(),
+				"import.source must be a string")
+		}
+		sources = append(sources, resolvePath(rootdir, filepath.Dir(fname), val.AsString()))
+
+		rng := block.Range()
+		for i := rng.Start.Byte; i < rng.End.Byte; i++ {
+			if stripped[i] != '\n' {
+				stripped[i] = ' '
+			}
+		}
+	}
+	return stripped, sources, nil
+}
+
+// dirFiles returns the sorted list of Terramate files of the directory dir,
+// including the opened files not yet saved to disk.
+func (s *Server) dirFiles(dir string) []string {
+	files := map[string]struct{}{}
+	dirEntries, err := os.ReadDir(dir)
+	if err == nil {
+		for _, dirEntry := range dirEntries {
+			name := dirEntry.Name()
+			if !dirEntry.IsDir() && !config.Skip(name) && isTerramateFile(name) {
+				files[filepath.Join(dir, name)] = struct{}{}
+			}
+		}
+	}
+	for _, fname := range s.documents.list() {
+		if filepath.Dir(fname) == dir && isTerramateFile(fname) {
+			files[fname] = struct{}{}
+		}
+	}
+
+	list := make([]string, 0, len(files))
+	for fname := range files {
+		list = append(list, fname)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// stackAt returns the stack defined at the host directory dir.
+func (p *projectState) stackAt(dir string) (*stack.S, bool) {
+	prjdir := project.PrjAbsPath(p.rootdir, dir)
+	for _, st := range p.stacks {
+		if st.Path() == prjdir {
+			return st, true
+		}
+	}
+	return nil, false
+}
+
+// hostPath returns the host path of the project path.
+func (p *projectState) hostPath(path project.Path) string {
+	return filepath.Join(p.rootdir, filepath.FromSlash(path.String()))
+}
+
+// contains tells if the host path is inside the project.
+func (p *projectState) contains(path string) bool {
+	return path == p.rootdir || strings.HasPrefix(path, p.rootdir+string(filepath.Separator))
+}
+
+// metadataFor returns the terramate namespace for the host directory dir.
+// The stack metadata is only available if dir is a stack.
+func (p *projectState) metadataFor(dir string) map[string]cty.Value {
+	if st, ok := p.stackAt(dir); ok {
+		return stack.MetadataToCtyValues(p.metadata, st)
+	}
+	return p.metadata.ToCtyMap()
+}
+
+// globalsFor evaluates the globals of the host directory dir.
+// If dir is a stack then its metadata is available during the evaluation.
+func (p *projectState) globalsFor(dir string) globals.EvalReport {
+	if st, ok := p.stackAt(dir); ok {
+		return stack.LoadStackGlobals(p.root, p.metadata, st)
+	}
+
+	ctx, err := eval.NewContext(dir)
+	if err != nil {
+		report := globals.NewEvalReport()
+		report.BootstrapErr = err
+		return report
+	}
+	ctx.SetNamespace("terramate", p.metadata.ToCtyMap())
+	return globals.Load(p.root, project.PrjAbsPath(p.rootdir, dir), ctx)
+}
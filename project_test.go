@@ -0,0 +1,189 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	"github.com/mineiros-io/terramate/config"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/mineiros-io/terramate/stack"
+	lsp "go.lsp.dev/protocol"
+)
+
+// TestImportsLikeTerramate checks the imports resolved by the language server,
+// which reads the imported files from the editor buffers, against the ones
+// resolved by Terramate from disk.
+func TestImportsLikeTerramate(t *testing.T) {
+	type file struct {
+		path    string
+		content string
+	}
+	type testcase struct {
+		name  string
+		files []file
+
+		// want is the hint of global.value in the stack /stacks/a, which is
+		// empty if the configuration of the stack is invalid.
+		want string
+	}
+
+	imports := func(sources ...string) string {
+		var code string
+		for _, src := range sources {
+			code += fmt.Sprintf("import {\n  source = %q\n}\n", src)
+		}
+		return code
+	}
+	value := func(v string) string {
+		return fmt.Sprintf("globals {\n  value = %q\n}\n", v)
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "import in the stack directory",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/modules/g.tm")},
+				{"modules/g.tm", value("module")},
+			},
+			want: `= "module"`,
+		},
+		{
+			name: "import in a parent directory",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n"},
+				{"stacks/imports.tm", imports("/modules/g.tm")},
+				{"modules/g.tm", value("module")},
+			},
+			want: `= "module"`,
+		},
+		{
+			name: "same import in the stack and a parent directory",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/modules/g.tm")},
+				{"stacks/imports.tm", imports("../modules/g.tm")},
+				{"modules/g.tm", value("module")},
+			},
+			want: `= "module"`,
+		},
+		{
+			name: "nested imports",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/m1/x.tm")},
+				{"m1/x.tm", imports("../m2/y.tm")},
+				{"m2/y.tm", value("nested")},
+			},
+			want: `= "nested"`,
+		},
+		{
+			name: "import from a child directory",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("sub/g.tm")},
+				{"stacks/a/sub/g.tm", value("child")},
+			},
+			want: `= "child"`,
+		},
+		{
+			name: "diamond imports",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/m1/x.tm", "/m2/y.tm")},
+				{"m1/x.tm", imports("/m3/z.tm")},
+				{"m2/y.tm", imports("/m3/z.tm")},
+				{"m3/z.tm", value("z")},
+			},
+		},
+		{
+			name: "file imported twice by the directory",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/modules/g.tm")},
+				{"stacks/a/other.tm", imports("/modules/g.tm")},
+				{"modules/g.tm", value("module")},
+			},
+		},
+		{
+			name: "import cycle",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/m1/x.tm")},
+				{"m1/x.tm", imports("/m2/y.tm") + value("x")},
+				{"m2/y.tm", imports("/m1/x.tm")},
+			},
+		},
+		{
+			name: "import of a stack block",
+			files: []file{
+				{"stacks/a/stack.tm", "stack {}\n" + imports("/modules/g.tm")},
+				{"modules/g.tm", "stack {}\n" + value("module")},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, test.RootConfig)
+			rootdir := f.Sandbox.RootDir()
+			for _, file := range tc.files {
+				writeFile(t, rootdir, file.path, file.content)
+			}
+			writeFile(t, rootdir, "stacks/a/use.tm",
+				"generate_hcl \"a.tf\" {\n  content {\n    v = global.value\n  }\n}\n")
+			f.Editor.CheckInitialize(rootdir)
+
+			if got := terramateValueHint(t, rootdir); got != tc.want {
+				t.Fatalf("want %q loaded by Terramate, got %q", tc.want, got)
+			}
+
+			var want []string
+			if tc.want != "" {
+				want = []string{tc.want}
+			}
+			var got []string
+			rng := lsp.Range{End: lsp.Position{Line: 100}}
+			for _, hint := range f.Editor.InlayHints("stacks/a/use.tm", rng) {
+				got = append(got, hint.Label)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("hints mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+// terramateValueHint returns the hint of global.value in the stack /stacks/a,
+// as loaded by Terramate. It is empty if Terramate fails to load the stack.
+func terramateValueHint(t *testing.T, rootdir string) string {
+	t.Helper()
+	root, err := config.LoadRoot(rootdir)
+	if err != nil {
+		return ""
+	}
+	stacks, err := stack.LoadAll(root.Tree())
+	if err != nil {
+		return ""
+	}
+	for _, st := range stacks {
+		if st.Path() != project.NewPath("/stacks/a") {
+			continue
+		}
+		report := stack.LoadStackGlobals(root, stack.NewProjectMetadata(rootdir, stacks), st)
+		if report.AsError() != nil {
+			return ""
+		}
+		if val, ok := report.Globals.AsValueMap()["value"]; ok {
+			return fmt.Sprintf("= %q", val.AsString())
+		}
+	}
+	return ""
+}
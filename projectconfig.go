@@ -0,0 +1,196 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/errors"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// projectConfigFilename is the name of the file, at the root of a project,
+// with the configuration of the language server shared by everyone working on
+// the project. Terramate ignores it, as it is not a Terramate file.
+const projectConfigFilename = ".terramate-ls.hcl"
+
+// projectConfig is the configuration of the language server for a project:
+//
+//	lint {
+//	  relative-stack-path = "warning"
+//	  unknown-function    = "off"
+//	}
+//
+//	exclude = ["/modules/*"]
+//
+//	format {
+//	  enabled = false
+//	}
+//
+// The lint severities take precedence over the lint settings of the editor
+// and the excluded paths are ignored in addition to the ignore setting.
+type projectConfig struct {
+	lint    map[string]string
+	exclude []string
+
+	// formatDisabled tells if the files of the project are not formatted.
+	// The files are formatted as `terramate fmt` does, without options.
+	formatDisabled bool
+}
+
+// loadedProjectConfig is a project configuration with the modification time
+// and the size of its file, so it is loaded again when the file changes.
+type loadedProjectConfig struct {
+	modTime time.Time
+	size    int64
+	cfg     projectConfig
+}
+
+// projectConfig returns the configuration of the project rootdir, which is
+// empty if the project has no configuration file or if it is invalid.
+func (s *Server) projectConfig(rootdir string) projectConfig {
+	fname := filepath.Join(rootdir, projectConfigFilename)
+	st, err := os.Stat(fname)
+
+	s.projectConfigsMu.Lock()
+	defer s.projectConfigsMu.Unlock()
+
+	if err != nil {
+		delete(s.projectConfigs, rootdir)
+		return projectConfig{}
+	}
+	if loaded, ok := s.projectConfigs[rootdir]; ok &&
+		loaded.modTime.Equal(st.ModTime()) && loaded.size == st.Size() {
+		return loaded.cfg
+	}
+
+	var cfg projectConfig
+	content, err := os.ReadFile(fname)
+	if err == nil {
+		cfg, err = parseProjectConfig(fname, content)
+	}
+	if err != nil {
+		s.log.Error().Err(err).Str("file", fname).Msg("ignoring invalid project configuration")
+		cfg = projectConfig{}
+	}
+	s.projectConfigs[rootdir] = loadedProjectConfig{
+		modTime: st.ModTime(),
+		size:    st.Size(),
+		cfg:     cfg,
+	}
+	return cfg
+}
+
+// parseProjectConfig parses the content of the project configuration file.
+func parseProjectConfig(fname string, content []byte) (projectConfig, error) {
+	cfg := projectConfig{}
+	file, diags := hclsyntax.ParseConfig(content, fname, hhcl.InitialPos)
+	if diags.HasErrors() {
+		return cfg, errors.E(diags)
+	}
+	body := file.Body.(*hclsyntax.Body)
+
+	for _, attr := range sortedAttributes(body.Attributes) {
+		if attr.Name != "exclude" {
+			return cfg, errors.E(attr.NameRange, "unknown attribute %q", attr.Name)
+		}
+		patterns, err := stringValues(attr)
+		if err != nil {
+			return cfg, err
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return cfg, errors.E(attr.Expr.Range(), err, "invalid pattern %q", pattern)
+			}
+		}
+		cfg.exclude = patterns
+	}
+
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "lint":
+			cfg.lint = map[string]string{}
+			for _, attr := range sortedAttributes(block.Body.Attributes) {
+				severity, ok := stringLiteral(attr.Expr)
+				if _, valid := lintSeverities[severity]; !ok || !valid {
+					return cfg, errors.E(attr.Expr.Range(),
+						"lint severity must be error, warning, information, hint or off")
+				}
+				cfg.lint[attr.Name] = severity
+			}
+		case "format":
+			for _, attr := range sortedAttributes(block.Body.Attributes) {
+				if attr.Name != "enabled" {
+					return cfg, errors.E(attr.NameRange, "unknown attribute %q", attr.Name)
+				}
+				value, diags := attr.Expr.Value(nil)
+				if diags.HasErrors() || value.Type() != cty.Bool || value.IsNull() {
+					return cfg, errors.E(attr.Expr.Range(), "format.enabled must be a boolean")
+				}
+				cfg.formatDisabled = value.False()
+			}
+		default:
+			return cfg, errors.E(block.TypeRange, "unknown block %q", block.Type)
+		}
+	}
+	return cfg, nil
+}
+
+// stringValues returns the strings of the list or tuple of the attribute.
+func stringValues(attr *hclsyntax.Attribute) ([]string, error) {
+	value, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() {
+		return nil, errors.E(diags)
+	}
+	if !value.Type().IsListType() && !value.Type().IsTupleType() {
+		return nil, errors.E(attr.Expr.Range(), "%s must be a list of strings", attr.Name)
+	}
+
+	var values []string
+	for it := value.ElementIterator(); it.Next(); {
+		_, elem := it.Element()
+		if elem.Type() != cty.String || elem.IsNull() {
+			return nil, errors.E(attr.Expr.Range(), "%s must be a list of strings", attr.Name)
+		}
+		values = append(values, elem.AsString())
+	}
+	return values, nil
+}
+
+// settingsFor returns the settings of the project rootdir, which are the
+// editor settings with the project configuration applied.
+func (s *Server) settingsFor(rootdir string) settings {
+	cfg := s.settings()
+	prjcfg := s.projectConfig(rootdir)
+	if len(prjcfg.lint) > 0 {
+		lint := map[string]string{}
+		for code, severity := range cfg.Lint {
+			lint[code] = severity
+		}
+		for code, severity := range prjcfg.lint {
+			lint[code] = severity
+		}
+		cfg.Lint = lint
+	}
+	if len(prjcfg.exclude) > 0 {
+		cfg.Ignore = append(append([]string{}, cfg.Ignore...), prjcfg.exclude...)
+	}
+	return cfg
+}
@@ -0,0 +1,117 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestProjectConfig(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {\n  after = [\"../b\"]\n}\n\nglobals {\n  a   = tm_uper(\"a\")\n}\n",
+		"f:b/stack.tm:stack {}\n",
+		"f:excluded/stack.tm:stack {}\n\nglobals {\n  a = tm_uper(\"a\")\n}\n",
+	)
+	writeFile(t, f.Sandbox.RootDir(), ".terramate-ls.hcl",
+		"lint {\n  relative-stack-path = \"off\"\n}\n\n"+
+			"exclude = [\"/excluded\"]\n\n"+
+			"format {\n  enabled = false\n}\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	// the lint severities of the project take precedence.
+	f.Editor.ChangeConfiguration(map[string]interface{}{
+		"terramate": map[string]interface{}{
+			"lint": map[string]string{
+				"relative-stack-path": "warning",
+				"unknown-function":    "hint",
+			},
+		},
+	})
+	f.Editor.Open("a/stack.tm")
+	want := map[string]lsp.DiagnosticSeverity{
+		"unknown-function": lsp.DiagnosticSeverityHint,
+	}
+	if diff := cmp.Diff(want, diagnosticCodes(f.Editor.Diagnostics("a/stack.tm"))); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+	f.Editor.Open("excluded/stack.tm")
+	if diags := f.Editor.Diagnostics("excluded/stack.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics of excluded files, got %+v", diags)
+	}
+	if edits := f.Editor.Formatting("a/stack.tm"); len(edits) != 0 {
+		t.Fatalf("want no formatting, got %+v", edits)
+	}
+
+	// the configuration is loaded again when it changes.
+	writeFile(t, f.Sandbox.RootDir(), ".terramate-ls.hcl", "exclude = []\n")
+	f.Editor.ChangeWatchedFiles(lsp.FileChangeTypeChanged, ".terramate-ls.hcl")
+	want = map[string]lsp.DiagnosticSeverity{
+		"relative-stack-path": lsp.DiagnosticSeverityWarning,
+		"unknown-function":    lsp.DiagnosticSeverityHint,
+	}
+	if diff := cmp.Diff(want, diagnosticCodes(f.Editor.Diagnostics("a/stack.tm"))); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+	if diags := f.Editor.Diagnostics("excluded/stack.tm"); len(diags) == 0 {
+		t.Fatal("want the unknown function")
+	}
+	if edits := f.Editor.Formatting("a/stack.tm"); len(edits) != 1 {
+		t.Fatalf("want the file formatted, got %+v", edits)
+	}
+	drainRequests(f.Editor)
+}
+
+func TestProjectConfigWithoutWatcher(t *testing.T) {
+	f := test.Setup(t,
+		test.RootConfig,
+		"f:excluded/stack.tm:stack {}\n",
+	)
+	writeFile(t, f.Sandbox.RootDir(), ".terramate-ls.hcl", "exclude = [\"/excluded\"]\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	if syms := f.Editor.WorkspaceSymbols("/excluded"); len(syms) != 0 {
+		t.Fatalf("want no symbols of excluded files, got %+v", syms)
+	}
+
+	// the editor does not notify the change, but the index follows the
+	// configuration file.
+	writeFile(t, f.Sandbox.RootDir(), ".terramate-ls.hcl", "exclude = []\n")
+	if syms := f.Editor.WorkspaceSymbols("/excluded"); len(syms) != 1 {
+		t.Fatalf("want the stack of the file not excluded anymore, got %+v", syms)
+	}
+}
+
+func TestProjectConfigInvalid(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {}\n\nglobals {\n  a = tm_uper(\"a\")\n}\n",
+	)
+	writeFile(t, f.Sandbox.RootDir(), ".terramate-ls.hcl",
+		"lint {\n  unknown-function = \"off\"\n}\n\nunknown = true\n")
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	// invalid configurations are ignored.
+	f.Editor.Open("a/stack.tm")
+	want := map[string]lsp.DiagnosticSeverity{
+		"unknown-function": lsp.DiagnosticSeverityError,
+	}
+	if diff := cmp.Diff(want, diagnosticCodes(f.Editor.Diagnostics("a/stack.tm"))); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+	drainRequests(f.Editor)
+}
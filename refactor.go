@@ -0,0 +1,534 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog/log"
+	lsp "go.lsp.dev/protocol"
+)
+
+// dirDependentFunctions are the functions whose result depends on the directory
+// of the file calling them, so expressions calling them can't be moved to
+// other directories.
+var dirDependentFunctions = map[string]bool{
+	"tm_abspath":          true,
+	"tm_file":             true,
+	"tm_fileexists":       true,
+	"tm_fileset":          true,
+	"tm_filebase64":       true,
+	"tm_filebase64sha256": true,
+	"tm_filebase64sha512": true,
+	"tm_filemd5":          true,
+	"tm_filesha1":         true,
+	"tm_filesha256":       true,
+	"tm_filesha512":       true,
+	"tm_templatefile":     true,
+	"tm_vendor":           true,
+}
+
+// refactorings returns the refactoring code actions of the kinds in only (or
+// all if only is empty) available at the range of the file.
+func (s *Server) refactorings(fname string, rng lsp.Range, only []lsp.CodeActionKind) []codeAction {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Debug().Err(err).Str("file", fname).Msg("reading file for refactorings")
+		return nil
+	}
+
+	body := parseBody(fname, content)
+	start := offsetFor(content, rng.Start)
+	end := offsetFor(content, rng.End)
+
+	var p *projectState
+	loadPrj := func() *projectState {
+		if p == nil {
+			p, err = s.loadProject(filepath.Dir(fname))
+			if err != nil {
+				log.Debug().Err(err).Msg("loading project for refactorings")
+			}
+		}
+		return p
+	}
+
+	var actions []codeAction
+	if wantsKind(only, lsp.RefactorExtract) && start != end {
+		if expr, ok := extractableExpr(body, start, end); ok && loadPrj() != nil {
+			actions = append(actions, s.extractGlobal(p, fname, content, expr)...)
+		}
+	}
+	if wantsKind(only, lsp.RefactorInline) {
+		if expr, ok := traversalAt(body, start); ok && expr.Traversal.RootName() == "global" &&
+			loadPrj() != nil {
+			actions = append(actions, s.inlineGlobal(p, fname, expr)...)
+		}
+	}
+	if wantsKind(only, lsp.RefactorRewrite) {
+		if path, nameRange, ok := globalAt(body, start); ok && !isTraversal(body, start) &&
+			loadPrj() != nil {
+			if action, ok := s.moveGlobalUp(p, fname, path, nameRange); ok {
+				actions = append(actions, action)
+			}
+		}
+	}
+	return actions
+}
+
+// isTraversal tells if the byte offset is inside a traversal expression.
+func isTraversal(body *hclsyntax.Body, offset int) bool {
+	_, ok := traversalAt(body, offset)
+	return ok
+}
+
+// extractableExpr returns the innermost expression containing the byte range
+// [start, end] which can be extracted to a global. Only expressions inside
+// globals and generate blocks which depend on nothing but globals, metadata
+// and Terramate functions are extractable.
+func extractableExpr(body *hclsyntax.Body, start, end int) (hclsyntax.Expression, bool) {
+	blocks := blocksAt(body, start)
+	if len(blocks) == 0 {
+		return nil, false
+	}
+	switch blocks[0].Type {
+	case "globals", "generate_hcl", "generate_file":
+	default:
+		return nil, false
+	}
+
+	var found hclsyntax.Expression
+	for _, node := range nodesAt(body, start) {
+		expr, ok := node.(hclsyntax.Expression)
+		if !ok || node.Range().End.Byte < end {
+			continue
+		}
+		if _, ok := expr.(*hclsyntax.ObjectConsKeyExpr); ok {
+			return nil, false
+		}
+		if _, ok := found.(*hclsyntax.TemplateExpr); ok {
+			if _, ok := expr.(*hclsyntax.LiteralValueExpr); ok {
+				// the literal parts of a template are not expressions by
+				// themselves.
+				break
+			}
+		}
+		found = expr
+	}
+	if found == nil {
+		return nil, false
+	}
+
+	extractable := true
+	locals := map[string]bool{}
+	_ = hclsyntax.VisitAll(found, func(node hclsyntax.Node) hhcl.Diagnostics {
+		switch n := node.(type) {
+		case *hclsyntax.ForExpr:
+			locals[n.KeyVar] = true
+			locals[n.ValVar] = true
+		case *hclsyntax.FunctionCallExpr:
+			if !strings.HasPrefix(n.Name, "tm_") {
+				extractable = false
+			}
+		case *hclsyntax.ScopeTraversalExpr:
+			switch root := n.Traversal.RootName(); root {
+			case "global", "terramate":
+			default:
+				if !locals[root] {
+					extractable = false
+				}
+			}
+		}
+		return nil
+	})
+	return found, extractable
+}
+
+// extractGlobal returns the actions extracting the expression to a new global
+// in the directory of the file or in any of its parent directories where the
+// globals used by the expression are defined.
+func (s *Server) extractGlobal(p *projectState, fname string, content []byte, expr hclsyntax.Expression) []codeAction {
+	var refs [][]string
+	dirDependent := false
+	_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hhcl.Diagnostics {
+		switch n := node.(type) {
+		case *hclsyntax.FunctionCallExpr:
+			dirDependent = dirDependent || dirDependentFunctions[n.Name]
+		case *hclsyntax.ScopeTraversalExpr:
+			if path := traversalPath(n.Traversal); n.Traversal.RootName() == "global" && len(path) > 0 {
+				refs = append(refs, path)
+			}
+		}
+		return nil
+	})
+
+	name := s.unusedGlobalName(p.rootdir, "extracted")
+	text := string(content[expr.Range().Start.Byte:expr.Range().End.Byte])
+
+	var actions []codeAction
+	dir := project.PrjAbsPath(p.rootdir, filepath.Dir(fname))
+	for target := dir; ; target = target.Dir() {
+		hostdir := p.hostPath(target)
+		visible := true
+		for _, ref := range refs {
+			if _, ok := p.effectiveGlobal(hostdir, ref); !ok {
+				visible = false
+			}
+		}
+		if target != dir && (dirDependent || !visible) {
+			break
+		}
+
+		edits := newFileEdits()
+		edits.add(fname, lsp.TextEdit{
+			Range:   lspRange(expr.Range()),
+			NewText: "global." + name,
+		})
+		s.addGlobal(edits, hostdir, fname, nil, name, text)
+		actions = append(actions, codeAction{
+			Title: fmt.Sprintf("Extract to global.%s in %s", name, target),
+			Kind:  lsp.RefactorExtract,
+			Edit:  edits.workspaceEdit(),
+		})
+
+		if target.Dir() == target {
+			break
+		}
+	}
+	return actions
+}
+
+// unusedGlobalName returns a global name, starting with prefix, which is not
+// defined nor referenced anywhere in the project.
+func (s *Server) unusedGlobalName(rootdir string, prefix string) string {
+	used := map[string]bool{}
+	s.index(rootdir).forEach(func(fname string, symbols *fileSymbols) {
+		for _, def := range symbols.globalDefs {
+			used[def.path[0]] = true
+		}
+		for _, ref := range symbols.globalRefs {
+			used[ref.path[0]] = true
+		}
+	})
+
+	name := prefix
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s_%d", prefix, i)
+	}
+	return name
+}
+
+// inlineGlobal returns the actions replacing the global reference with the
+// expression of its definition, and replacing all the references of the
+// definition. The definition is removed if nothing else depends on it.
+func (s *Server) inlineGlobal(p *projectState, fname string, ref *hclsyntax.ScopeTraversalExpr) []codeAction {
+	path := traversalPath(ref.Traversal)
+	if len(path) == 0 {
+		return nil
+	}
+	dir := filepath.Dir(fname)
+	def, ok := p.effectiveGlobal(dir, path)
+	if !ok || def.expr == nil || !equalPaths(def.path, path) {
+		return nil
+	}
+	if def.rng.Filename == fname && def.rng.Overlaps(ref.SrcRange) {
+		return nil
+	}
+	defContent, err := s.documents.read(def.rng.Filename)
+	if err != nil {
+		return nil
+	}
+
+	defExpr, ok := def.expr.(hclsyntax.Expression)
+	if !ok {
+		return nil
+	}
+	dirDependent := false
+	_ = hclsyntax.VisitAll(defExpr, func(node hclsyntax.Node) hhcl.Diagnostics {
+		if call, ok := node.(*hclsyntax.FunctionCallExpr); ok && dirDependentFunctions[call.Name] {
+			dirDependent = true
+		}
+		return nil
+	})
+
+	text := string(defContent[def.expr.Range().Start.Byte:def.expr.Range().End.Byte])
+	if needsParens(defExpr) {
+		text = "(" + text + ")"
+	}
+	inline := func(refdir string, rng hhcl.Range, nameRanges []hhcl.Range) (lsp.TextEdit, bool) {
+		if dirDependent && project.PrjAbsPath(p.rootdir, refdir) != def.dir {
+			return lsp.TextEdit{}, false
+		}
+		return lsp.TextEdit{
+			Range:   lspRange(hhcl.RangeBetween(rng, nameRanges[len(path)-1])),
+			NewText: text,
+		}, true
+	}
+
+	edit, ok := inline(dir, ref.SrcRange, traversalNameRanges(ref.Traversal))
+	if !ok {
+		return nil
+	}
+	single := newFileEdits()
+	single.add(fname, edit)
+	actions := []codeAction{
+		{
+			Title: fmt.Sprintf("Inline %s", def.name()),
+			Kind:  lsp.RefactorInline,
+			Edit:  single.workspaceEdit(),
+		},
+	}
+
+	all := newFileEdits()
+	removable := true
+	s.globalUses(p, def, func(reffile string, use globalRef) {
+		if !equalPaths(use.path, path) {
+			removable = false
+			return
+		}
+		if edit, ok := inline(filepath.Dir(reffile), use.rng, use.nameRanges); ok {
+			all.add(reffile, edit)
+		} else {
+			removable = false
+		}
+	})
+	s.index(p.rootdir).forEach(func(reffile string, symbols *fileSymbols) {
+		for _, use := range symbols.globalRefs {
+			if isPathPrefix(use.path, path) && !equalPaths(use.path, path) {
+				// the parent objects of the global include it.
+				removable = false
+			}
+		}
+	})
+	if removable {
+		removeGlobal(all, def.rng.Filename, defContent, def.nameRange)
+	}
+	actions = append(actions, codeAction{
+		Title: fmt.Sprintf("Inline all references to %s", def.name()),
+		Kind:  lsp.RefactorInline,
+		Edit:  all.workspaceEdit(),
+	})
+	return actions
+}
+
+// needsParens tells if the expression must be wrapped in parentheses when it
+// replaces a reference inside another expression.
+func needsParens(expr hclsyntax.Expression) bool {
+	switch expr.(type) {
+	case *hclsyntax.LiteralValueExpr, *hclsyntax.TemplateExpr, *hclsyntax.TemplateWrapExpr,
+		*hclsyntax.TupleConsExpr, *hclsyntax.ObjectConsExpr, *hclsyntax.ScopeTraversalExpr,
+		*hclsyntax.FunctionCallExpr, *hclsyntax.ParenthesesExpr:
+		return false
+	}
+	return true
+}
+
+// moveGlobalUp returns the action moving the global, defined with the same
+// expression in the sibling stacks, to their parent directory. The global is
+// only moved if no stack inside the parent directory would see a different
+// definition after moving it.
+func (s *Server) moveGlobalUp(p *projectState, fname string, path []string, nameRange hhcl.Range) (codeAction, bool) {
+	dir := filepath.Dir(fname)
+	def, ok := p.effectiveGlobal(dir, path)
+	if !ok || def.expr == nil || def.nameRange.Filename != fname ||
+		def.nameRange.Start.Byte != nameRange.Start.Byte {
+		return codeAction{}, false
+	}
+	parent := def.dir.Dir()
+	if parent == def.dir {
+		return codeAction{}, false
+	}
+	for _, other := range p.dirGlobals(parent) {
+		if isPathPrefix(other.path, path) || isPathPrefix(path, other.path) {
+			return codeAction{}, false
+		}
+	}
+
+	exprTokens := func(def globalDefinition) (string, bool) {
+		content, err := s.documents.read(def.rng.Filename)
+		if err != nil || def.expr == nil {
+			return "", false
+		}
+		return normalizedExpr(content[def.expr.Range().Start.Byte:def.expr.Range().End.Byte]), true
+	}
+	want, ok := exprTokens(def)
+	if !ok {
+		return codeAction{}, false
+	}
+
+	var moved []globalDefinition
+	for _, st := range p.stacks {
+		if st.Path().Dir() != parent || st.Path() == parent {
+			continue
+		}
+		for _, other := range p.dirGlobals(st.Path()) {
+			if !equalPaths(other.path, path) {
+				continue
+			}
+			if got, ok := exprTokens(other); ok && got == want {
+				moved = append(moved, other)
+			}
+		}
+	}
+	if len(moved) < 2 {
+		return codeAction{}, false
+	}
+
+	for _, st := range p.stacks {
+		if !isParentOrSelf(parent, st.Path()) {
+			continue
+		}
+		eff, ok := p.effectiveGlobal(st.HostPath(), path)
+		if !ok || eff.dir == parent || !isParentOrSelf(parent, eff.dir) {
+			return codeAction{}, false
+		}
+	}
+
+	edits := newFileEdits()
+	for _, other := range moved {
+		content, err := s.documents.read(other.rng.Filename)
+		if err != nil {
+			return codeAction{}, false
+		}
+		removeGlobal(edits, other.rng.Filename, content, other.nameRange)
+	}
+	content, _ := s.documents.read(fname)
+	text := string(content[def.expr.Range().Start.Byte:def.expr.Range().End.Byte])
+	hostdir := p.hostPath(parent)
+	s.addGlobal(edits, hostdir, "", path[:len(path)-1], path[len(path)-1], text)
+
+	return codeAction{
+		Title: fmt.Sprintf("Move %s up to %s", def.name(), parent),
+		Kind:  lsp.RefactorRewrite,
+		Edit:  edits.workspaceEdit(),
+	}, true
+}
+
+// normalizedExpr returns the tokens of the expression source separated by a
+// single space, so expressions differing only in formatting are equal.
+func normalizedExpr(src []byte) string {
+	tokens, _ := hclsyntax.LexExpression(src, "", hhcl.InitialPos)
+	var parts []string
+	for _, tok := range tokens {
+		switch tok.Type {
+		case hclsyntax.TokenNewline, hclsyntax.TokenComment, hclsyntax.TokenEOF:
+			continue
+		}
+		parts = append(parts, string(tok.Bytes))
+	}
+	return strings.Join(parts, " ")
+}
+
+// addGlobal adds to edits the definition of the global attribute name, with the
+// expression text, in a globals block with the labels of the host directory
+// dir. The first multi-line globals block with the labels is used, looking
+// first at the preferred file. If there is no such block, a new block is
+// added to the preferred file if it is in dir or to the globals.tm file of
+// dir, which is created if needed.
+func (s *Server) addGlobal(edits *fileEdits, dir string, preferred string, labels []string, name, text string) {
+	files := s.dirFiles(dir)
+	if filepath.Dir(preferred) == dir {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i] == preferred && files[j] != preferred
+		})
+	}
+
+	for _, fname := range files {
+		content, err := s.documents.read(fname)
+		if err != nil {
+			continue
+		}
+		for _, block := range parseBody(fname, content).Blocks {
+			if block.Type != "globals" || !equalPaths(block.Labels, labels) ||
+				block.CloseBraceRange.Start.Line == block.OpenBraceRange.Start.Line {
+				continue
+			}
+			indent := lineIndent(content, block.TypeRange.Start.Byte) + "  "
+			edits.add(fname, lsp.TextEdit{
+				Range: lsp.Range{
+					Start: lsp.Position{Line: uint32(block.CloseBraceRange.Start.Line - 1)},
+					End:   lsp.Position{Line: uint32(block.CloseBraceRange.Start.Line - 1)},
+				},
+				NewText: fmt.Sprintf("%s%s = %s\n", indent, name, text),
+			})
+			return
+		}
+	}
+
+	target := filepath.Join(dir, "globals.tm")
+	if filepath.Dir(preferred) == dir {
+		target = preferred
+	}
+	var header string
+	for _, label := range labels {
+		header += fmt.Sprintf(" %q", label)
+	}
+	block := fmt.Sprintf("globals%s {\n  %s = %s\n}\n", header, name, text)
+
+	content, err := s.documents.read(target)
+	if err != nil {
+		edits.create(target)
+		edits.add(target, lsp.TextEdit{NewText: block})
+		return
+	}
+	if len(content) > 0 {
+		block = "\n" + block
+		if !bytes.HasSuffix(content, []byte("\n")) {
+			block = "\n" + block
+		}
+	}
+	end := positionFor(content, len(content))
+	edits.add(target, lsp.TextEdit{
+		Range:   lsp.Range{Start: end, End: end},
+		NewText: block,
+	})
+}
+
+// removeGlobal adds to edits the removal of the global attribute with the name
+// range. If it is the only content of its globals block, the whole block is
+// removed.
+func removeGlobal(edits *fileEdits, fname string, content []byte, nameRange hhcl.Range) {
+	for _, block := range parseBody(fname, content).Blocks {
+		if block.Type != "globals" || !block.Range().Overlaps(nameRange) {
+			continue
+		}
+		for _, attr := range block.Body.Attributes {
+			if attr.NameRange.Start.Byte != nameRange.Start.Byte {
+				continue
+			}
+			rng := attr.Range()
+			if len(block.Body.Attributes) == 1 && len(block.Body.Blocks) == 0 {
+				rng = block.Range()
+			}
+			edits.add(fname, lsp.TextEdit{Range: lineRange(content, rng)})
+			return
+		}
+	}
+}
+
+// lineIndent returns the whitespace before the byte offset in its line.
+func lineIndent(content []byte, offset int) string {
+	start := offset
+	for start > 0 && (content[start-1] == ' ' || content[start-1] == '\t') {
+		start--
+	}
+	return string(content[start:offset])
+}
@@ -0,0 +1,309 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestRefactorings(t *testing.T) {
+	type testcase struct {
+		name   string
+		layout []string
+		file   string
+		rng    lsp.Range
+		kind   lsp.CodeActionKind
+		titles []string
+		title  string
+		want   map[string]string
+	}
+
+	at := func(line, start, end uint32) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: line, Character: start},
+			End:   lsp.Position{Line: line, Character: end},
+		}
+	}
+
+	generate := "stack {}\n\ngenerate_hcl \"x.tf\" {\n  content {\n    name = \"${global.env}-app\"\n  }\n}\n"
+
+	for _, tc := range []testcase{
+		{
+			name: "extract to the current directory",
+			layout: []string{
+				"f:globals.tm:globals {\n  env = \"prod\"\n}\n",
+				"f:stack/stack.tm:" + generate,
+			},
+			file: "stack/stack.tm",
+			rng:  at(4, 11, 30),
+			kind: lsp.RefactorExtract,
+			titles: []string{
+				"Extract to global.extracted in /stack",
+				"Extract to global.extracted in /",
+			},
+			title: "Extract to global.extracted in /stack",
+			want: map[string]string{
+				"stack/stack.tm": "stack {}\n\ngenerate_hcl \"x.tf\" {\n  content {\n    name = global.extracted\n  }\n}\n" +
+					"\nglobals {\n  extracted = \"${global.env}-app\"\n}\n",
+			},
+		},
+		{
+			name: "extract to an existing globals block of a parent directory",
+			layout: []string{
+				"f:globals.tm:globals {\n  env = \"prod\"\n}\n",
+				"f:stack/stack.tm:" + generate,
+			},
+			file:  "stack/stack.tm",
+			rng:   at(4, 11, 30),
+			kind:  lsp.RefactorExtract,
+			title: "Extract to global.extracted in /",
+			want: map[string]string{
+				"globals.tm":     "globals {\n  env = \"prod\"\n  extracted = \"${global.env}-app\"\n}\n",
+				"stack/stack.tm": "stack {}\n\ngenerate_hcl \"x.tf\" {\n  content {\n    name = global.extracted\n  }\n}\n",
+			},
+		},
+		{
+			name: "extract an interpolation with an unused name",
+			layout: []string{
+				"f:globals.tm:globals {\n  extracted = 1\n}\n",
+				"f:stack/stack.tm:stack {}\n\nglobals {\n  env = \"prod\"\n  name = \"${global.env}-app\"\n}\n",
+			},
+			file: "stack/stack.tm",
+			rng:  at(4, 12, 22),
+			kind: lsp.RefactorExtract,
+			titles: []string{
+				"Extract to global.extracted_2 in /stack",
+			},
+			title: "Extract to global.extracted_2 in /stack",
+			want: map[string]string{
+				"stack/stack.tm": "stack {}\n\nglobals {\n  env = \"prod\"\n  name = \"${global.extracted_2}-app\"\n  extracted_2 = global.env\n}\n",
+			},
+		},
+		{
+			name: "extract to a new globals file",
+			layout: []string{
+				"f:stack/stack.tm:stack {}\n",
+				"f:stack/other.tm:globals {\n  a = 1\n}\n",
+			},
+			file:  "stack/other.tm",
+			rng:   at(1, 6, 7),
+			kind:  lsp.RefactorExtract,
+			title: "Extract to global.extracted in /",
+			want: map[string]string{
+				"globals.tm":     "globals {\n  extracted = 1\n}\n",
+				"stack/other.tm": "globals {\n  a = global.extracted\n}\n",
+			},
+		},
+		{
+			name: "no extract outside globals and generate blocks",
+			layout: []string{
+				"f:stack/stack.tm:stack {\n  name = \"stack\"\n}\n",
+			},
+			file: "stack/stack.tm",
+			rng:  at(1, 9, 16),
+			kind: lsp.RefactorExtract,
+		},
+		{
+			name: "no extract of expressions depending on local names",
+			layout: []string{
+				"f:stack/stack.tm:stack {}\n\ngenerate_hcl \"x.tf\" {\n  content {\n    name = var.name\n  }\n}\n",
+			},
+			file: "stack/stack.tm",
+			rng:  at(4, 11, 19),
+			kind: lsp.RefactorExtract,
+		},
+		{
+			name: "inline a reference",
+			layout: []string{
+				"f:globals.tm:globals {\n  env = \"prod\"\n}\n",
+				"f:stack/stack.tm:stack {}\n\nglobals {\n  a = global.env\n  b = \"${global.env}-x\"\n}\n",
+			},
+			file: "stack/stack.tm",
+			rng:  at(3, 8, 8),
+			kind: lsp.RefactorInline,
+			titles: []string{
+				"Inline global.env",
+				"Inline all references to global.env",
+			},
+			title: "Inline global.env",
+			want: map[string]string{
+				"stack/stack.tm": "stack {}\n\nglobals {\n  a = \"prod\"\n  b = \"${global.env}-x\"\n}\n",
+			},
+		},
+		{
+			name: "inline all references and remove the definition",
+			layout: []string{
+				"f:globals.tm:globals {\n  env = \"prod\"\n  name = \"app\"\n}\n",
+				"f:stack/stack.tm:stack {}\n\nglobals {\n  a = global.env\n  b = \"${global.env}-x\"\n}\n",
+			},
+			file:  "stack/stack.tm",
+			rng:   at(3, 8, 8),
+			kind:  lsp.RefactorInline,
+			title: "Inline all references to global.env",
+			want: map[string]string{
+				"globals.tm":     "globals {\n  name = \"app\"\n}\n",
+				"stack/stack.tm": "stack {}\n\nglobals {\n  a = \"prod\"\n  b = \"${\"prod\"}-x\"\n}\n",
+			},
+		},
+		{
+			name: "inline wraps operations in parentheses",
+			layout: []string{
+				"f:globals.tm:globals {\n  n = 1 + 2\n  m = global.n * 3\n}\n",
+			},
+			file:  "globals.tm",
+			rng:   at(2, 10, 10),
+			kind:  lsp.RefactorInline,
+			title: "Inline global.n",
+			want: map[string]string{
+				"globals.tm": "globals {\n  n = 1 + 2\n  m = (1 + 2) * 3\n}\n",
+			},
+		},
+		{
+			name: "no inline of directory dependent definitions",
+			layout: []string{
+				"f:globals.tm:globals {\n  path = tm_abspath(\".\")\n}\n",
+				"f:stack/stack.tm:stack {}\n\nglobals {\n  a = global.path\n}\n",
+			},
+			file: "stack/stack.tm",
+			rng:  at(3, 8, 8),
+			kind: lsp.RefactorInline,
+		},
+		{
+			name: "move a global up",
+			layout: []string{
+				"f:stacks/a/stack.tm:stack {}\n\nglobals {\n  region = \"eu\"\n}\n",
+				"f:stacks/b/stack.tm:stack {}\n\nglobals {\n  region   =   \"eu\"\n  other = 1\n}\n",
+			},
+			file: "stacks/a/stack.tm",
+			rng:  at(3, 3, 3),
+			kind: lsp.RefactorRewrite,
+			titles: []string{
+				"Move global.region up to /stacks",
+			},
+			title: "Move global.region up to /stacks",
+			want: map[string]string{
+				"stacks/a/stack.tm": "stack {}\n\n",
+				"stacks/b/stack.tm": "stack {}\n\nglobals {\n  other = 1\n}\n",
+				"stacks/globals.tm": "globals {\n  region = \"eu\"\n}\n",
+			},
+		},
+		{
+			name: "no move up if a sibling stack has a different value",
+			layout: []string{
+				"f:stacks/a/stack.tm:stack {}\n\nglobals {\n  region = \"eu\"\n}\n",
+				"f:stacks/b/stack.tm:stack {}\n\nglobals {\n  region = \"us\"\n}\n",
+			},
+			file: "stacks/a/stack.tm",
+			rng:  at(3, 3, 3),
+			kind: lsp.RefactorRewrite,
+		},
+		{
+			name: "no move up if a stack would see the global",
+			layout: []string{
+				"f:stacks/a/stack.tm:stack {}\n\nglobals {\n  region = \"eu\"\n}\n",
+				"f:stacks/b/stack.tm:stack {}\n\nglobals {\n  region = \"eu\"\n}\n",
+				"f:stacks/c/stack.tm:stack {}\n",
+			},
+			file: "stacks/a/stack.tm",
+			rng:  at(3, 3, 3),
+			kind: lsp.RefactorRewrite,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			actions := f.Editor.CodeActions(tc.file, tc.rng, nil, tc.kind)
+			if tc.titles != nil || tc.title == "" {
+				var titles []string
+				for _, action := range actions {
+					titles = append(titles, action.Title)
+				}
+				if diff := cmp.Diff(tc.titles, titles); diff != "" {
+					t.Fatalf("titles mismatch, want(-) got(+):\n%s", diff)
+				}
+			}
+			if tc.title == "" {
+				return
+			}
+
+			for _, action := range actions {
+				if action.Title != tc.title {
+					continue
+				}
+				if action.Kind != tc.kind || action.Edit == nil {
+					t.Fatalf("invalid refactoring: %+v", action)
+				}
+				got := applyWorkspaceEdit(t, f.Sandbox.RootDir(), action.Edit)
+				if diff := cmp.Diff(tc.want, got); diff != "" {
+					t.Fatalf("refactored files mismatch, want(-) got(+):\n%s", diff)
+				}
+				return
+			}
+			t.Fatalf("no refactoring %q in %+v", tc.title, actions)
+		})
+	}
+}
+
+// applyWorkspaceEdit applies the workspace edit to the files of the root
+// directory and returns the resulting content of the edited files, by their
+// path relative to the root directory.
+func applyWorkspaceEdit(t *testing.T, rootdir string, edit *test.WorkspaceEdit) map[string]string {
+	t.Helper()
+
+	files := map[string]string{}
+	apply := func(u lsp.URI, edits []lsp.TextEdit) {
+		relpath, err := filepath.Rel(rootdir, u.Filename())
+		if err != nil {
+			t.Fatal(err)
+		}
+		relpath = filepath.ToSlash(relpath)
+		content, ok := files[relpath]
+		if !ok {
+			data, err := os.ReadFile(u.Filename())
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			content = string(data)
+		}
+		files[relpath] = applyEdits(content, edits)
+	}
+
+	for u, edits := range edit.Changes {
+		apply(u, edits)
+	}
+	for _, change := range edit.DocumentChanges {
+		if _, ok := change["textDocument"]; !ok {
+			continue
+		}
+		data, err := json.Marshal(change)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var docEdit lsp.TextDocumentEdit
+		if err := json.Unmarshal(data, &docEdit); err != nil {
+			t.Fatal(err)
+		}
+		apply(docEdit.TextDocument.URI, docEdit.Edits)
+	}
+	return files
+}
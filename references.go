@@ -0,0 +1,181 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleReferences(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.ReferenceParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	locations, err := s.references(fname, params.Position, params.Context.IncludeDeclaration)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to find references")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, locations, nil)
+}
+
+// references returns the locations referencing the symbol at the given
+// position of the file. The supported symbols are:
+//   - globals, either a reference or its definition, which returns all the
+//     global.<path> references resolving to the same definition.
+//   - stacks, when the position is inside a stack block or at one of its
+//     after, before, wants and wanted_by paths, which returns all the stack
+//     paths selecting the stack.
+func (s *Server) references(fname string, pos lsp.Position, includeDecl bool) ([]lsp.Location, error) {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(fname)
+	offset := offsetFor(content, pos)
+	body := parseBody(fname, content)
+
+	if path, _, ok := globalAt(body, offset); ok {
+		p, err := s.loadProject(dir)
+		if err != nil {
+			return nil, err
+		}
+		return s.globalReferences(p, dir, path, includeDecl), nil
+	}
+
+	blocks := blocksAt(body, offset)
+	if len(blocks) == 0 || blocks[0].Type != "stack" {
+		return nil, nil
+	}
+
+	rootdir := s.projectRoot(dir)
+	target := dir
+	if attr, _, ok := attributeAt(body, offset); ok && isStackReference(attr.Name) {
+		str, _, ok := stringAt(body, offset)
+		if !ok {
+			return nil, nil
+		}
+		target = resolvePath(rootdir, dir, str)
+	}
+	return s.stackReferences(rootdir, target, includeDecl), nil
+}
+
+// globalReferences returns the locations of the global.<path> references whose
+// effective definition is the same as the one of path visible from dir.
+func (s *Server) globalReferences(p *projectState, dir string, path []string, includeDecl bool) []lsp.Location {
+	def, ok := p.effectiveGlobal(dir, path)
+	if !ok {
+		return nil
+	}
+
+	var locations []lsp.Location
+	if includeDecl {
+		locations = append(locations, lsp.Location{
+			URI:   fileURI(def.nameRange.Filename),
+			Range: lspRange(def.nameRange),
+		})
+	}
+
+	s.globalUses(p, def, func(fname string, ref globalRef) {
+		locations = append(locations, lsp.Location{
+			URI:   fileURI(fname),
+			Range: lspRange(ref.rng),
+		})
+	})
+	return locations
+}
+
+// globalUses calls fn for each global.<path> reference of the definition def or
+// of any of its children, in every directory where def is the effective
+// definition.
+func (s *Server) globalUses(p *projectState, def globalDefinition, fn func(fname string, ref globalRef)) {
+	effective := map[string]bool{}
+	s.index(p.rootdir).forEach(func(fname string, symbols *fileSymbols) {
+		refdir := filepath.Dir(fname)
+		for _, ref := range symbols.globalRefs {
+			if !isPathPrefix(def.path, ref.path) {
+				continue
+			}
+			same, ok := effective[refdir]
+			if !ok {
+				other, found := p.effectiveGlobal(refdir, def.path)
+				same = found && other.sameAs(def)
+				effective[refdir] = same
+			}
+			if same {
+				fn(fname, ref)
+			}
+		}
+	})
+}
+
+// stackReferences returns the locations of the stack paths selecting the stack
+// at the host directory target. A path selects the stack if it is the stack
+// directory or any of its parent directories.
+func (s *Server) stackReferences(rootdir string, target string, includeDecl bool) []lsp.Location {
+	decl, ok := s.stackLocation(target)
+	if !ok {
+		return nil
+	}
+
+	var locations []lsp.Location
+	if includeDecl {
+		locations = append(locations, decl)
+	}
+
+	stackdir := project.PrjAbsPath(rootdir, target)
+	s.index(rootdir).forEach(func(fname string, symbols *fileSymbols) {
+		for _, ref := range symbols.pathRefs {
+			if isStackReference(ref.attr) && isParentOrSelf(ref.target, stackdir) {
+				locations = append(locations, lsp.Location{
+					URI:   fileURI(fname),
+					Range: lspRange(ref.rng),
+				})
+			}
+		}
+	})
+	return locations
+}
+
+// isParentOrSelf tells if parent is the same directory as dir or any of its
+// parent directories.
+func isParentOrSelf(parent project.Path, dir project.Path) bool {
+	for {
+		if dir == parent {
+			return true
+		}
+		next := dir.Dir()
+		if next == dir {
+			return false
+		}
+		dir = next
+	}
+}
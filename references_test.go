@@ -0,0 +1,174 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestReferences(t *testing.T) {
+	type wantLocation struct {
+		file string
+		rng  lsp.Range
+	}
+	type testcase struct {
+		name        string
+		layout      []string
+		file        string
+		pos         lsp.Position
+		includeDecl bool
+		want        []wantLocation
+	}
+
+	line := func(l, start, end uint32) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: l, Character: start},
+			End:   lsp.Position{Line: l, Character: end},
+		}
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "global references in all inheriting stacks",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stacks/a/stack.tm:stack {}\ngenerate_hcl \"f.tf\" {\n  content {\n    r = global.region\n  }\n}",
+				"f:stacks/b/stack.tm:stack {}\ngenerate_file \"f.txt\" {\n  content = global.region\n}",
+				"f:stacks/c/stack.tm:stack {}\nglobals {\n  r = global.region\n}",
+			},
+			file:        "globals.tm",
+			pos:         lsp.Position{Line: 1, Character: 4},
+			includeDecl: true,
+			want: []wantLocation{
+				{file: "globals.tm", rng: line(1, 2, 8)},
+				{file: "stacks/a/stack.tm", rng: line(3, 8, 21)},
+				{file: "stacks/b/stack.tm", rng: line(2, 12, 25)},
+				{file: "stacks/c/stack.tm", rng: line(2, 6, 19)},
+			},
+		},
+		{
+			name: "overridden global references are not included",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stacks/a/stack.tm:stack {}\nglobals {\n  r = global.region\n}",
+				"f:stacks/b/globals.tm:globals {\n  region = \"eu-west-1\"\n}",
+				"f:stacks/b/stack.tm:stack {}\nglobals {\n  r = global.region\n}",
+			},
+			file: "stacks/a/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 10},
+			want: []wantLocation{
+				{file: "stacks/a/stack.tm", rng: line(2, 6, 19)},
+			},
+		},
+		{
+			name: "references from the overriding definition",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stacks/a/stack.tm:stack {}\nglobals {\n  r = global.region\n}",
+				"f:stacks/b/globals.tm:globals {\n  region = \"eu-west-1\"\n}",
+				"f:stacks/b/stack.tm:stack {}\nglobals {\n  r = global.region.name\n}",
+			},
+			file: "stacks/b/globals.tm",
+			pos:  lsp.Position{Line: 1, Character: 2},
+			want: []wantLocation{
+				{file: "stacks/b/stack.tm", rng: line(2, 6, 24)},
+			},
+		},
+		{
+			name: "references of an imported global",
+			layout: []string{
+				test.RootConfig,
+				"f:modules/globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/stack.tm:stack {}\nimport {\n  source = \"/modules/globals.tm\"\n}\nglobals {\n  a = global.region\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 5, Character: 10},
+			want: []wantLocation{
+				{file: "stack/stack.tm", rng: line(5, 6, 19)},
+			},
+		},
+		{
+			name: "undefined global",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.undefined\n}",
+			},
+			file: "stack/stack.tm",
+			pos:  lsp.Position{Line: 2, Character: 10},
+		},
+		{
+			name: "stack references",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/stack.tm:stack {\n  name = \"a\"\n}",
+				"f:stacks/b/stack.tm:stack {\n  after = [\"/stacks/a\", \"/other\"]\n}",
+				"f:stacks/c/stack.tm:stack {\n  before = [\"../a\"]\n  wants = [\"/stacks\"]\n}",
+				"f:stacks/d/stack.tm:stack {\n  after = [\"/stacks/b\"]\n}",
+			},
+			file:        "stacks/a/stack.tm",
+			pos:         lsp.Position{Line: 1, Character: 4},
+			includeDecl: true,
+			want: []wantLocation{
+				{file: "stacks/a/stack.tm", rng: line(0, 0, 5)},
+				{file: "stacks/b/stack.tm", rng: line(1, 11, 22)},
+				{file: "stacks/c/stack.tm", rng: line(1, 12, 18)},
+				{file: "stacks/c/stack.tm", rng: line(2, 11, 20)},
+			},
+		},
+		{
+			name: "stack references from a stack path",
+			layout: []string{
+				test.RootConfig,
+				"f:stacks/a/stack.tm:stack {}",
+				"f:stacks/b/stack.tm:stack {\n  after = [\"/stacks/a\"]\n}",
+			},
+			file: "stacks/b/stack.tm",
+			pos:  lsp.Position{Line: 1, Character: 14},
+			want: []wantLocation{
+				{file: "stacks/b/stack.tm", rng: line(1, 11, 22)},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			want := []lsp.Location{}
+			for _, loc := range tc.want {
+				want = append(want, lsp.Location{
+					URI:   uri.File(filepath.Join(f.Sandbox.RootDir(), loc.file)),
+					Range: loc.rng,
+				})
+			}
+
+			got := f.Editor.References(tc.file, tc.pos, tc.includeDecl)
+			if got == nil {
+				got = []lsp.Location{}
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Fatalf("references mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// codeRequestFailed is the LSP error code for requests which are valid but
+// could not be fulfilled by the server.
+const codeRequestFailed jsonrpc2.Code = -32803
+
+func (s *Server) handlePrepareRename(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.PrepareRenameParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	target, ok, err := s.renameTarget(fname, params.Position)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to prepare rename")
+		return reply(ctx, nil, nil)
+	}
+	if !ok {
+		return reply(ctx, nil, nil)
+	}
+	rng := lspRange(target.rng)
+	return reply(ctx, &rng, nil)
+}
+
+func (s *Server) handleRename(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.RenameParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	edit, err := s.rename(fname, params.Position, params.NewName)
+	if err != nil {
+		log.Info().Err(err).Msg("rename refused")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+	return reply(ctx, edit, nil)
+}
+
+// renameTarget is the global being renamed.
+type renameTarget struct {
+	project *projectState
+	def     globalDefinition
+
+	// rng is the range of the name being renamed at the requested position.
+	rng hhcl.Range
+}
+
+// renameTarget returns the global which can be renamed at the given position
+// of the file. The name being renamed is always the last name of the path of
+// the effective definition, eg.: renaming at global.a.b.c, where global.a.b is
+// defined, renames b.
+func (s *Server) renameTarget(fname string, pos lsp.Position) (renameTarget, bool, error) {
+	content, err := s.documents.read(fname)
+	if err != nil {
+		return renameTarget{}, false, err
+	}
+
+	dir := filepath.Dir(fname)
+	offset := offsetFor(content, pos)
+	body := parseBody(fname, content)
+
+	path, _, ok := globalAt(body, offset)
+	if !ok {
+		return renameTarget{}, false, nil
+	}
+
+	p, err := s.loadProject(dir)
+	if err != nil {
+		return renameTarget{}, false, err
+	}
+
+	def, ok := p.effectiveGlobal(dir, path)
+	if !ok {
+		return renameTarget{}, false, nil
+	}
+
+	rng := unquotedNameRange(def)
+	if expr, ok := traversalAt(body, offset); ok {
+		rng = traversalNameRanges(expr.Traversal)[len(def.path)-1]
+	}
+	return renameTarget{
+		project: p,
+		def:     def,
+		rng:     rng,
+	}, true, nil
+}
+
+// rename renames the global at the given position of the file, returning the
+// edits for its definition and all of its references. The rename is refused if
+// the new name would collide with another global or if any reference would
+// resolve to a different definition after the rename.
+func (s *Server) rename(fname string, pos lsp.Position, newName string) (*lsp.WorkspaceEdit, error) {
+	if !hclsyntax.ValidIdentifier(newName) {
+		return nil, fmt.Errorf("%q is not a valid global name", newName)
+	}
+
+	target, ok, err := s.renameTarget(fname, pos)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no global to rename at this position")
+	}
+
+	p, def := target.project, target.def
+	newPath := append(append([]string{}, def.path[:len(def.path)-1]...), newName)
+	newDef := globalDefinition{path: newPath}
+	if equalPaths(def.path, newPath) {
+		return nil, fmt.Errorf("%s already has this name", def.name())
+	}
+
+	for _, dir := range s.index(p.rootdir).dirs() {
+		var visible bool
+		for _, other := range p.globalDefinitions(dir, def.path) {
+			if other.sameAs(def) {
+				visible = true
+				break
+			}
+		}
+		if !visible {
+			continue
+		}
+
+		effective, _ := p.effectiveGlobal(dir, def.path)
+		if !effective.sameAs(def) {
+			return nil, fmt.Errorf(
+				"%s is overridden at %s, renaming it would split the definitions",
+				def.name(), p.definedAt(effective),
+			)
+		}
+
+		for _, other := range p.globalDefinitions(dir, def.path) {
+			if !other.sameAs(def) {
+				return nil, fmt.Errorf(
+					"%s is also defined at %s, which would become the effective definition at %s",
+					other.name(), p.definedAt(other), project.PrjAbsPath(p.rootdir, dir),
+				)
+			}
+		}
+
+		for _, other := range p.globalDefinitions(dir, newPath) {
+			return nil, fmt.Errorf(
+				"%s collides with %s defined at %s",
+				newDef.name(), other.name(), p.definedAt(other),
+			)
+		}
+	}
+
+	changes := map[lsp.URI][]lsp.TextEdit{}
+	addEdit := func(rng hhcl.Range) {
+		uri := fileURI(rng.Filename)
+		changes[uri] = append(changes[uri], lsp.TextEdit{
+			Range:   lspRange(rng),
+			NewText: newName,
+		})
+	}
+
+	addEdit(unquotedNameRange(def))
+	s.globalUses(p, def, func(fname string, ref globalRef) {
+		addEdit(ref.nameRanges[len(def.path)-1])
+	})
+
+	for _, edits := range changes {
+		sort.Slice(edits, func(i, j int) bool {
+			a, b := edits[i].Range.Start, edits[j].Range.Start
+			return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+		})
+	}
+	return &lsp.WorkspaceEdit{Changes: changes}, nil
+}
+
+// unquotedNameRange returns the range of the name of the definition without
+// the quotes, which are present in the labels of map blocks.
+func unquotedNameRange(def globalDefinition) hhcl.Range {
+	rng := def.nameRange
+	name := def.path[len(def.path)-1]
+	if rng.End.Byte-rng.Start.Byte == len(name)+2 {
+		rng.Start.Byte++
+		rng.Start.Column++
+		rng.End.Byte--
+		rng.End.Column--
+	}
+	return rng
+}
@@ -0,0 +1,206 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+func TestRename(t *testing.T) {
+	type testcase struct {
+		name    string
+		layout  []string
+		file    string
+		pos     lsp.Position
+		newName string
+		want    map[string][]lsp.Range
+		wantErr string
+	}
+
+	line := func(l, start, end uint32) lsp.Range {
+		return lsp.Range{
+			Start: lsp.Position{Line: l, Character: start},
+			End:   lsp.Position{Line: l, Character: end},
+		}
+	}
+
+	for _, tc := range []testcase{
+		{
+			name: "definition and references in all stacks",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stacks/a/stack.tm:stack {}\ngenerate_hcl \"f.tf\" {\n  content {\n    r = global.region\n  }\n}",
+				"f:stacks/b/stack.tm:stack {}\nglobals {\n  r = global.region\n  o = global.other\n}",
+			},
+			file:    "stacks/b/stack.tm",
+			pos:     lsp.Position{Line: 2, Character: 15},
+			newName: "location",
+			want: map[string][]lsp.Range{
+				"globals.tm":        {line(1, 2, 8)},
+				"stacks/a/stack.tm": {line(3, 15, 21)},
+				"stacks/b/stack.tm": {line(2, 13, 19)},
+			},
+		},
+		{
+			name: "object attribute of a labelled global",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals \"obj\" {\n  a = 1\n}",
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.obj.a.b\n  o = global.obj\n}",
+			},
+			file:    "globals.tm",
+			pos:     lsp.Position{Line: 1, Character: 2},
+			newName: "c",
+			want: map[string][]lsp.Range{
+				"globals.tm":     {line(1, 2, 3)},
+				"stack/stack.tm": {line(2, 17, 18)},
+			},
+		},
+		{
+			name: "invalid name",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+			},
+			file:    "globals.tm",
+			pos:     lsp.Position{Line: 1, Character: 2},
+			newName: "not valid",
+			wantErr: "not a valid global name",
+		},
+		{
+			name: "collision with a global defined at a parent directory",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  location = \"us\"\n}",
+				"f:stack/globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/stack.tm:stack {}",
+			},
+			file:    "stack/globals.tm",
+			pos:     lsp.Position{Line: 1, Character: 2},
+			newName: "location",
+			wantErr: "global.location collides with global.location defined at /globals.tm:2",
+		},
+		{
+			name: "collision with a global defined at a child directory",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/globals.tm:globals {\n  location = \"us\"\n  r = global.region\n}",
+				"f:stack/stack.tm:stack {}",
+			},
+			file:    "globals.tm",
+			pos:     lsp.Position{Line: 1, Character: 2},
+			newName: "location",
+			wantErr: "global.location collides with global.location defined at /stack/globals.tm:2",
+		},
+		{
+			name: "overriding definition would lose",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/globals.tm:globals {\n  region = \"eu-west-1\"\n}",
+				"f:stack/stack.tm:stack {}",
+			},
+			file:    "stack/globals.tm",
+			pos:     lsp.Position{Line: 1, Character: 2},
+			newName: "location",
+			wantErr: "global.region is also defined at /globals.tm:2",
+		},
+		{
+			name: "overridden definition",
+			layout: []string{
+				test.RootConfig,
+				"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+				"f:stack/globals.tm:globals {\n  region = \"eu-west-1\"\n}",
+				"f:stack/stack.tm:stack {}",
+			},
+			file:    "globals.tm",
+			pos:     lsp.Position{Line: 1, Character: 2},
+			newName: "location",
+			wantErr: "global.region is overridden at /stack/globals.tm:2",
+		},
+		{
+			name: "undefined global",
+			layout: []string{
+				test.RootConfig,
+				"f:stack/stack.tm:stack {}\nglobals {\n  a = global.undefined\n}",
+			},
+			file:    "stack/stack.tm",
+			pos:     lsp.Position{Line: 2, Character: 10},
+			newName: "defined",
+			wantErr: "no global to rename",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, tc.layout...)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			got, err := f.Editor.Rename(tc.file, tc.pos, tc.newName)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q but got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := map[lsp.URI][]lsp.TextEdit{}
+			for file, ranges := range tc.want {
+				fileuri := uri.File(filepath.Join(f.Sandbox.RootDir(), file))
+				for _, rng := range ranges {
+					want[fileuri] = append(want[fileuri], lsp.TextEdit{
+						Range:   rng,
+						NewText: tc.newName,
+					})
+				}
+			}
+			if diff := cmp.Diff(want, got.Changes); diff != "" {
+				t.Fatalf("rename mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPrepareRename(t *testing.T) {
+	f := test.Setup(t,
+		test.RootConfig,
+		"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+		"f:stack/stack.tm:stack {\n  name = \"stack\"\n}\nglobals {\n  r = global.region\n}",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	got := f.Editor.PrepareRename("stack/stack.tm", lsp.Position{Line: 4, Character: 8})
+	want := lsp.Range{
+		Start: lsp.Position{Line: 4, Character: 13},
+		End:   lsp.Position{Line: 4, Character: 19},
+	}
+	if got == nil || *got != want {
+		t.Fatalf("prepare rename got %v != want %v", got, want)
+	}
+
+	if got := f.Editor.PrepareRename("stack/stack.tm", lsp.Position{Line: 1, Character: 10}); got != nil {
+		t.Fatalf("unexpected prepare rename range: %v", *got)
+	}
+}
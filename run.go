@@ -0,0 +1,242 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+
+	"github.com/mineiros-io/terramate/project"
+	"github.com/mineiros-io/terramate/run"
+	"github.com/mineiros-io/terramate/run/dag"
+	"github.com/mineiros-io/terramate/stack"
+	lsp "go.lsp.dev/protocol"
+)
+
+// runGraph builds the run order graph of the stacks of the project the same
+// way `terramate run` does: a stack runs after the stacks selected by its after
+// attribute, before the ones selected by its before attribute and after its
+// parent stacks. The stacks are loaded again, as building the graph changes
+// them.
+func (p *projectState) runGraph() (*dag.DAG, error) {
+	stacks := stack.List{}
+	for _, node := range p.root.Tree().Stacks() {
+		st, err := stack.New(p.rootdir, node.Node)
+		if err != nil {
+			continue
+		}
+		stacks = append(stacks, st)
+	}
+	sort.Sort(stacks)
+
+	for _, st := range stacks {
+		for _, other := range stacks {
+			if st.Path() != other.Path() && st.Path().HasPrefix(other.Path().String()+"/") {
+				other.AppendBefore(st.Path().String())
+			}
+		}
+	}
+
+	d := dag.New()
+	visited := dag.Visited{}
+	for _, st := range stacks {
+		err := run.BuildDAG(d, p.root, st,
+			"before", stack.S.Before,
+			"after", stack.S.After,
+			visited,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// runOrder returns the paths of the stacks of the project in the order they
+// run. If the stacks can't be ordered, the reason is returned with the error.
+func (p *projectState) runOrder(d *dag.DAG) ([]project.Path, string, error) {
+	reason, err := d.Validate()
+	if err != nil {
+		return nil, reason, err
+	}
+
+	var order []project.Path
+	for _, id := range d.Order() {
+		if st, ok := p.stackAt(p.hostPath(project.Path(id))); ok {
+			order = append(order, st.Path())
+		}
+	}
+	return order, "", nil
+}
+
+// runCycle returns the stacks of the cycle found when validating the run order
+// graph, where each stack runs after the next one and the first stack is
+// repeated at the end. The reason of the validation can start with stacks
+// leading to the cycle, eg.: /a -> /b -> /c -> /b.
+func runCycle(reason string) []project.Path {
+	parts := strings.Split(reason, " -> ")
+	last := parts[len(parts)-1]
+	for i, part := range parts {
+		if part == last {
+			parts = parts[i:]
+			break
+		}
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+
+	cycle := make([]project.Path, 0, len(parts))
+	for _, part := range parts {
+		cycle = append(cycle, project.NewPath(part))
+	}
+	return cycle
+}
+
+// runOrderCycle is a cycle in the run order of the stacks of a project.
+type runOrderCycle struct {
+	// stacks are the paths of the stacks of the cycle, from a stack to the one
+	// running before it, ending with the first stack.
+	stacks []project.Path
+	reason string
+}
+
+// runOrderCycle returns the run order cycle of the stacks of the project, which
+// is empty if the run order has no cycles.
+func (p *projectState) runOrderCycle() runOrderCycle {
+	d, err := p.runGraph()
+	if err != nil {
+		return runOrderCycle{}
+	}
+	reason, err := d.Validate()
+	if err == nil {
+		return runOrderCycle{}
+	}
+	return runOrderCycle{stacks: runCycle(reason), reason: reason}
+}
+
+// cycleProblems returns the problems of the paths of the after and before
+// attributes of the stack block, at the host directory dir, which order the
+// stack in the run order cycle of the project.
+func (p *projectState) cycleProblems(cycle runOrderCycle, dir string, block *hclsyntax.Block) []problem {
+	st, ok := p.stackAt(dir)
+	if !ok || cycle.reason == "" {
+		return nil
+	}
+
+	// runsAfter has the edges of the cycle, from a stack to the one running
+	// before it.
+	runsAfter := map[[2]project.Path]bool{}
+	for i := 0; i+1 < len(cycle.stacks); i++ {
+		runsAfter[[2]project.Path{cycle.stacks[i], cycle.stacks[i+1]}] = true
+	}
+
+	var problems []problem
+	for _, attr := range sortedAttributes(block.Body.Attributes) {
+		if attr.Name != "after" && attr.Name != "before" {
+			continue
+		}
+		tuple, ok := attr.Expr.(*hclsyntax.TupleConsExpr)
+		if !ok {
+			continue
+		}
+		for _, elem := range tuple.Exprs {
+			value, ok := stringLiteral(elem)
+			if !ok {
+				continue
+			}
+			target := project.PrjAbsPath(p.rootdir, resolvePath(p.rootdir, dir, value))
+			for _, other := range cycle.stacks {
+				edge := [2]project.Path{st.Path(), other}
+				if attr.Name == "before" {
+					edge = [2]project.Path{other, st.Path()}
+				}
+				if runsAfter[edge] && isParentOrSelf(target, other) {
+					problems = append(problems, problem{
+						code:     codeRunOrderCycle,
+						rng:      lspRange(elem.Range()),
+						severity: lsp.DiagnosticSeverityError,
+						message:  fmt.Sprintf("run order cycle: %s", cycle.reason),
+					})
+					break
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// recheckCycles checks again the directories of the stacks entering or leaving
+// a run order cycle since the last check of the projects of the checked
+// directories, as the diagnostics of the stacks in a cycle depend on each
+// other. The run order is only validated again for the projects with a cycle
+// found by the check or by the last one.
+func (s *Server) recheckCycles(ctx context.Context, c *check, checked []string) {
+	rechecked := map[string]bool{}
+	for _, dir := range checked {
+		rechecked[dir] = true
+	}
+
+	for _, dir := range checked {
+		rootdir := s.projectRoot(dir)
+		s.cyclesMu.Lock()
+		last, hadCycle := s.cycles[rootdir]
+		s.cyclesMu.Unlock()
+		if _, ok := c.cycles[rootdir]; !ok && !hadCycle {
+			continue
+		}
+		p, ok := c.project(dir)
+		if !ok {
+			continue
+		}
+
+		cycle := c.cycle(p)
+		s.cyclesMu.Lock()
+		if cycle.reason == "" {
+			delete(s.cycles, rootdir)
+		} else {
+			s.cycles[rootdir] = cycle
+		}
+		s.cyclesMu.Unlock()
+		if cycle.reason == last.reason {
+			continue
+		}
+
+		var dirs []string
+		for _, path := range append(append([]project.Path{}, last.stacks...), cycle.stacks...) {
+			stackdir := p.hostPath(path)
+			if !rechecked[stackdir] {
+				rechecked[stackdir] = true
+				dirs = append(dirs, stackdir)
+			}
+		}
+		sort.Strings(dirs)
+		for _, stackdir := range dirs {
+			files := s.dirFiles(stackdir)
+			if len(files) == 0 {
+				continue
+			}
+			content, err := s.documents.read(files[0])
+			if err != nil {
+				continue
+			}
+			_ = s.checkDir(ctx, c, files[0], string(content))
+		}
+	}
+}
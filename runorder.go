@@ -0,0 +1,185 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mineiros-io/terramate"
+	"github.com/mineiros-io/terramate/project"
+	"github.com/mineiros-io/terramate/run/dag"
+	"github.com/mineiros-io/terramate/stack"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// methodRunOrder is the request returning the order the stacks run, which is
+// not part of the protocol.
+const methodRunOrder = "terramate/runOrder"
+
+// runOrderParams are the parameters of the terramate/runOrder request.
+type runOrderParams struct {
+	// Directory is the URI of the directory whose stacks are ordered, with
+	// the stacks they want. All the stacks of the project of the workspace
+	// folders are ordered if it is empty, as `terramate run` does from the
+	// project root, which requires the folders to be in a single project.
+	Directory lsp.URI `json:"directory,omitempty"`
+}
+
+// runOrderResult is the result of the terramate/runOrder request.
+type runOrderResult struct {
+	// Order are the project paths of the stacks in the order they run. It is
+	// empty if the stacks have an ordering cycle.
+	Order []string `json:"order"`
+
+	// Cycle are the stacks of the ordering cycle, if any, where each stack
+	// runs after the next one and the first stack is repeated at the end.
+	Cycle []string `json:"cycle,omitempty"`
+
+	// Dot and Mermaid are the graph of the stacks, where the edges go from
+	// the stacks running first.
+	Dot     string `json:"dot"`
+	Mermaid string `json:"mermaid"`
+}
+
+func (s *Server) handleRunOrder(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params runOrderParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	var dir string
+	if params.Directory != "" {
+		dir, _ = commandDir([]interface{}{string(params.Directory)})
+		if dir == "" {
+			return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams,
+				fmt.Sprintf("invalid directory URI %q", params.Directory)))
+		}
+	} else {
+		var err error
+		dir, err = s.workspaceProject()
+		if err != nil {
+			return reply(ctx, nil, jsonrpc2.NewError(jsonrpc2.InvalidParams, err.Error()))
+		}
+	}
+
+	p, err := s.loadProject(dir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to load project")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+
+	prjdir := project.NewPath("/")
+	if params.Directory != "" {
+		prjdir = project.PrjAbsPath(p.rootdir, dir)
+	}
+	result, err := p.runOrderOf(prjdir)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to compute the run order")
+		return reply(ctx, nil, jsonrpc2.NewError(codeRequestFailed, err.Error()))
+	}
+	return reply(ctx, result, nil)
+}
+
+// runOrderOf returns the run order of the stacks inside the project directory
+// dir and of the stacks they want.
+func (p *projectState) runOrderOf(dir project.Path) (*runOrderResult, error) {
+	scope := stack.List{}
+	for _, st := range p.stacks {
+		if isParentOrSelf(dir, st.Path()) {
+			scope = append(scope, st)
+		}
+	}
+	// the git base ref is only used to find the changed stacks.
+	selection, err := terramate.NewManager(p.root, "").AddWantedOf(scope)
+	if err != nil {
+		return nil, err
+	}
+	selected := map[project.Path]bool{}
+	for _, st := range selection {
+		selected[st.Path()] = true
+	}
+
+	d, err := p.runGraph()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &runOrderResult{Order: []string{}}
+	order, reason, err := p.runOrder(d)
+	if err != nil {
+		for _, path := range runCycle(reason) {
+			result.Cycle = append(result.Cycle, path.String())
+		}
+	}
+	for _, path := range order {
+		if selected[path] {
+			result.Order = append(result.Order, path.String())
+		}
+	}
+
+	var nodes []project.Path
+	for path := range selected {
+		nodes = append(nodes, path)
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i] < nodes[j]
+	})
+	result.Dot, result.Mermaid = runGraphCode(d, nodes, selected)
+	return result, nil
+}
+
+// runGraphCode returns the DOT and Mermaid code of the run order graph of the
+// nodes, with the edges between the selected stacks.
+func runGraphCode(d *dag.DAG, nodes []project.Path, selected map[project.Path]bool) (string, string) {
+	var dot, mermaid strings.Builder
+	dot.WriteString("digraph {\n")
+	mermaid.WriteString("flowchart TD\n")
+
+	ids := map[project.Path]string{}
+	for i, node := range nodes {
+		ids[node] = fmt.Sprintf("s%d", i)
+		fmt.Fprintf(&dot, "\t%q;\n", node)
+		fmt.Fprintf(&mermaid, "\t%s[%q]\n", ids[node], node)
+	}
+	for _, node := range nodes {
+		ancestors := append([]dag.ID{}, d.AncestorsOf(dag.ID(node))...)
+		sort.Slice(ancestors, func(i, j int) bool {
+			return ancestors[i] < ancestors[j]
+		})
+		for _, id := range ancestors {
+			ancestor := project.Path(id)
+			if !selected[ancestor] {
+				continue
+			}
+			fmt.Fprintf(&dot, "\t%q -> %q;\n", ancestor, node)
+			fmt.Fprintf(&mermaid, "\t%s --> %s\n", ids[ancestor], ids[node])
+		}
+	}
+
+	dot.WriteString("}\n")
+	return dot.String(), mermaid.String()
+}
@@ -0,0 +1,128 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestRunOrder(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {}\n",
+		"f:b/stack.tm:stack {\n  after = [\"/a\"]\n}\n",
+		"f:c/stack.tm:stack {\n  before = [\"../a\"]\n}\n",
+		"f:parent/stack.tm:stack {}\n",
+		"f:parent/child/stack.tm:stack {}\n",
+		"f:w/stack.tm:stack {\n  wants = [\"/b\"]\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	want := test.RunOrder{
+		Order: []string{"/c", "/a", "/b", "/parent", "/parent/child", "/w"},
+		Dot: "digraph {\n" +
+			"\t\"/a\";\n\t\"/b\";\n\t\"/c\";\n\t\"/parent\";\n\t\"/parent/child\";\n\t\"/w\";\n" +
+			"\t\"/c\" -> \"/a\";\n" +
+			"\t\"/a\" -> \"/b\";\n" +
+			"\t\"/parent\" -> \"/parent/child\";\n" +
+			"}\n",
+		Mermaid: "flowchart TD\n" +
+			"\ts0[\"/a\"]\n\ts1[\"/b\"]\n\ts2[\"/c\"]\n\ts3[\"/parent\"]\n\ts4[\"/parent/child\"]\n\ts5[\"/w\"]\n" +
+			"\ts2 --> s0\n" +
+			"\ts0 --> s1\n" +
+			"\ts3 --> s4\n",
+	}
+	if diff := cmp.Diff(want, f.Editor.RunOrder("")); diff != "" {
+		t.Fatalf("run order mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	// the stacks wanted by the stacks of the directory are selected too.
+	got := f.Editor.RunOrder("w")
+	if diff := cmp.Diff([]string{"/b", "/w"}, got.Order); diff != "" {
+		t.Fatalf("run order mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+func TestRunOrderCycle(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {\n  after = [\"/b\", \"/c\"]\n}\n",
+		"f:b/stack.tm:stack {\n  after = [\"/a\"]\n}\n",
+		"f:c/stack.tm:stack {}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	got := f.Editor.RunOrder("")
+	if diff := cmp.Diff([]string{"/a", "/b", "/a"}, got.Cycle); diff != "" {
+		t.Fatalf("cycle mismatch, want(-) got(+):\n%s", diff)
+	}
+	if len(got.Order) != 0 {
+		t.Fatalf("want no order with a cycle, got %v", got.Order)
+	}
+
+	f.Editor.Open("a/stack.tm")
+	want := []lsp.Diagnostic{
+		{
+			Range: lsp.Range{
+				Start: lsp.Position{Line: 1, Character: 11},
+				End:   lsp.Position{Line: 1, Character: 15},
+			},
+			Severity: lsp.DiagnosticSeverityError,
+			Code:     "run-order-cycle",
+			Source:   "terramate",
+			Message:  "run order cycle: /a -> /b -> /a",
+		},
+	}
+	if diff := cmp.Diff(want, f.Editor.Diagnostics("a/stack.tm")); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+	drainRequests(f.Editor)
+}
+
+func TestRunOrderCycleChange(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {}\n",
+		"f:b/stack.tm:stack {\n  after = [\"/a\"]\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+	f.Editor.Open("a/stack.tm")
+	drainRequests(f.Editor)
+
+	f.Editor.Change("a/stack.tm", "stack {\n  after = [\"/b\"]\n}\n")
+	want := []lsp.Diagnostic{
+		{
+			Range: lsp.Range{
+				Start: lsp.Position{Line: 1, Character: 11},
+				End:   lsp.Position{Line: 1, Character: 15},
+			},
+			Severity: lsp.DiagnosticSeverityError,
+			Code:     "run-order-cycle",
+			Source:   "terramate",
+			Message:  "run order cycle: /a -> /b -> /a",
+		},
+	}
+	if diff := cmp.Diff(want, f.Editor.Diagnostics("b/stack.tm")); diff != "" {
+		t.Fatalf("diagnostics of the other stack mismatch, want(-) got(+):\n%s", diff)
+	}
+	drainRequests(f.Editor)
+
+	f.Editor.Change("a/stack.tm", "stack {}\n")
+	if diff := cmp.Diff([]lsp.Diagnostic{}, f.Editor.Diagnostics("b/stack.tm")); diff != "" {
+		t.Fatalf("diagnostics after breaking the cycle mismatch, want(-) got(+):\n%s", diff)
+	}
+	drainRequests(f.Editor)
+}
@@ -0,0 +1,105 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// methodTextDocumentSelectionRange is missing in the protocol package.
+const methodTextDocumentSelectionRange = "textDocument/selectionRange"
+
+func (s *Server) handleSelectionRange(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.SelectionRangeParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+
+	body := parseBody(fname, content)
+	ranges := make([]lsp.SelectionRange, 0, len(params.Positions))
+	for _, pos := range params.Positions {
+		ranges = append(ranges, selectionRange(content, body, offsetFor(content, pos)))
+	}
+	return reply(ctx, ranges, nil)
+}
+
+// selectionRange returns the selection range at the byte offset, which expands
+// along the syntax tree from the innermost expression to the attribute, its
+// blocks and then the whole file. The name of an attribute or the type or
+// label of a block is the innermost selection when the offset is inside it.
+func selectionRange(content []byte, body *hclsyntax.Body, offset int) lsp.SelectionRange {
+	sel := &lsp.SelectionRange{
+		Range: lsp.Range{
+			Start: positionFor(content, 0),
+			End:   positionFor(content, len(content)),
+		},
+	}
+	expand := func(rng hhcl.Range) {
+		// sibling nodes touching the offset are not nested into each other.
+		next := lspRange(rng)
+		if next == sel.Range ||
+			positionBefore(next.Start, sel.Range.Start) ||
+			positionBefore(sel.Range.End, next.End) {
+			return
+		}
+		sel = &lsp.SelectionRange{
+			Range:  next,
+			Parent: sel,
+		}
+	}
+
+	for _, node := range nodesAt(body, offset) {
+		switch n := node.(type) {
+		case *hclsyntax.Block:
+			expand(n.Range())
+			if containsOffset(n.TypeRange, offset) {
+				expand(n.TypeRange)
+			}
+			for _, rng := range n.LabelRanges {
+				if containsOffset(rng, offset) {
+					expand(rng)
+				}
+			}
+		case *hclsyntax.Attribute:
+			expand(n.Range())
+			if containsOffset(n.NameRange, offset) {
+				expand(n.NameRange)
+			}
+		case hclsyntax.Expression:
+			expand(n.Range())
+		}
+	}
+	return *sel
+}
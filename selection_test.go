@@ -0,0 +1,117 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestSelectionRanges(t *testing.T) {
+	type testcase struct {
+		name    string
+		content string
+		pos     lsp.Position
+		want    []string
+	}
+
+	const content = "globals {\n  a = tm_upper(global.b)\n}\n\nstack {\n  after = [\"/a\", \"/b\"]\n}\n"
+
+	for _, tc := range []testcase{
+		{
+			name:    "function argument",
+			content: content,
+			pos:     lsp.Position{Line: 1, Character: 20},
+			want: []string{
+				"1:15-1:23",
+				"1:6-1:24",
+				"1:2-1:24",
+				"0:0-2:1",
+				"0:0-7:0",
+			},
+		},
+		{
+			name:    "attribute name",
+			content: content,
+			pos:     lsp.Position{Line: 1, Character: 2},
+			want: []string{
+				"1:2-1:3",
+				"1:2-1:24",
+				"0:0-2:1",
+				"0:0-7:0",
+			},
+		},
+		{
+			name:    "string in a list",
+			content: content,
+			pos:     lsp.Position{Line: 5, Character: 18},
+			want: []string{
+				"5:18-5:20",
+				"5:17-5:21",
+				"5:10-5:22",
+				"5:2-5:22",
+				"4:0-6:1",
+				"0:0-7:0",
+			},
+		},
+		{
+			name:    "block type",
+			content: content,
+			pos:     lsp.Position{Line: 4, Character: 1},
+			want: []string{
+				"4:0-4:5",
+				"4:0-6:1",
+				"0:0-7:0",
+			},
+		},
+		{
+			name:    "outside blocks",
+			content: content,
+			pos:     lsp.Position{Line: 3, Character: 0},
+			want: []string{
+				"0:0-7:0",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, "f:file.tm:"+tc.content)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			got := f.Editor.SelectionRanges("file.tm", tc.pos)
+			if len(got) != 1 {
+				t.Fatalf("want a single selection range, got %d", len(got))
+			}
+			if diff := cmp.Diff(tc.want, selections(&got[0])); diff != "" {
+				t.Fatalf("selection ranges mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+// selections returns the ranges of the selection and its parents, from the
+// innermost to the outermost, in the `line:char-line:char` form.
+func selections(sel *lsp.SelectionRange) []string {
+	var ranges []string
+	for ; sel != nil; sel = sel.Parent {
+		ranges = append(ranges, fmt.Sprintf("%d:%d-%d:%d",
+			sel.Range.Start.Line, sel.Range.Start.Character,
+			sel.Range.End.Line, sel.Range.End.Character))
+	}
+	return ranges
+}
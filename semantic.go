@@ -0,0 +1,331 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// semanticTokensOptions are the semantic tokens capabilities of the server.
+// The protocol package does not support the legend of the semantic tokens
+// options yet.
+type semanticTokensOptions struct {
+	Legend lsp.SemanticTokensLegend `json:"legend"`
+	Range  bool                     `json:"range"`
+	Full   bool                     `json:"full"`
+}
+
+// token types, the values are the indexes in the legend.
+const (
+	tokenKeyword uint32 = iota
+	tokenType
+	tokenProperty
+	tokenVariable
+	tokenNamespace
+	tokenFunction
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenComment
+)
+
+// token modifiers, the values are the bits in the legend.
+const (
+	modDeclaration uint32 = 1 << iota
+	modReadonly
+	modDeprecated
+	modDefaultLibrary
+)
+
+// semanticTokensLegend is the legend of the token types and modifiers. Its
+// order must match the token types and modifiers constants.
+func semanticTokensLegend() lsp.SemanticTokensLegend {
+	return lsp.SemanticTokensLegend{
+		TokenTypes: []lsp.SemanticTokenTypes{
+			lsp.SemanticTokenKeyword,
+			lsp.SemanticTokenType,
+			lsp.SemanticTokenProperty,
+			lsp.SemanticTokenVariable,
+			lsp.SemanticTokenNamespace,
+			lsp.SemanticTokenFunction,
+			lsp.SemanticTokenString,
+			lsp.SemanticTokenNumber,
+			lsp.SemanticTokenOperator,
+			lsp.SemanticTokenComment,
+		},
+		TokenModifiers: []lsp.SemanticTokenModifiers{
+			lsp.SemanticTokenModifierDeclaration,
+			lsp.SemanticTokenModifierReadonly,
+			lsp.SemanticTokenModifierDeprecated,
+			lsp.SemanticTokenModifierDefaultLibrary,
+		},
+	}
+}
+
+// deprecatedMetadata are the terramate metadata replaced by the
+// terramate.stack.* metadata.
+var deprecatedMetadata = map[string]bool{
+	"name":        true,
+	"path":        true,
+	"description": true,
+}
+
+func (s *Server) handleSemanticTokensFull(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.SemanticTokensParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, &lsp.SemanticTokens{
+		Data: encodeTokens(content, semanticTokens(fname, content), 0, len(content)),
+	}, nil)
+}
+
+func (s *Server) handleSemanticTokensRange(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.SemanticTokensRangeParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	start := offsetFor(content, params.Range.Start)
+	end := offsetFor(content, params.Range.End)
+	return reply(ctx, &lsp.SemanticTokens{
+		Data: encodeTokens(content, semanticTokens(fname, content), start, end),
+	}, nil)
+}
+
+// semanticToken is a classified byte range of the content.
+type semanticToken struct {
+	start, end int
+	typ        uint32
+	mods       uint32
+}
+
+// semanticTokens classifies the content of the Terramate file. The tokens are
+// sorted and don't overlap.
+//
+// Identifiers are classified from the syntax tree, as their meaning depends on
+// where they are. Literals, template interpolations, heredocs and comments are
+// classified from the lexer tokens.
+func semanticTokens(fname string, content []byte) []semanticToken {
+	var tokens []semanticToken
+	add := func(rng hhcl.Range, typ, mods uint32) {
+		tokens = append(tokens, semanticToken{
+			start: rng.Start.Byte,
+			end:   rng.End.Byte,
+			typ:   typ,
+			mods:  mods,
+		})
+	}
+
+	body := parseBody(fname, content)
+	bodyTokens(body, "", add)
+
+	syntaxTokens := len(tokens)
+	lexTokens, _ := hclsyntax.LexConfig(content, fname, hhcl.InitialPos)
+	for _, tok := range lexTokens {
+		switch tok.Type {
+		case hclsyntax.TokenOQuote, hclsyntax.TokenCQuote,
+			hclsyntax.TokenQuotedLit, hclsyntax.TokenStringLit:
+			add(tok.Range, tokenString, 0)
+		case hclsyntax.TokenOHeredoc, hclsyntax.TokenCHeredoc:
+			add(tok.Range, tokenKeyword, 0)
+		case hclsyntax.TokenNumberLit:
+			add(tok.Range, tokenNumber, 0)
+		case hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl,
+			hclsyntax.TokenTemplateSeqEnd:
+			add(tok.Range, tokenOperator, 0)
+		case hclsyntax.TokenComment:
+			add(tok.Range, tokenComment, 0)
+		case hclsyntax.TokenIdent:
+			switch string(tok.Bytes) {
+			case "true", "false", "null":
+				add(tok.Range, tokenKeyword, 0)
+			}
+		}
+	}
+
+	// the syntax tokens win over the lexer tokens, eg.: a quoted block label
+	// is a single label token instead of three string tokens.
+	sort.SliceStable(tokens, func(i, j int) bool {
+		if tokens[i].start != tokens[j].start {
+			return tokens[i].start < tokens[j].start
+		}
+		return i < syntaxTokens && j >= syntaxTokens
+	})
+
+	result := make([]semanticToken, 0, len(tokens))
+	last := -1
+	for _, tok := range tokens {
+		if tok.start < last || tok.start >= tok.end {
+			continue
+		}
+		result = append(result, tok)
+		last = tok.end
+	}
+	return result
+}
+
+// bodyTokens adds the tokens of the identifiers of the body, which is inside a
+// block of the given type.
+func bodyTokens(body *hclsyntax.Body, blockType string, add func(hhcl.Range, uint32, uint32)) {
+	for _, attr := range body.Attributes {
+		if blockType == "globals" {
+			add(attr.NameRange, tokenVariable, modDeclaration)
+		} else {
+			add(attr.NameRange, tokenProperty, 0)
+		}
+		exprTokens(attr.Expr, add)
+	}
+	for _, block := range body.Blocks {
+		add(block.TypeRange, tokenKeyword, 0)
+		for _, rng := range block.LabelRanges {
+			add(rng, tokenType, 0)
+		}
+		bodyTokens(block.Body, block.Type, add)
+	}
+}
+
+// exprTokens adds the tokens of the traversals and function calls of the
+// expression.
+func exprTokens(expr hclsyntax.Expression, add func(hhcl.Range, uint32, uint32)) {
+	_ = hclsyntax.VisitAll(expr, func(node hclsyntax.Node) hhcl.Diagnostics {
+		switch n := node.(type) {
+		case *hclsyntax.FunctionCallExpr:
+			var mods uint32
+			if strings.HasPrefix(n.Name, "tm_") {
+				mods = modDefaultLibrary
+			}
+			add(n.NameRange, tokenFunction, mods)
+		case *hclsyntax.ScopeTraversalExpr:
+			root := n.Traversal.RootName()
+			if root != "global" && root != "terramate" {
+				return nil
+			}
+			add(n.Traversal[0].SourceRange(), tokenNamespace, 0)
+			for i, rng := range traversalNameRanges(n.Traversal) {
+				if root == "global" {
+					add(rng, tokenVariable, 0)
+					continue
+				}
+				mods := modReadonly
+				name := n.Traversal[i+1].(hhcl.TraverseAttr).Name
+				if i == 0 && deprecatedMetadata[name] {
+					mods |= modDeprecated
+				}
+				add(rng, tokenProperty, mods)
+			}
+		}
+		return nil
+	})
+}
+
+// encodeTokens encodes the tokens overlapping the byte range [start, end] in
+// the relative format of the protocol. Tokens spanning multiple lines are
+// split at the line breaks.
+func encodeTokens(content []byte, tokens []semanticToken, start, end int) []uint32 {
+	data := []uint32{}
+
+	var (
+		offset, line, char   int
+		lastLine, lastChar   int
+		tokenLine, tokenChar int
+	)
+
+	// advance moves the position until the byte offset, calling fn at each
+	// line break found.
+	advance := func(to int, fn func()) {
+		for offset < to && offset < len(content) {
+			r, size := utf8.DecodeRune(content[offset:])
+			if r == '\n' {
+				if fn != nil {
+					fn()
+				}
+				line++
+				char = 0
+			} else {
+				char += len(utf16.Encode([]rune{r}))
+			}
+			offset += size
+		}
+	}
+
+	emit := func(length int, tok semanticToken) {
+		if length <= 0 {
+			return
+		}
+		deltaChar := tokenChar
+		if tokenLine == lastLine {
+			deltaChar = tokenChar - lastChar
+		}
+		data = append(data,
+			uint32(tokenLine-lastLine),
+			uint32(deltaChar),
+			uint32(length),
+			tok.typ,
+			tok.mods,
+		)
+		lastLine, lastChar = tokenLine, tokenChar
+	}
+
+	for _, tok := range tokens {
+		if tok.end < start || tok.start > end {
+			continue
+		}
+		advance(tok.start, nil)
+		tokenLine, tokenChar = line, char
+		advance(tok.end, func() {
+			emit(char-tokenChar, tok)
+			tokenLine, tokenChar = line+1, 0
+		})
+		emit(char-tokenChar, tok)
+	}
+	return data
+}
@@ -0,0 +1,181 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestSemanticTokens(t *testing.T) {
+	type testcase struct {
+		name    string
+		content string
+		rng     *lsp.Range
+		want    []string
+	}
+
+	for _, tc := range []testcase{
+		{
+			name:    "empty document",
+			content: "",
+			want:    []string{},
+		},
+		{
+			name:    "stack block",
+			content: "stack {\n  name = \"app\"\n  after = [\"/other\"]\n}\n",
+			want: []string{
+				`0:0 "stack" keyword`,
+				`1:2 "name" property`,
+				`1:9 "\"" string`,
+				`1:10 "app" string`,
+				`1:13 "\"" string`,
+				`2:2 "after" property`,
+				`2:11 "\"" string`,
+				`2:12 "/other" string`,
+				`2:18 "\"" string`,
+			},
+		},
+		{
+			name:    "globals and labels",
+			content: "globals \"obj\" {\n  a = 1 # comment\n  b = global.obj.a\n  c = true\n}\n",
+			want: []string{
+				`0:0 "globals" keyword`,
+				`0:8 "\"obj\"" type`,
+				`1:2 "a" variable declaration`,
+				`1:6 "1" number`,
+				`1:8 "# comment" comment`,
+				`2:2 "b" variable declaration`,
+				`2:6 "global" namespace`,
+				`2:13 "obj" variable`,
+				`2:17 "a" variable`,
+				`3:2 "c" variable declaration`,
+				`3:6 "true" keyword`,
+			},
+		},
+		{
+			name:    "metadata and functions",
+			content: "globals {\n  a = tm_upper(terramate.name)\n  b = terramate.stack.path.absolute\n}\n",
+			want: []string{
+				`0:0 "globals" keyword`,
+				`1:2 "a" variable declaration`,
+				`1:6 "tm_upper" function defaultLibrary`,
+				`1:15 "terramate" namespace`,
+				`1:25 "name" property readonly,deprecated`,
+				`2:2 "b" variable declaration`,
+				`2:6 "terramate" namespace`,
+				`2:16 "stack" property readonly`,
+				`2:22 "path" property readonly`,
+				`2:27 "absolute" property readonly`,
+			},
+		},
+		{
+			name:    "template interpolation",
+			content: "globals {\n  a = \"x-${global.b}\"\n}\n",
+			want: []string{
+				`0:0 "globals" keyword`,
+				`1:2 "a" variable declaration`,
+				`1:6 "\"" string`,
+				`1:7 "x-" string`,
+				`1:9 "${" operator`,
+				`1:11 "global" namespace`,
+				`1:18 "b" variable`,
+				`1:19 "}" operator`,
+				`1:20 "\"" string`,
+			},
+		},
+		{
+			name:    "heredocs are split in lines",
+			content: "globals {\n  a = <<-EOT\n  one\n  two\n  EOT\n}\n",
+			want: []string{
+				`0:0 "globals" keyword`,
+				`1:2 "a" variable declaration`,
+				`1:6 "<<-EOT" keyword`,
+				`2:0 "  one" string`,
+				`3:0 "  two" string`,
+				`4:0 "  EOT" keyword`,
+			},
+		},
+		{
+			name:    "range",
+			content: "globals {\n  a = 1\n  b = 2\n  c = 3\n}\n",
+			rng: &lsp.Range{
+				Start: lsp.Position{Line: 2, Character: 0},
+				End:   lsp.Position{Line: 2, Character: 7},
+			},
+			want: []string{
+				`2:2 "b" variable declaration`,
+				`2:6 "2" number`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := test.Setup(t, "f:file.tm:"+tc.content)
+			f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+			var got *lsp.SemanticTokens
+			if tc.rng != nil {
+				got = f.Editor.SemanticTokensRange("file.tm", *tc.rng)
+			} else {
+				got = f.Editor.SemanticTokens("file.tm")
+			}
+			if got == nil {
+				t.Fatal("no semantic tokens")
+			}
+			if diff := cmp.Diff(tc.want, decodeTokens(tc.content, got.Data)); diff != "" {
+				t.Fatalf("tokens mismatch, want(-) got(+):\n%s", diff)
+			}
+		})
+	}
+}
+
+// decodeTokens decodes the semantic tokens data into readable tokens, in the
+// `line:char "text" type modifiers` form.
+func decodeTokens(content string, data []uint32) []string {
+	legend := test.DefaultInitializeResult().Capabilities.SemanticTokensProvider.(map[string]interface{})["legend"].(map[string]interface{})
+	types := legend["tokenTypes"].([]interface{})
+	modifiers := legend["tokenModifiers"].([]interface{})
+
+	lines := strings.Split(content, "\n")
+	tokens := []string{}
+	var line, char uint32
+	for i := 0; i+4 < len(data); i += 5 {
+		if data[i] > 0 {
+			char = 0
+		}
+		line += data[i]
+		char += data[i+1]
+
+		text := lines[line][char : char+data[i+2]]
+		token := fmt.Sprintf("%d:%d %q %s", line, char, text, types[data[i+3]])
+
+		var mods []string
+		for bit, mod := range modifiers {
+			if data[i+4]&(1<<bit) != 0 {
+				mods = append(mods, mod.(string))
+			}
+		}
+		if len(mods) > 0 {
+			token += " " + strings.Join(mods, ",")
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
@@ -0,0 +1,251 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"time"
+
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// settingsSection is the section of the editor configuration with the
+// settings of the language server.
+const settingsSection = "terramate"
+
+// Experimental features enabled with the experimental setting.
+const (
+	// experimentalChangedStacks reports the stacks changed in the git branch
+	// as diagnostics of their stack blocks.
+	experimentalChangedStacks = "changed-stacks-diagnostics"
+)
+
+// lintSeverities are the severities of the lint setting. The problems of the
+// rules set to "off" are not reported.
+var lintSeverities = map[string]lsp.DiagnosticSeverity{
+	"error":       lsp.DiagnosticSeverityError,
+	"warning":     lsp.DiagnosticSeverityWarning,
+	"information": lsp.DiagnosticSeverityInformation,
+	"hint":        lsp.DiagnosticSeverityHint,
+	"off":         0,
+}
+
+// settings are the settings of the language server, which the editors keep
+// in the "terramate" section of their configuration. The zero value is the
+// default configuration.
+type settings struct {
+	// Lint maps the codes of the problems found by the language server, eg.:
+	// "missing-stack", to their severity: "error", "warning", "information",
+	// "hint" or "off". The problems not listed keep their default severity.
+	Lint map[string]string `json:"lint,omitempty"`
+
+	// DebounceDelay is the time, in milliseconds, the language server waits
+	// after a change before checking the file again. The files are checked at
+	// every change if it is zero.
+	DebounceDelay int `json:"debounceDelay,omitempty"`
+
+	// GenerateCheck tells if the stacks whose generated code is outdated are
+	// reported, as `terramate generate` would change their files.
+	GenerateCheck bool `json:"generateCheck,omitempty"`
+
+	// Ignore are the patterns of the project paths, eg.: "/modules/*", whose
+	// files are neither checked nor indexed. The patterns have the syntax of
+	// path.Match and also ignore the files inside the matched directories.
+	Ignore []string `json:"ignore,omitempty"`
+
+	// LogLevel is the level of the logs of the language server: "trace",
+	// "debug", "info", "warn", "error" or "fatal". The level given on the
+	// command line is used if it is empty.
+	LogLevel string `json:"logLevel,omitempty"`
+
+	// Experimental are the names of the experimental features enabled.
+	Experimental []string `json:"experimental,omitempty"`
+}
+
+// validate logs and removes the invalid settings, so the valid ones are still
+// applied.
+func (cfg *settings) validate(log zerolog.Logger) {
+	for code, severity := range cfg.Lint {
+		if _, ok := lintSeverities[severity]; !ok {
+			log.Warn().Str("rule", code).Str("severity", severity).Msg("ignoring invalid lint severity")
+			delete(cfg.Lint, code)
+		}
+	}
+	if cfg.DebounceDelay < 0 {
+		log.Warn().Int("delay", cfg.DebounceDelay).Msg("ignoring negative debounce delay")
+		cfg.DebounceDelay = 0
+	}
+
+	patterns := cfg.Ignore[:0]
+	for _, pattern := range cfg.Ignore {
+		if _, err := path.Match(pattern, ""); err != nil {
+			log.Warn().Err(err).Str("pattern", pattern).Msg("ignoring invalid ignore pattern")
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	cfg.Ignore = patterns
+
+	switch cfg.LogLevel {
+	case "", "trace", "debug", "info", "warn", "error", "fatal":
+	default:
+		log.Warn().Str("level", cfg.LogLevel).Msg("ignoring invalid log level")
+		cfg.LogLevel = ""
+	}
+
+	features := cfg.Experimental[:0]
+	for _, feature := range cfg.Experimental {
+		if feature != experimentalChangedStacks {
+			log.Warn().Str("feature", feature).Msg("ignoring unknown experimental feature")
+			continue
+		}
+		features = append(features, feature)
+	}
+	cfg.Experimental = features
+}
+
+// debounceDelay returns the delay of the checks after a change.
+func (cfg settings) debounceDelay() time.Duration {
+	return time.Duration(cfg.DebounceDelay) * time.Millisecond
+}
+
+// experimental tells if the experimental feature is enabled.
+func (cfg settings) experimental(feature string) bool {
+	for _, enabled := range cfg.Experimental {
+		if enabled == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ignored tells if the project path, or one of its parent directories,
+// matches one of the ignore patterns.
+func (cfg settings) ignored(prjpath project.Path) bool {
+	for p := prjpath.String(); ; p = path.Dir(p) {
+		for _, pattern := range cfg.Ignore {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+		if p == "/" {
+			return false
+		}
+	}
+}
+
+// applyLint changes the severity of the problems as configured, removing the
+// problems of the rules turned off.
+func (cfg settings) applyLint(problems []problem) []problem {
+	if len(cfg.Lint) == 0 {
+		return problems
+	}
+	configured := problems[:0]
+	for _, p := range problems {
+		if name, ok := cfg.Lint[p.code]; ok {
+			p.severity = lintSeverities[name]
+			if p.severity == 0 {
+				continue
+			}
+		}
+		configured = append(configured, p)
+	}
+	return configured
+}
+
+// settings returns the current settings of the server.
+func (s *Server) settings() settings {
+	s.settingsMu.Lock()
+	defer s.settingsMu.Unlock()
+	return s.cfg
+}
+
+// applySettings replaces the settings of the server and updates the state
+// depending on them: the log level, the indexes, which depend on the ignored
+// paths, and the diagnostics of the opened files.
+func (s *Server) applySettings(ctx context.Context, cfg settings) {
+	cfg.validate(s.log)
+
+	s.settingsMu.Lock()
+	s.cfg = cfg
+	s.settingsMu.Unlock()
+
+	level := s.logLevel
+	if cfg.LogLevel != "" {
+		level, _ = zerolog.ParseLevel(cfg.LogLevel)
+	}
+	zerolog.SetGlobalLevel(level)
+	s.dropIndexes()
+	s.republishDiagnostics(ctx)
+}
+
+// fetchSettings asks the editor for the settings and applies them.
+//
+// It must not be called from a request handler, as it waits for the editor.
+func (s *Server) fetchSettings(ctx context.Context) {
+	var result []json.RawMessage
+	_, err := s.conn.Call(ctx, lsp.MethodWorkspaceConfiguration, lsp.ConfigurationParams{
+		Items: []lsp.ConfigurationItem{{Section: settingsSection}},
+	}, &result)
+	if err != nil {
+		s.log.Error().Err(err).Msg("failed to fetch the settings")
+		return
+	}
+
+	var cfg settings
+	if len(result) > 0 && string(result[0]) != "null" {
+		if err := json.Unmarshal(result[0], &cfg); err != nil {
+			s.log.Error().Err(err).Msg("failed to decode the settings")
+			return
+		}
+	}
+	s.applySettings(ctx, cfg)
+}
+
+func (s *Server) handleDidChangeConfiguration(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	if s.configuration {
+		// the editor may not send the settings, which are fetched instead.
+		go s.fetchSettings(ctx)
+		return reply(ctx, nil, nil)
+	}
+
+	var params struct {
+		Settings map[string]json.RawMessage `json:"settings"`
+	}
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	var cfg settings
+	if raw, ok := params.Settings[settingsSection]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			log.Error().Err(err).Msg("failed to decode the settings")
+			return reply(ctx, nil, nil)
+		}
+	}
+	s.applySettings(ctx, cfg)
+	return reply(ctx, nil, nil)
+}
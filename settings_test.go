@@ -0,0 +1,156 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate-ls/test"
+	"github.com/rs/zerolog"
+	lsp "go.lsp.dev/protocol"
+)
+
+// diagnosticCodes returns the codes and severities of the diagnostics.
+func diagnosticCodes(diags []lsp.Diagnostic) map[string]lsp.DiagnosticSeverity {
+	codes := map[string]lsp.DiagnosticSeverity{}
+	for _, diag := range diags {
+		code, _ := diag.Code.(string)
+		codes[code] = diag.Severity
+	}
+	return codes
+}
+
+func TestSettingsFetched(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {\n  after = [\"../b\"]\n}\n\nglobals {\n  a = tm_uper(\"a\")\n}\n",
+		"f:b/stack.tm:stack {}\n",
+	)
+	f.Editor.Settings = map[string]interface{}{
+		"lint": map[string]string{
+			"unknown-function":    "off",
+			"relative-stack-path": "warning",
+		},
+	}
+	f.Editor.InitializeWith(f.Sandbox.RootDir(), lsp.ClientCapabilities{
+		Workspace: &lsp.WorkspaceClientCapabilities{Configuration: true},
+	})
+	assert.EqualStrings(t, lsp.MethodWindowShowMessage, (<-f.Editor.Requests).Method())
+
+	f.Editor.Open("a/stack.tm")
+	want := map[string]lsp.DiagnosticSeverity{
+		"relative-stack-path": lsp.DiagnosticSeverityHint,
+		"unknown-function":    lsp.DiagnosticSeverityError,
+	}
+	if diff := cmp.Diff(want, diagnosticCodes(f.Editor.Diagnostics("a/stack.tm"))); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	// the settings are fetched once the editor is initialized.
+	f.Editor.Initialized()
+	for r := range f.Editor.Requests {
+		if r.Method() == lsp.MethodWorkspaceConfiguration {
+			break
+		}
+	}
+	want = map[string]lsp.DiagnosticSeverity{
+		"relative-stack-path": lsp.DiagnosticSeverityWarning,
+	}
+	if diff := cmp.Diff(want, diagnosticCodes(f.Editor.Diagnostics("a/stack.tm"))); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+	drainRequests(f.Editor)
+}
+
+func TestSettingsChanged(t *testing.T) {
+	f := test.Setup(t,
+		"f:generate.tm:generate_hcl \"main.tf\" {\n  content {\n    a = 1\n  }\n}\n",
+		"f:stack/stack.tm:stack {}\n",
+		"f:ignored/stack.tm:stack {\n  name = \"ignored\"\n}\n\nglobals {\n  a = tm_uper(\"a\")\n}\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	f.Editor.Open("stack/stack.tm")
+	if diags := f.Editor.Diagnostics("stack/stack.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics, got %+v", diags)
+	}
+	f.Editor.Open("ignored/stack.tm")
+	if diags := f.Editor.Diagnostics("ignored/stack.tm"); len(diags) == 0 {
+		t.Fatal("want the unknown function")
+	}
+	if symbols := f.Editor.WorkspaceSymbols("ignored"); len(symbols) != 1 {
+		t.Fatalf("want the ignored stack, got %+v", symbols)
+	}
+
+	// the settings apply to the running server.
+	f.Editor.ChangeConfiguration(map[string]interface{}{
+		"terramate": map[string]interface{}{
+			"generateCheck": true,
+			"ignore":        []string{"/ign*"},
+			"debounceDelay": 10,
+		},
+	})
+	if diags := f.Editor.Diagnostics("ignored/stack.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics of ignored files, got %+v", diags)
+	}
+	want := map[string]lsp.DiagnosticSeverity{
+		"outdated-generated-code": lsp.DiagnosticSeverityWarning,
+	}
+	if diff := cmp.Diff(want, diagnosticCodes(f.Editor.Diagnostics("stack/stack.tm"))); diff != "" {
+		t.Fatalf("diagnostics mismatch, want(-) got(+):\n%s", diff)
+	}
+	if symbols := f.Editor.WorkspaceSymbols("ignored"); len(symbols) != 0 {
+		t.Fatalf("want no symbols of ignored files, got %+v", symbols)
+	}
+
+	// the changes are checked after the debounce delay.
+	f.Editor.Change("stack/stack.tm", "stack {\n  after = [\"../ignored\"]\n}\n")
+	f.Editor.Change("stack/stack.tm", "stack {\n  after = [\"/ignored\"]\n}\n")
+	if diags := f.Editor.Diagnostics("stack/stack.tm"); len(diags) != 1 {
+		t.Fatalf("want the last change checked, got %+v", diags)
+	}
+	drainRequests(f.Editor)
+
+	// the defaults are restored without settings.
+	f.Editor.ChangeConfiguration(map[string]interface{}{})
+	if diags := f.Editor.Diagnostics("stack/stack.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics, got %+v", diags)
+	}
+	drainRequests(f.Editor)
+}
+
+func TestSettingsLogLevel(t *testing.T) {
+	level := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+	f := test.Setup(t)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	f.Editor.ChangeConfiguration(map[string]interface{}{
+		"terramate": map[string]interface{}{"logLevel": "error"},
+	})
+	if got := zerolog.GlobalLevel(); got != zerolog.ErrorLevel {
+		t.Fatalf("want error log level, got %s", got)
+	}
+
+	// the level of the server creation is restored when the setting is cleared.
+	f.Editor.ChangeConfiguration(map[string]interface{}{
+		"terramate": map[string]interface{}{"logLevel": ""},
+	})
+	if got := zerolog.GlobalLevel(); got != level {
+		t.Fatalf("want %s log level, got %s", level, got)
+	}
+}
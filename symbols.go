@@ -0,0 +1,178 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+func (s *Server) handleDocumentSymbol(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DocumentSymbolParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	fname := params.TextDocument.URI.Filename()
+	content, err := s.documents.read(fname)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to read document")
+		return reply(ctx, nil, nil)
+	}
+	return reply(ctx, documentSymbols(parseBody(fname, content)), nil)
+}
+
+// documentSymbols returns the outline of the Terramate file body.
+// Blocks unknown to the outline are ignored.
+func documentSymbols(body *hclsyntax.Body) []lsp.DocumentSymbol {
+	symbols := []lsp.DocumentSymbol{}
+	for _, block := range body.Blocks {
+		switch block.Type {
+		case "stack":
+			sym := blockSymbol(block, lsp.SymbolKindModule)
+			if attr, ok := block.Body.Attributes["name"]; ok {
+				sym.Detail, _ = stringLiteral(attr.Expr)
+			}
+			symbols = append(symbols, sym)
+		case "globals":
+			sym := blockSymbol(block, lsp.SymbolKindNamespace)
+			sym.Children = globalsSymbols(block)
+			symbols = append(symbols, sym)
+		case "generate_hcl", "generate_file":
+			sym := blockSymbol(block, lsp.SymbolKindFile)
+			sym.Detail = block.Type
+			for _, child := range block.Body.Blocks {
+				if child.Type == "assert" {
+					sym.Children = append(sym.Children, assertSymbol(child))
+				}
+			}
+			symbols = append(symbols, sym)
+		case "import":
+			sym := blockSymbol(block, lsp.SymbolKindPackage)
+			if attr, ok := block.Body.Attributes["source"]; ok {
+				sym.Detail, _ = stringLiteral(attr.Expr)
+			}
+			symbols = append(symbols, sym)
+		case "terramate":
+			symbols = append(symbols, configSymbol(block))
+		case "assert":
+			symbols = append(symbols, assertSymbol(block))
+		}
+	}
+	return symbols
+}
+
+// blockSymbol returns the symbol of the block, named after its type and
+// labels, eg.: generate_hcl "main.tf".
+func blockSymbol(block *hclsyntax.Block, kind lsp.SymbolKind) lsp.DocumentSymbol {
+	name := block.Type
+	selection := block.TypeRange
+	if len(block.Labels) > 0 {
+		quoted := make([]string, len(block.Labels))
+		for i, label := range block.Labels {
+			quoted[i] = fmt.Sprintf("%q", label)
+		}
+		name = block.Type + " " + strings.Join(quoted, " ")
+		selection = hhcl.RangeBetween(block.TypeRange, block.LabelRanges[len(block.LabelRanges)-1])
+	}
+	return lsp.DocumentSymbol{
+		Name:           name,
+		Kind:           kind,
+		Range:          lspRange(block.Range()),
+		SelectionRange: lspRange(selection),
+	}
+}
+
+// globalsSymbols returns a symbol for each global defined in the globals block.
+func globalsSymbols(block *hclsyntax.Block) []lsp.DocumentSymbol {
+	var symbols []lsp.DocumentSymbol
+	for _, attr := range sortedAttributes(block.Body.Attributes) {
+		path := append(append([]string{}, block.Labels...), attr.Name)
+		symbols = append(symbols, lsp.DocumentSymbol{
+			Name:           attr.Name,
+			Detail:         globalDefinition{path: path}.name(),
+			Kind:           lsp.SymbolKindVariable,
+			Range:          lspRange(attr.Range()),
+			SelectionRange: lspRange(attr.NameRange),
+		})
+	}
+	for _, child := range block.Body.Blocks {
+		if child.Type != "map" || len(child.Labels) != 1 {
+			continue
+		}
+		path := append(append([]string{}, block.Labels...), child.Labels[0])
+		symbols = append(symbols, lsp.DocumentSymbol{
+			Name:           child.Labels[0],
+			Detail:         globalDefinition{path: path}.name(),
+			Kind:           lsp.SymbolKindObject,
+			Range:          lspRange(child.Range()),
+			SelectionRange: lspRange(child.LabelRanges[0]),
+		})
+	}
+	sortSymbols(symbols)
+	return symbols
+}
+
+// configSymbol returns the symbol of the terramate block, including all of its
+// nested blocks and attributes.
+func configSymbol(block *hclsyntax.Block) lsp.DocumentSymbol {
+	sym := blockSymbol(block, lsp.SymbolKindNamespace)
+	for _, attr := range sortedAttributes(block.Body.Attributes) {
+		sym.Children = append(sym.Children, lsp.DocumentSymbol{
+			Name:           attr.Name,
+			Kind:           lsp.SymbolKindProperty,
+			Range:          lspRange(attr.Range()),
+			SelectionRange: lspRange(attr.NameRange),
+		})
+	}
+	for _, child := range block.Body.Blocks {
+		sym.Children = append(sym.Children, configSymbol(child))
+	}
+	sortSymbols(sym.Children)
+	return sym
+}
+
+// assertSymbol returns the symbol of the assert block, detailed with its
+// message if it is a literal string.
+func assertSymbol(block *hclsyntax.Block) lsp.DocumentSymbol {
+	sym := blockSymbol(block, lsp.SymbolKindBoolean)
+	if attr, ok := block.Body.Attributes["message"]; ok {
+		sym.Detail, _ = stringLiteral(attr.Expr)
+	}
+	return sym
+}
+
+// sortSymbols sorts the symbols by their position in the file.
+func sortSymbols(symbols []lsp.DocumentSymbol) {
+	sort.Slice(symbols, func(i, j int) bool {
+		a, b := symbols[i].Range.Start, symbols[j].Range.Start
+		return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestDocumentSymbols(t *testing.T) {
+	content := strings.Join([]string{
+		`stack {`,
+		`  name = "my-stack"`,
+		`}`,
+		``,
+		`globals "obj" {`,
+		`  b = 1`,
+		`  map "m" {`,
+		`  }`,
+		`  a = 2`,
+		`}`,
+		``,
+		`generate_hcl "main.tf" {`,
+		`  assert {`,
+		`    assertion = true`,
+		`    message   = "always"`,
+		`  }`,
+		`  content {`,
+		`    a = 1`,
+		`  }`,
+		`}`,
+		``,
+		`import {`,
+		`  source = "/modules/g.tm"`,
+		`}`,
+		``,
+		`terramate {`,
+		`  required_version = "~> 0.2"`,
+		`  config {`,
+		`    git {`,
+		`      default_branch = "main"`,
+		`    }`,
+		`  }`,
+		`}`,
+		``,
+		`vendor {`,
+		`}`,
+	}, "\n")
+
+	f := test.Setup(t, "f:stack.tm:"+content)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	got := f.Editor.DocumentSymbols("stack.tm")
+	want := []string{
+		`stack (my-stack) kind=2 lines=0-2`,
+		`globals "obj" kind=3 lines=4-9`,
+		`  b (global.obj.b) kind=13 lines=5-5`,
+		`  m (global.obj.m) kind=19 lines=6-7`,
+		`  a (global.obj.a) kind=13 lines=8-8`,
+		`generate_hcl "main.tf" (generate_hcl) kind=1 lines=11-19`,
+		`  assert (always) kind=17 lines=12-15`,
+		`import (/modules/g.tm) kind=4 lines=21-23`,
+		`terramate kind=3 lines=25-32`,
+		`  required_version kind=7 lines=26-26`,
+		`  config kind=3 lines=27-31`,
+		`    git kind=3 lines=28-30`,
+		`      default_branch kind=7 lines=29-29`,
+	}
+	if diff := cmp.Diff(want, outline(got, "")); diff != "" {
+		t.Fatalf("outline mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	wantSelection := lsp.Range{
+		Start: lsp.Position{Line: 11, Character: 0},
+		End:   lsp.Position{Line: 11, Character: 22},
+	}
+	if got[2].SelectionRange != wantSelection {
+		t.Fatalf("selection range got %v != want %v", got[2].SelectionRange, wantSelection)
+	}
+}
+
+func outline(symbols []lsp.DocumentSymbol, indent string) []string {
+	var lines []string
+	for _, sym := range symbols {
+		line := indent + sym.Name
+		if sym.Detail != "" {
+			line += " (" + sym.Detail + ")"
+		}
+		line += fmt.Sprintf(" kind=%d lines=%d-%d", int(sym.Kind), sym.Range.Start.Line, sym.Range.End.Line)
+		lines = append(lines, line)
+		lines = append(lines, outline(sym.Children, indent+"  ")...)
+	}
+	return lines
+}
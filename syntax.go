@@ -0,0 +1,189 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	hhcl "github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// parseBody parses the content of a Terramate file.
+// The returned body is partial if the content has syntax errors.
+func parseBody(fname string, content []byte) *hclsyntax.Body {
+	file, _ := hclsyntax.ParseConfig(content, fname, hhcl.InitialPos)
+	if file != nil {
+		if body, ok := file.Body.(*hclsyntax.Body); ok {
+			return body
+		}
+	}
+	return &hclsyntax.Body{
+		Attributes: hclsyntax.Attributes{},
+	}
+}
+
+// containsOffset tells if the byte offset is inside the range r.
+// The end of the range is inclusive, so a cursor placed right after a
+// word is still considered inside it.
+func containsOffset(r hhcl.Range, offset int) bool {
+	return r.Start.Byte <= offset && offset <= r.End.Byte
+}
+
+// nodesAt returns the syntax nodes containing the byte offset, ordered from
+// the outermost to the innermost node.
+func nodesAt(body *hclsyntax.Body, offset int) []hclsyntax.Node {
+	var nodes []hclsyntax.Node
+	_ = hclsyntax.VisitAll(body, func(node hclsyntax.Node) hhcl.Diagnostics {
+		if containsOffset(node.Range(), offset) {
+			nodes = append(nodes, node)
+		}
+		return nil
+	})
+	return nodes
+}
+
+// blocksAt returns the blocks containing the byte offset, ordered from the
+// outermost to the innermost block.
+func blocksAt(body *hclsyntax.Body, offset int) []*hclsyntax.Block {
+	var blocks []*hclsyntax.Block
+	for _, node := range nodesAt(body, offset) {
+		if block, ok := node.(*hclsyntax.Block); ok {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// traversalAt returns the innermost scope traversal containing the byte offset.
+func traversalAt(body *hclsyntax.Body, offset int) (*hclsyntax.ScopeTraversalExpr, bool) {
+	var found *hclsyntax.ScopeTraversalExpr
+	for _, node := range nodesAt(body, offset) {
+		if expr, ok := node.(*hclsyntax.ScopeTraversalExpr); ok {
+			found = expr
+		}
+	}
+	return found, found != nil
+}
+
+// funcCallAt returns the function call whose name contains the byte offset.
+func funcCallAt(body *hclsyntax.Body, offset int) (*hclsyntax.FunctionCallExpr, bool) {
+	var found *hclsyntax.FunctionCallExpr
+	for _, node := range nodesAt(body, offset) {
+		if call, ok := node.(*hclsyntax.FunctionCallExpr); ok && containsOffset(call.NameRange, offset) {
+			found = call
+		}
+	}
+	return found, found != nil
+}
+
+// traversalPath returns the attribute names of the traversal after its root
+// name, stopping at the first non-attribute step (eg.: an index).
+// Eg.: global.a.b[0].c returns [a b].
+func traversalPath(traversal hhcl.Traversal) []string {
+	var path []string
+	for _, step := range traversal[1:] {
+		attr, ok := step.(hhcl.TraverseAttr)
+		if !ok {
+			break
+		}
+		path = append(path, attr.Name)
+	}
+	return path
+}
+
+// traversalNameRanges returns the ranges of the names returned by
+// traversalPath, excluding the dot before each of them.
+func traversalNameRanges(traversal hhcl.Traversal) []hhcl.Range {
+	var ranges []hhcl.Range
+	for _, step := range traversal[1:] {
+		attr, ok := step.(hhcl.TraverseAttr)
+		if !ok {
+			break
+		}
+		rng := attr.SrcRange
+		rng.Start = rng.End
+		rng.Start.Byte -= len(attr.Name)
+		rng.Start.Column -= utf8.RuneCountInString(attr.Name)
+		ranges = append(ranges, rng)
+	}
+	return ranges
+}
+
+// attributeAt returns the innermost attribute containing the byte offset and
+// the blocks containing it, ordered from the outermost to the innermost block.
+func attributeAt(body *hclsyntax.Body, offset int) (*hclsyntax.Attribute, []*hclsyntax.Block, bool) {
+	var (
+		found  *hclsyntax.Attribute
+		blocks []*hclsyntax.Block
+	)
+	for _, node := range nodesAt(body, offset) {
+		switch n := node.(type) {
+		case *hclsyntax.Block:
+			blocks = append(blocks, n)
+			found = nil
+		case *hclsyntax.Attribute:
+			found = n
+		}
+	}
+	return found, blocks, found != nil
+}
+
+// stringAt returns the literal string containing the byte offset.
+// The returned range includes the quotes.
+func stringAt(body *hclsyntax.Body, offset int) (string, hhcl.Range, bool) {
+	var (
+		found string
+		rng   hhcl.Range
+		ok    bool
+	)
+	for _, node := range nodesAt(body, offset) {
+		expr, isExpr := node.(hclsyntax.Expression)
+		if !isExpr {
+			continue
+		}
+		if str, isStr := stringLiteral(expr); isStr {
+			found, rng, ok = str, expr.Range(), true
+		}
+	}
+	return found, rng, ok
+}
+
+// stringLiteral returns the value of expr if it is a literal string.
+func stringLiteral(expr hclsyntax.Expression) (string, bool) {
+	tmpl, ok := expr.(*hclsyntax.TemplateExpr)
+	if !ok || !tmpl.IsStringLiteral() {
+		return "", false
+	}
+	val, diags := tmpl.Value(nil)
+	if diags.HasErrors() || val.IsNull() || !val.Type().Equals(cty.String) {
+		return "", false
+	}
+	return val.AsString(), true
+}
+
+// sortedAttributes returns the attributes ordered by their position.
+func sortedAttributes(attrs hclsyntax.Attributes) []*hclsyntax.Attribute {
+	sorted := make([]*hclsyntax.Attribute, 0, len(attrs))
+	for _, attr := range attrs {
+		sorted = append(sorted, attr)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].SrcRange.Start.Byte < sorted[j].SrcRange.Start.Byte
+	})
+	return sorted
+}
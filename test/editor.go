@@ -20,6 +20,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/madlambda/spells/assert"
@@ -36,25 +37,47 @@ type Editor struct {
 	sandbox sandbox.S
 	conn    jsonrpc2.Conn
 
-	// Requests that arrived at the editor.
+	// Requests that arrived at the editor, in the order they arrived.
 	Requests chan jsonrpc2.Request
+
+	// received are the requests not yet forwarded to Requests.
+	received chan jsonrpc2.Request
+
+	// Settings are the settings sent to the language server when it fetches
+	// the configuration. They must be set before the language server is
+	// initialized.
+	Settings interface{}
 }
 
 // NewEditor creates a new editor server.
 func NewEditor(t *testing.T, s sandbox.S, conn jsonrpc2.Conn) *Editor {
-	return &Editor{
+	e := &Editor{
 		t:        t,
 		sandbox:  s,
 		conn:     conn,
 		Requests: make(chan jsonrpc2.Request),
+		received: make(chan jsonrpc2.Request, 1024),
 	}
+	go func() {
+		for r := range e.received {
+			e.Requests <- r
+		}
+	}()
+	return e
 }
 
-// Handler is the default editor request handler.
+// Handler is the default editor request handler. The requests are forwarded
+// to Requests by a single goroutine, so the handler doesn't block and the
+// order of the notifications is kept. The workspace edits are always applied
+// and the configuration requests get the Settings.
 func (e *Editor) Handler(ctx context.Context, reply jsonrpc2.Replier, r jsonrpc2.Request) error {
-	go func() {
-		e.Requests <- r
-	}()
+	e.received <- r
+	switch r.Method() {
+	case lsp.MethodWorkspaceApplyEdit:
+		return reply(ctx, lsp.ApplyWorkspaceEditResponse{Applied: true}, nil)
+	case lsp.MethodWorkspaceConfiguration:
+		return reply(ctx, []interface{}{e.Settings}, nil)
+	}
 	return reply(ctx, nil, nil)
 }
 
@@ -64,13 +87,21 @@ func (e *Editor) call(method string, params, result interface{}) (jsonrpc2.ID, e
 
 // Initialize sends a initialize request to the language server and return its
 // result.
-func (e *Editor) Initialize(workspace string) lsp.InitializeResult {
+func (e *Editor) Initialize(workspace string) InitializeResult {
+	e.t.Helper()
+	return e.InitializeWith(workspace, lsp.ClientCapabilities{})
+}
+
+// InitializeWith sends a initialize request with the given client capabilities
+// to the language server and return its result.
+func (e *Editor) InitializeWith(workspace string, capabilities lsp.ClientCapabilities) InitializeResult {
 	e.t.Helper()
-	var got lsp.InitializeResult
+	var got InitializeResult
 	_, err := e.call(
 		lsp.MethodInitialize,
 		lsp.InitializeParams{
-			RootURI: uri.File(workspace),
+			RootURI:      uri.File(workspace),
+			Capabilities: capabilities,
 		},
 		&got)
 
@@ -78,6 +109,57 @@ func (e *Editor) Initialize(workspace string) lsp.InitializeResult {
 	return got
 }
 
+// InitializeWithFolders sends a initialize request with the given workspace
+// folders, relative to the sandbox, to the language server and return its
+// result.
+func (e *Editor) InitializeWithFolders(folders ...string) InitializeResult {
+	e.t.Helper()
+	var got InitializeResult
+	_, err := e.call(
+		lsp.MethodInitialize,
+		lsp.InitializeParams{
+			RootURI:          uri.File(filepath.Join(e.sandbox.RootDir(), folders[0])),
+			WorkspaceFolders: e.workspaceFolders(folders),
+		},
+		&got)
+
+	assert.NoError(e.t, err, "calling %q", lsp.MethodInitialize)
+	return got
+}
+
+// ChangeWorkspaceFolders sends a didChangeWorkspaceFolders notification to
+// the language server with the folders, relative to the sandbox, added to and
+// removed from the workspace.
+func (e *Editor) ChangeWorkspaceFolders(added, removed []string) {
+	t := e.t
+	t.Helper()
+	_, err := e.call(lsp.MethodWorkspaceDidChangeWorkspaceFolders, lsp.DidChangeWorkspaceFoldersParams{
+		Event: lsp.WorkspaceFoldersChangeEvent{
+			Added:   e.workspaceFolders(added),
+			Removed: e.workspaceFolders(removed),
+		},
+	}, nil)
+	assert.NoError(t, err, "call %q", lsp.MethodWorkspaceDidChangeWorkspaceFolders)
+}
+
+func (e *Editor) workspaceFolders(folders []string) []lsp.WorkspaceFolder {
+	workspaceFolders := []lsp.WorkspaceFolder{}
+	for _, folder := range folders {
+		workspaceFolders = append(workspaceFolders, lsp.WorkspaceFolder{
+			URI:  string(uri.File(filepath.Join(e.sandbox.RootDir(), folder))),
+			Name: filepath.Base(folder),
+		})
+	}
+	return workspaceFolders
+}
+
+// Initialized sends an initialized notification to the language server.
+func (e *Editor) Initialized() {
+	e.t.Helper()
+	_, err := e.call(lsp.MethodInitialized, lsp.InitializedParams{}, nil)
+	assert.NoError(e.t, err, "calling %q", lsp.MethodInitialized)
+}
+
 // CheckInitialize sends an initialize request to the language server and checks
 // if the response is the expected default response (See DefaultInitializeResult).
 func (e *Editor) CheckInitialize(workspace string) {
@@ -138,18 +220,565 @@ func (e *Editor) Change(path, content string) {
 	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentDidChange)
 }
 
+// ChangeConfiguration sends a didChangeConfiguration notification to the
+// language server with the given settings.
+func (e *Editor) ChangeConfiguration(settings interface{}) {
+	t := e.t
+	t.Helper()
+	_, err := e.call(lsp.MethodWorkspaceDidChangeConfiguration, lsp.DidChangeConfigurationParams{
+		Settings: settings,
+	}, nil)
+	assert.NoError(t, err, "call %q", lsp.MethodWorkspaceDidChangeConfiguration)
+}
+
+// ChangeWatchedFiles sends a didChangeWatchedFiles notification to the
+// language server with a change of the given type for each path.
+func (e *Editor) ChangeWatchedFiles(typ lsp.FileChangeType, paths ...string) {
+	t := e.t
+	t.Helper()
+	params := lsp.DidChangeWatchedFilesParams{}
+	for _, path := range paths {
+		params.Changes = append(params.Changes, &lsp.FileEvent{
+			Type: typ,
+			URI:  uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		})
+	}
+	_, err := e.call(lsp.MethodWorkspaceDidChangeWatchedFiles, params, nil)
+	assert.NoError(t, err, "call %q", lsp.MethodWorkspaceDidChangeWatchedFiles)
+}
+
+// Completion sends a completion request to the language server for the given
+// file position and returns its result.
+func (e *Editor) Completion(path string, pos lsp.Position) []lsp.CompletionItem {
+	t := e.t
+	t.Helper()
+	var items []lsp.CompletionItem
+	_, err := e.call(lsp.MethodTextDocumentCompletion, lsp.CompletionParams{
+		TextDocumentPositionParams: e.positionParams(path, pos),
+	}, &items)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentCompletion)
+	return items
+}
+
+// Hover sends a hover request to the language server for the given file
+// position and returns its result.
+func (e *Editor) Hover(path string, pos lsp.Position) *lsp.Hover {
+	t := e.t
+	t.Helper()
+	var hover *lsp.Hover
+	_, err := e.call(lsp.MethodTextDocumentHover, lsp.HoverParams{
+		TextDocumentPositionParams: e.positionParams(path, pos),
+	}, &hover)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentHover)
+	return hover
+}
+
+// Definition sends a definition request to the language server for the given
+// file position and returns its result.
+func (e *Editor) Definition(path string, pos lsp.Position) []lsp.Location {
+	t := e.t
+	t.Helper()
+	var locations []lsp.Location
+	_, err := e.call(lsp.MethodTextDocumentDefinition, lsp.DefinitionParams{
+		TextDocumentPositionParams: e.positionParams(path, pos),
+	}, &locations)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentDefinition)
+	return locations
+}
+
+// References sends a references request to the language server for the given
+// file position and returns its result.
+func (e *Editor) References(path string, pos lsp.Position, includeDecl bool) []lsp.Location {
+	t := e.t
+	t.Helper()
+	var locations []lsp.Location
+	_, err := e.call(lsp.MethodTextDocumentReferences, lsp.ReferenceParams{
+		TextDocumentPositionParams: e.positionParams(path, pos),
+		Context: lsp.ReferenceContext{
+			IncludeDeclaration: includeDecl,
+		},
+	}, &locations)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentReferences)
+	return locations
+}
+
+// PrepareRename sends a prepareRename request to the language server for the
+// given file position and returns its result.
+func (e *Editor) PrepareRename(path string, pos lsp.Position) *lsp.Range {
+	t := e.t
+	t.Helper()
+	var rng *lsp.Range
+	_, err := e.call(lsp.MethodTextDocumentPrepareRename, lsp.PrepareRenameParams{
+		TextDocumentPositionParams: e.positionParams(path, pos),
+	}, &rng)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentPrepareRename)
+	return rng
+}
+
+// Rename sends a rename request to the language server for the given file
+// position. The error is returned as the server refuses invalid renames.
+func (e *Editor) Rename(path string, pos lsp.Position, newName string) (*lsp.WorkspaceEdit, error) {
+	var edit *lsp.WorkspaceEdit
+	_, err := e.call(lsp.MethodTextDocumentRename, lsp.RenameParams{
+		TextDocumentPositionParams: e.positionParams(path, pos),
+		NewName:                    newName,
+	}, &edit)
+	return edit, err
+}
+
+// DocumentSymbols sends a documentSymbol request to the language server for
+// the given file and returns its result.
+func (e *Editor) DocumentSymbols(path string) []lsp.DocumentSymbol {
+	t := e.t
+	t.Helper()
+	var symbols []lsp.DocumentSymbol
+	_, err := e.call(lsp.MethodTextDocumentDocumentSymbol, lsp.DocumentSymbolParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+	}, &symbols)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentDocumentSymbol)
+	return symbols
+}
+
+// Formatting sends a formatting request to the language server for the given
+// file and returns its result.
+func (e *Editor) Formatting(path string) []lsp.TextEdit {
+	t := e.t
+	t.Helper()
+	var edits []lsp.TextEdit
+	_, err := e.call(lsp.MethodTextDocumentFormatting, lsp.DocumentFormattingParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+	}, &edits)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentFormatting)
+	return edits
+}
+
+// RangeFormatting sends a rangeFormatting request to the language server for
+// the given file range and returns its result.
+func (e *Editor) RangeFormatting(path string, rng lsp.Range) []lsp.TextEdit {
+	t := e.t
+	t.Helper()
+	var edits []lsp.TextEdit
+	_, err := e.call(lsp.MethodTextDocumentRangeFormatting, lsp.DocumentRangeFormattingParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		Range: rng,
+	}, &edits)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentRangeFormatting)
+	return edits
+}
+
+// WorkspaceSymbols sends a workspace/symbol request to the language server
+// with the given query and returns its result.
+func (e *Editor) WorkspaceSymbols(query string) []lsp.SymbolInformation {
+	t := e.t
+	t.Helper()
+	var symbols []lsp.SymbolInformation
+	_, err := e.call(lsp.MethodWorkspaceSymbol, lsp.WorkspaceSymbolParams{
+		Query: query,
+	}, &symbols)
+	assert.NoError(t, err, "call %q", lsp.MethodWorkspaceSymbol)
+	return symbols
+}
+
+// WillRenameFiles sends a workspace/willRenameFiles request to the language
+// server for the moved files or directories and returns its result.
+// The files are relative to the sandbox root directory.
+func (e *Editor) WillRenameFiles(files map[string]string) *lsp.WorkspaceEdit {
+	t := e.t
+	t.Helper()
+	var params lsp.RenameFilesParams
+	for from, to := range files {
+		params.Files = append(params.Files, lsp.FileRename{
+			OldURI: string(uri.File(filepath.Join(e.sandbox.RootDir(), from))),
+			NewURI: string(uri.File(filepath.Join(e.sandbox.RootDir(), to))),
+		})
+	}
+	var edit *lsp.WorkspaceEdit
+	_, err := e.call(lsp.MethodWillRenameFiles, params, &edit)
+	assert.NoError(t, err, "call %q", lsp.MethodWillRenameFiles)
+	return edit
+}
+
+// CodeAction is a code action sent by the language server. Its edit supports
+// file operations, unlike the lsp.CodeAction.
+type CodeAction struct {
+	Title       string
+	Kind        lsp.CodeActionKind
+	Diagnostics []lsp.Diagnostic
+	IsPreferred bool
+	Edit        *WorkspaceEdit
+}
+
+// WorkspaceEdit is a workspace edit sent by the language server. The document
+// changes are kept as decoded JSON objects, as they can be text document edits
+// or file operations.
+type WorkspaceEdit struct {
+	Changes         map[lsp.URI][]lsp.TextEdit
+	DocumentChanges []map[string]interface{}
+}
+
+// CodeActions sends a codeAction request to the language server for the given
+// file range and diagnostics and returns its result. If no kinds are given,
+// all the code actions are requested.
+func (e *Editor) CodeActions(
+	path string,
+	rng lsp.Range,
+	diags []lsp.Diagnostic,
+	only ...lsp.CodeActionKind,
+) []CodeAction {
+	t := e.t
+	t.Helper()
+	var actions []CodeAction
+	_, err := e.call(lsp.MethodTextDocumentCodeAction, lsp.CodeActionParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		Range: rng,
+		Context: lsp.CodeActionContext{
+			Diagnostics: diags,
+			Only:        only,
+		},
+	}, &actions)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentCodeAction)
+	return actions
+}
+
+// CodeLenses sends a codeLens request to the language server for the given
+// file and returns its result.
+func (e *Editor) CodeLenses(path string) []lsp.CodeLens {
+	t := e.t
+	t.Helper()
+	var lenses []lsp.CodeLens
+	_, err := e.call(lsp.MethodTextDocumentCodeLens, lsp.CodeLensParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+	}, &lenses)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentCodeLens)
+	return lenses
+}
+
+// DocumentLinks sends a documentLink request to the language server for the
+// given file and returns its result.
+func (e *Editor) DocumentLinks(path string) []lsp.DocumentLink {
+	t := e.t
+	t.Helper()
+	var links []lsp.DocumentLink
+	_, err := e.call(lsp.MethodTextDocumentDocumentLink, lsp.DocumentLinkParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+	}, &links)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentDocumentLink)
+	return links
+}
+
+// ExecuteCommand sends a workspace/executeCommand request to the language
+// server and decodes its result into result. The error of the request is
+// returned.
+func (e *Editor) ExecuteCommand(command string, args []interface{}, result interface{}) error {
+	_, err := e.call(lsp.MethodWorkspaceExecuteCommand, lsp.ExecuteCommandParams{
+		Command:   command,
+		Arguments: args,
+	}, result)
+	return err
+}
+
+// InlayHint is an inlay hint of the textDocument/inlayHint request, which is
+// missing in the protocol package.
+type InlayHint struct {
+	Position    lsp.Position `json:"position"`
+	Label       string       `json:"label"`
+	Tooltip     string       `json:"tooltip,omitempty"`
+	PaddingLeft bool         `json:"paddingLeft,omitempty"`
+}
+
+// InlayHints sends an inlayHint request to the language server for the given
+// file range and returns its result.
+func (e *Editor) InlayHints(path string, rng lsp.Range) []InlayHint {
+	t := e.t
+	t.Helper()
+	var hints []InlayHint
+	_, err := e.call("textDocument/inlayHint", map[string]interface{}{
+		"textDocument": lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		"range": rng,
+	}, &hints)
+	assert.NoError(t, err, "call %q", "textDocument/inlayHint")
+	return hints
+}
+
+// ExecuteCommandWithProgress is like ExecuteCommand but asks the language
+// server to report the progress of the command with the token.
+func (e *Editor) ExecuteCommandWithProgress(command string, args []interface{}, token string, result interface{}) error {
+	_, err := e.call(lsp.MethodWorkspaceExecuteCommand, lsp.ExecuteCommandParams{
+		WorkDoneProgressParams: lsp.WorkDoneProgressParams{
+			WorkDoneToken: lsp.NewProgressToken(token),
+		},
+		Command:   command,
+		Arguments: args,
+	}, result)
+	return err
+}
+
+// GeneratedPreview is a preview of the terramate/generatedPreview request.
+type GeneratedPreview struct {
+	URI       string `json:"uri"`
+	Stack     string `json:"stack,omitempty"`
+	Condition bool   `json:"condition"`
+	Content   string `json:"content"`
+	Error     string `json:"error,omitempty"`
+}
+
+// GeneratedPreview sends a terramate/generatedPreview request to the language
+// server for the generate block at the file position and returns its result.
+// All the stacks inheriting the block are previewed if stack is empty.
+func (e *Editor) GeneratedPreview(path string, pos lsp.Position, stack string) ([]GeneratedPreview, error) {
+	var previews []GeneratedPreview
+	_, err := e.call("terramate/generatedPreview", map[string]interface{}{
+		"textDocument": lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		"position": pos,
+		"stack":    stack,
+	}, &previews)
+	return previews, err
+}
+
+// RunOrder is the result of the terramate/runOrder request.
+type RunOrder struct {
+	Order   []string `json:"order"`
+	Cycle   []string `json:"cycle,omitempty"`
+	Dot     string   `json:"dot"`
+	Mermaid string   `json:"mermaid"`
+}
+
+// RunOrder sends a terramate/runOrder request to the language server for the
+// stacks of the given directory, or of the whole project if it is empty, and
+// returns its result.
+func (e *Editor) RunOrder(dir string) RunOrder {
+	t := e.t
+	t.Helper()
+	params := map[string]interface{}{}
+	if dir != "" {
+		params["directory"] = uri.File(filepath.Join(e.sandbox.RootDir(), dir))
+	}
+	var order RunOrder
+	_, err := e.call("terramate/runOrder", params, &order)
+	assert.NoError(t, err, "call %q", "terramate/runOrder")
+	return order
+}
+
+// ChangedStacks is the result of the terramate/changedStacks request.
+type ChangedStacks struct {
+	BaseRef string         `json:"baseRef"`
+	Stacks  []ChangedStack `json:"stacks"`
+}
+
+// ChangedStack is a stack changed in the git branch.
+type ChangedStack struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// ChangedStacks sends a terramate/changedStacks request to the language server
+// for the stacks of the given directory, or of the whole project if it is
+// empty, and returns its result.
+func (e *Editor) ChangedStacks(dir string) ChangedStacks {
+	t := e.t
+	t.Helper()
+	params := map[string]interface{}{}
+	if dir != "" {
+		params["directory"] = uri.File(filepath.Join(e.sandbox.RootDir(), dir))
+	}
+	var changed ChangedStacks
+	_, err := e.call("terramate/changedStacks", params, &changed)
+	assert.NoError(t, err, "call %q", "terramate/changedStacks")
+	return changed
+}
+
+// Diagnostics waits for the diagnostics published by the language server for
+// the given file and returns them. The diagnostics published for other files
+// are discarded.
+func (e *Editor) Diagnostics(path string) []lsp.Diagnostic {
+	t := e.t
+	t.Helper()
+	abspath := filepath.Join(e.sandbox.RootDir(), path)
+	for {
+		select {
+		case r := <-e.Requests:
+			if r.Method() != lsp.MethodTextDocumentPublishDiagnostics {
+				continue
+			}
+			var params lsp.PublishDiagnosticsParams
+			assert.NoError(t, json.Unmarshal(r.Params(), &params), "unmarshaling diagnostics")
+			if params.URI.Filename() == abspath {
+				return params.Diagnostics
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no diagnostics published for %s", path)
+		}
+	}
+}
+
+// FoldingRanges sends a foldingRange request to the language server for the
+// given file and returns its result.
+func (e *Editor) FoldingRanges(path string) []lsp.FoldingRange {
+	t := e.t
+	t.Helper()
+	var ranges []lsp.FoldingRange
+	_, err := e.call(lsp.MethodTextDocumentFoldingRange, lsp.FoldingRangeParams{
+		TextDocumentPositionParams: lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{
+				URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+			},
+		},
+	}, &ranges)
+	assert.NoError(t, err, "call %q", lsp.MethodTextDocumentFoldingRange)
+	return ranges
+}
+
+// SelectionRanges sends a selectionRange request to the language server for
+// the given file positions and returns its result.
+func (e *Editor) SelectionRanges(path string, positions ...lsp.Position) []lsp.SelectionRange {
+	t := e.t
+	t.Helper()
+	var ranges []lsp.SelectionRange
+	_, err := e.call("textDocument/selectionRange", lsp.SelectionRangeParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		Positions: positions,
+	}, &ranges)
+	assert.NoError(t, err, "call %q", "textDocument/selectionRange")
+	return ranges
+}
+
+// SemanticTokens sends a semanticTokens/full request to the language server
+// for the given file and returns its result.
+func (e *Editor) SemanticTokens(path string) *lsp.SemanticTokens {
+	t := e.t
+	t.Helper()
+	var tokens *lsp.SemanticTokens
+	_, err := e.call(lsp.MethodSemanticTokensFull, lsp.SemanticTokensParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+	}, &tokens)
+	assert.NoError(t, err, "call %q", lsp.MethodSemanticTokensFull)
+	return tokens
+}
+
+// SemanticTokensRange sends a semanticTokens/range request to the language
+// server for the given file range and returns its result.
+func (e *Editor) SemanticTokensRange(path string, rng lsp.Range) *lsp.SemanticTokens {
+	t := e.t
+	t.Helper()
+	var tokens *lsp.SemanticTokens
+	_, err := e.call(lsp.MethodSemanticTokensRange, lsp.SemanticTokensRangeParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		Range: rng,
+	}, &tokens)
+	assert.NoError(t, err, "call %q", lsp.MethodSemanticTokensRange)
+	return tokens
+}
+
+func (e *Editor) positionParams(path string, pos lsp.Position) lsp.TextDocumentPositionParams {
+	return lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri.File(filepath.Join(e.sandbox.RootDir(), path)),
+		},
+		Position: pos,
+	}
+}
+
+// InitializeResult is the result of the initialize request, including the
+// server capabilities missing in the protocol package.
+type InitializeResult struct {
+	Capabilities ServerCapabilities `json:"capabilities"`
+}
+
+// ServerCapabilities are the server capabilities of the protocol package and
+// the ones of newer versions of the protocol.
+type ServerCapabilities struct {
+	lsp.ServerCapabilities
+
+	InlayHintProvider bool `json:"inlayHintProvider,omitempty"`
+}
+
 // DefaultInitializeResult is the default server response for the initialization
 // request.
-func DefaultInitializeResult() lsp.InitializeResult {
-	return lsp.InitializeResult{
-		Capabilities: lsp.ServerCapabilities{
-			CompletionProvider: &lsp.CompletionOptions{},
-			DefinitionProvider: false,
-			HoverProvider:      false,
-			TextDocumentSync: map[string]interface{}{
-				"change":    float64(1),
-				"openClose": true,
-				"save":      map[string]interface{}{},
+func DefaultInitializeResult() InitializeResult {
+	return InitializeResult{
+		Capabilities: ServerCapabilities{
+			InlayHintProvider: true,
+			ServerCapabilities: lsp.ServerCapabilities{
+				CodeActionProvider: map[string]interface{}{
+					"codeActionKinds": []interface{}{
+						"quickfix",
+						"refactor.extract",
+						"refactor.inline",
+						"refactor.rewrite",
+					},
+				},
+				CodeLensProvider:                &lsp.CodeLensOptions{},
+				CompletionProvider:              &lsp.CompletionOptions{},
+				DefinitionProvider:              true,
+				DocumentFormattingProvider:      true,
+				DocumentRangeFormattingProvider: true,
+				DocumentSymbolProvider:          true,
+				DocumentLinkProvider:            &lsp.DocumentLinkOptions{},
+				ExecuteCommandProvider: &lsp.ExecuteCommandOptions{
+					Commands: []string{
+						"terramate.showDependencies",
+						"terramate.generate",
+						"terramate.createStack",
+					},
+				},
+				FoldingRangeProvider:    true,
+				HoverProvider:           true,
+				ReferencesProvider:      true,
+				SelectionRangeProvider:  true,
+				WorkspaceSymbolProvider: true,
+				RenameProvider: map[string]interface{}{
+					"prepareProvider": true,
+				},
+				SemanticTokensProvider: map[string]interface{}{
+					"legend": map[string]interface{}{
+						"tokenTypes": []interface{}{
+							"keyword", "type", "property", "variable", "namespace",
+							"function", "string", "number", "operator", "comment",
+						},
+						"tokenModifiers": []interface{}{
+							"declaration", "readonly", "deprecated", "defaultLibrary",
+						},
+					},
+					"range": true,
+					"full":  true,
+				},
+				Workspace: &lsp.ServerCapabilitiesWorkspace{
+					WorkspaceFolders: &lsp.ServerCapabilitiesWorkspaceFolders{
+						Supported:           true,
+						ChangeNotifications: true,
+					},
+					FileOperations: &lsp.ServerCapabilitiesWorkspaceFileOperations{
+						WillRename: fileOperationOptions(),
+						DidRename:  fileOperationOptions(),
+					},
+				},
+				TextDocumentSync: map[string]interface{}{
+					"change":    float64(1),
+					"openClose": true,
+					"save":      map[string]interface{}{},
+				},
 			},
 		},
 	}
@@ -158,3 +787,16 @@ func DefaultInitializeResult() lsp.InitializeResult {
 func init() {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 }
+
+func fileOperationOptions() *lsp.FileOperationRegistrationOptions {
+	return &lsp.FileOperationRegistrationOptions{
+		Filters: []lsp.FileOperationFilter{
+			{
+				Scheme: "file",
+				Pattern: lsp.FileOperationPattern{
+					Glob: "**",
+				},
+			},
+		},
+	}
+}
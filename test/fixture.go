@@ -25,6 +25,10 @@ import (
 	"go.lsp.dev/jsonrpc2"
 )
 
+// RootConfig is the sandbox layout entry of a Terramate root configuration,
+// which makes the sandbox directory the project root.
+const RootConfig = "f:terramate.tm:terramate {\n config {\n }\n}"
+
 // Fixture is the default test fixture.
 type Fixture struct {
 	Sandbox sandbox.S
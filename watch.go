@@ -0,0 +1,125 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+
+	"github.com/mineiros-io/terramate/config"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+	"go.lsp.dev/uri"
+)
+
+// fileWatchersID is the ID of the registration of the file watchers.
+const fileWatchersID = "terramate-ls/fileWatchers"
+
+// gitignoreFilename is the name of the files with the paths ignored by git.
+const gitignoreFilename = ".gitignore"
+
+// fileWatchers returns the watchers of the files changing the configuration of
+// the projects.
+func fileWatchers() []lsp.FileSystemWatcher {
+	return []lsp.FileSystemWatcher{
+		{GlobPattern: "**/*.tm"},
+		{GlobPattern: "**/*.tm.hcl"},
+		{GlobPattern: "**/" + gitignoreFilename},
+		{GlobPattern: "**/" + config.SkipFilename},
+		{GlobPattern: "**/" + projectConfigFilename},
+	}
+}
+
+// registerFileWatchers asks the editor to notify the changes of the watched
+// files made outside of it, eg.: by `git checkout` or `terramate generate`.
+//
+// It must not be called from a request handler, as it waits for the editor.
+func (s *Server) registerFileWatchers(ctx context.Context) {
+	_, err := s.conn.Call(ctx, lsp.MethodClientRegisterCapability, lsp.RegistrationParams{
+		Registrations: []lsp.Registration{
+			{
+				ID:     fileWatchersID,
+				Method: lsp.MethodWorkspaceDidChangeWatchedFiles,
+				RegisterOptions: lsp.DidChangeWatchedFilesRegistrationOptions{
+					Watchers: fileWatchers(),
+				},
+			},
+		},
+	}, nil)
+	if err != nil {
+		s.log.Error().Err(err).Msg("failed to register the file watchers")
+	}
+}
+
+func (s *Server) handleDidChangeWatchedFiles(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.DidChangeWatchedFilesParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+
+	reindex := false
+	for _, change := range params.Changes {
+		fname := change.URI.Filename()
+		switch name := filepath.Base(fname); {
+		case name == gitignoreFilename || name == config.SkipFilename || name == projectConfigFilename:
+			// the ignored files and directories changed. The project
+			// configuration is loaded again when it is used.
+			reindex = true
+		case isTerramateFile(name):
+			s.reindexFile(fname)
+			if _, opened := s.documents.get(fname); change.Type == lsp.FileChangeTypeDeleted && !opened {
+				s.sendDiagnostics(ctx, lsp.URI(uri.File(fname)), []lsp.Diagnostic{})
+			}
+		}
+	}
+	if reindex {
+		s.dropIndexes()
+	}
+
+	s.republishDiagnostics(ctx)
+	return reply(ctx, nil, nil)
+}
+
+// republishDiagnostics checks the directories of the opened files again and
+// publishes their diagnostics, which may have changed with the files changed
+// outside of the editor.
+func (s *Server) republishDiagnostics(ctx context.Context) {
+	c := s.newCheck()
+	checked := map[string]bool{}
+	var dirs []string
+	for _, fname := range s.documents.list() {
+		dir := filepath.Dir(fname)
+		if checked[dir] {
+			continue
+		}
+		checked[dir] = true
+
+		content, ok := s.documents.get(fname)
+		if !ok {
+			continue
+		}
+		_ = s.checkDir(ctx, c, fname, content)
+		dirs = append(dirs, dir)
+	}
+	s.recheckCycles(ctx, c, dirs)
+}
@@ -0,0 +1,133 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/madlambda/spells/assert"
+	"github.com/mineiros-io/terramate-ls/test"
+	lsp "go.lsp.dev/protocol"
+)
+
+func TestWatchedFilesRegistration(t *testing.T) {
+	f := test.Setup(t)
+	f.Editor.InitializeWith(f.Sandbox.RootDir(), lsp.ClientCapabilities{
+		Workspace: &lsp.WorkspaceClientCapabilities{
+			DidChangeWatchedFiles: &lsp.DidChangeWatchedFilesWorkspaceClientCapabilities{
+				DynamicRegistration: true,
+			},
+		},
+	})
+	assert.EqualStrings(t, lsp.MethodWindowShowMessage, (<-f.Editor.Requests).Method())
+	f.Editor.Initialized()
+
+	r := <-f.Editor.Requests
+	assert.EqualStrings(t, lsp.MethodClientRegisterCapability, r.Method())
+	var params struct {
+		Registrations []struct {
+			Method          string `json:"method"`
+			RegisterOptions struct {
+				Watchers []struct {
+					GlobPattern string `json:"globPattern"`
+				} `json:"watchers"`
+			} `json:"registerOptions"`
+		} `json:"registrations"`
+	}
+	assert.NoError(t, json.Unmarshal(r.Params(), &params))
+	if len(params.Registrations) != 1 {
+		t.Fatalf("want 1 registration, got %+v", params.Registrations)
+	}
+	registration := params.Registrations[0]
+	assert.EqualStrings(t, lsp.MethodWorkspaceDidChangeWatchedFiles, registration.Method)
+
+	var got []string
+	for _, watcher := range registration.RegisterOptions.Watchers {
+		got = append(got, watcher.GlobPattern)
+	}
+	want := []string{"**/*.tm", "**/*.tm.hcl", "**/.gitignore", "**/.tmskip", "**/.terramate-ls.hcl"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatalf("watchers mismatch, want(-) got(+):\n%s", diff)
+	}
+
+	// editors not supporting the registration have no watchers.
+	f = test.Setup(t)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+	f.Editor.Initialized()
+	drainRequests(f.Editor)
+}
+
+func TestWatchedFilesChanges(t *testing.T) {
+	f := test.Setup(t,
+		"f:a/stack.tm:stack {\n  after = [\"/b\"]\n}\n",
+		"f:b/stack.tm:stack {\n  after = [\"/a\"]\n}\n",
+		"f:c/stack.tm:stack {}\n",
+		"f:c/globals.tm:globals {\n",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	f.Editor.Open("a/stack.tm")
+	if diags := f.Editor.Diagnostics("a/stack.tm"); len(diags) != 1 ||
+		!strings.HasPrefix(diags[0].Message, "run order cycle") {
+		t.Fatalf("want the run order cycle, got %+v", diags)
+	}
+
+	// the cycle is fixed outside of the editor, eg.: by a git checkout.
+	writeFile(t, f.Sandbox.RootDir(), "b/stack.tm", "stack {}\n")
+	f.Editor.ChangeWatchedFiles(lsp.FileChangeTypeChanged, "b/stack.tm")
+	if diags := f.Editor.Diagnostics("a/stack.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics, got %+v", diags)
+	}
+
+	// the diagnostics of deleted files are cleared.
+	f.Editor.Open("c/stack.tm")
+	if diags := f.Editor.Diagnostics("c/globals.tm"); len(diags) == 0 {
+		t.Fatal("want the syntax error of the globals")
+	}
+	assert.NoError(t, os.Remove(filepath.Join(f.Sandbox.RootDir(), "c/globals.tm")))
+	f.Editor.ChangeWatchedFiles(lsp.FileChangeTypeDeleted, "c/globals.tm")
+	if diags := f.Editor.Diagnostics("c/globals.tm"); len(diags) != 0 {
+		t.Fatalf("want no diagnostics, got %+v", diags)
+	}
+	drainRequests(f.Editor)
+
+	// the created files are indexed and the skipped ones are not.
+	writeFile(t, f.Sandbox.RootDir(), "d/stack.tm", "stack {\n  name = \"created\"\n}\n")
+	f.Editor.ChangeWatchedFiles(lsp.FileChangeTypeCreated, "d/stack.tm")
+	if symbols := f.Editor.WorkspaceSymbols("created"); len(symbols) != 1 {
+		t.Fatalf("want the created stack, got %+v", symbols)
+	}
+
+	writeFile(t, f.Sandbox.RootDir(), "d/.tmskip", "")
+	f.Editor.ChangeWatchedFiles(lsp.FileChangeTypeCreated, "d/.tmskip")
+	if symbols := f.Editor.WorkspaceSymbols("created"); len(symbols) != 0 {
+		t.Fatalf("want no symbols of skipped directories, got %+v", symbols)
+	}
+	drainRequests(f.Editor)
+}
+
+// writeFile writes the file at the path relative to rootdir, as it is changed
+// outside of the editor.
+func writeFile(t *testing.T, rootdir, path, content string) {
+	t.Helper()
+	abspath := filepath.Join(rootdir, path)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(abspath), 0700))
+	assert.NoError(t, os.WriteFile(abspath, []byte(content), 0600))
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/mineiros-io/terramate/project"
+	"github.com/rs/zerolog"
+	"go.lsp.dev/jsonrpc2"
+	lsp "go.lsp.dev/protocol"
+)
+
+// maxWorkspaceSymbols is the maximum number of symbols returned by a single
+// workspace/symbol request. Editors refine the query while the user types, so
+// returning everything on big repositories is just wasted time.
+const maxWorkspaceSymbols = 500
+
+func (s *Server) handleWorkspaceSymbol(
+	ctx context.Context,
+	reply jsonrpc2.Replier,
+	r jsonrpc2.Request,
+	log zerolog.Logger,
+) error {
+	var params lsp.WorkspaceSymbolParams
+	if err := json.Unmarshal(r.Params(), &params); err != nil {
+		log.Error().Err(err).Msg("failed to unmarshal params")
+		return jsonrpc2.ErrParse
+	}
+	return reply(ctx, s.workspaceSymbols(params.Query), nil)
+}
+
+// workspaceSymbols returns the symbols of the projects of all the workspace
+// folders matching the query.
+// The query matches, ignoring case, any part of:
+//   - the name, id or path of stacks.
+//   - the name of globals, eg.: global.a.b. A symbol is returned for each
+//     definition of the global.
+//   - the label of generate_hcl and generate_file blocks.
+func (s *Server) workspaceSymbols(query string) []lsp.SymbolInformation {
+	query = strings.ToLower(query)
+	matches := func(values ...string) bool {
+		for _, value := range values {
+			if strings.Contains(strings.ToLower(value), query) {
+				return true
+			}
+		}
+		return false
+	}
+
+	symbols := []lsp.SymbolInformation{}
+	add := func(sym lsp.SymbolInformation) bool {
+		symbols = append(symbols, sym)
+		return len(symbols) < maxWorkspaceSymbols
+	}
+
+	indexed := map[string]bool{}
+	for _, folder := range s.workspaceFolders() {
+		rootdir := s.projectRoot(folder)
+		if indexed[rootdir] {
+			continue
+		}
+		indexed[rootdir] = true
+		s.index(rootdir).forEach(func(fname string, file *fileSymbols) {
+			if len(symbols) >= maxWorkspaceSymbols {
+				return
+			}
+
+			dir := project.PrjAbsPath(rootdir, filepath.Dir(fname)).String()
+			for _, stack := range file.stacks {
+				name := stack.name
+				if name == "" {
+					name = filepath.Base(filepath.Dir(fname))
+				}
+				if !matches(name, stack.id, dir) {
+					continue
+				}
+				if !add(lsp.SymbolInformation{
+					Name:          name,
+					Kind:          lsp.SymbolKindModule,
+					Location:      lsp.Location{URI: fileURI(fname), Range: lspRange(stack.rng)},
+					ContainerName: dir,
+				}) {
+					return
+				}
+			}
+
+			for _, global := range file.globalDefs {
+				name := globalDefinition{path: global.path}.name()
+				if !matches(name) {
+					continue
+				}
+				if !add(lsp.SymbolInformation{
+					Name:          name,
+					Kind:          lsp.SymbolKindVariable,
+					Location:      lsp.Location{URI: fileURI(fname), Range: lspRange(global.nameRange)},
+					ContainerName: dir,
+				}) {
+					return
+				}
+			}
+
+			for _, gen := range file.generates {
+				if !matches(gen.label) {
+					continue
+				}
+				if !add(lsp.SymbolInformation{
+					Name:          gen.label,
+					Kind:          lsp.SymbolKindFile,
+					Location:      lsp.Location{URI: fileURI(fname), Range: lspRange(gen.rng)},
+					ContainerName: dir,
+				}) {
+					return
+				}
+			}
+		})
+	}
+	return symbols
+}
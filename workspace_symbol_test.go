@@ -0,0 +1,107 @@
+// Copyright 2022 Mineiros GmbH
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tmls_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mineiros-io/terramate-ls/test"
+)
+
+func TestWorkspaceSymbols(t *testing.T) {
+	f := test.Setup(t,
+		test.RootConfig,
+		"f:globals.tm:globals {\n  region = \"us-east-1\"\n}",
+		"f:stacks/prod/network/stack.tm:stack {\n  name = \"network\"\n  id = \"net-1\"\n}\ngenerate_hcl \"backend.tf\" {\n  content {\n  }\n}",
+		"f:stacks/prod/db/stack.tm:stack {}",
+		"f:stacks/dev/app/stack.tm:stack {}",
+		"f:stacks/dev/globals.tm:globals {\n  region = \"eu-west-1\"\n}",
+	)
+	f.Editor.CheckInitialize(f.Sandbox.RootDir())
+
+	symbols := func(query string) []string {
+		var got []string
+		for _, sym := range f.Editor.WorkspaceSymbols(query) {
+			file, err := filepath.Rel(f.Sandbox.RootDir(), sym.Location.URI.Filename())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, fmt.Sprintf("%s kind=%d in %s at %s:%d",
+				sym.Name, int(sym.Kind), sym.ContainerName,
+				filepath.ToSlash(file), sym.Location.Range.Start.Line))
+		}
+		return got
+	}
+
+	for _, tc := range []struct {
+		query string
+		want  []string
+	}{
+		{
+			query: "network",
+			want:  []string{"network kind=2 in /stacks/prod/network at stacks/prod/network/stack.tm:0"},
+		},
+		{
+			query: "net-1",
+			want:  []string{"network kind=2 in /stacks/prod/network at stacks/prod/network/stack.tm:0"},
+		},
+		{
+			query: "/stacks/dev",
+			want:  []string{"app kind=2 in /stacks/dev/app at stacks/dev/app/stack.tm:0"},
+		},
+		{
+			query: "global.region",
+			want: []string{
+				"global.region kind=13 in / at globals.tm:1",
+				"global.region kind=13 in /stacks/dev at stacks/dev/globals.tm:1",
+			},
+		},
+		{
+			query: "backend",
+			want:  []string{"backend.tf kind=1 in /stacks/prod/network at stacks/prod/network/stack.tm:4"},
+		},
+		{
+			query: "nothing matches",
+		},
+	} {
+		if diff := cmp.Diff(tc.want, symbols(tc.query)); diff != "" {
+			t.Errorf("query %q mismatch, want(-) got(+):\n%s", tc.query, diff)
+		}
+	}
+
+	f.Editor.Change("stacks/dev/app/stack.tm", "stack {\n  name = \"frontend\"\n}")
+	drainRequests(f.Editor)
+
+	want := []string{"frontend kind=2 in /stacks/dev/app at stacks/dev/app/stack.tm:0"}
+	if diff := cmp.Diff(want, symbols("front")); diff != "" {
+		t.Fatalf("changed stack mismatch, want(-) got(+):\n%s", diff)
+	}
+}
+
+// drainRequests discards the requests sent by the server to the editor, like
+// the diagnostics published after a change.
+func drainRequests(e *test.Editor) {
+	for {
+		select {
+		case <-e.Requests:
+		case <-time.After(50 * time.Millisecond):
+			return
+		}
+	}
+}